@@ -0,0 +1,50 @@
+package gozulipbot
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strings"
+)
+
+// gravatarURL is the format used to build an avatar url from a gravatar hash.
+const gravatarURL = "https://secure.gravatar.com/avatar/%s?d=identicon"
+
+// AvatarURLResolved returns the user's avatar as an absolute url.
+// Relative avatar urls are resolved against site, e.g. "https://example.zulipchat.com".
+// If the server left the avatar empty (as it does with client_gravatar),
+// a gravatar url is constructed from the user's email instead.
+func (u User) AvatarURLResolved(site string) string {
+	return resolveAvatarURL(u.AvatarURL, site, "", u.Email)
+}
+
+// AvatarURLResolved returns the sender's avatar as an absolute url.
+// Relative avatar urls are resolved against site. If the server left the
+// avatar empty, a gravatar url is constructed from GravatarHash, or from the
+// sender's email if there is no hash.
+func (e EventMessage) AvatarURLResolved(site string) string {
+	return resolveAvatarURL(e.AvatarURL, site, e.GravatarHash, e.SenderEmail)
+}
+
+// resolveAvatarURL turns a possibly relative or missing avatar url into an
+// absolute one.
+func resolveAvatarURL(avatar, site, hash, email string) string {
+	if avatar == "" {
+		if hash == "" {
+			if email == "" {
+				return ""
+			}
+			hash = fmt.Sprintf("%x", md5.Sum([]byte(strings.ToLower(strings.TrimSpace(email)))))
+		}
+		return fmt.Sprintf(gravatarURL, hash)
+	}
+
+	if strings.HasPrefix(avatar, "http://") || strings.HasPrefix(avatar, "https://") {
+		return avatar
+	}
+	// protocol relative urls
+	if strings.HasPrefix(avatar, "//") {
+		return "https:" + avatar
+	}
+
+	return strings.TrimRight(site, "/") + "/" + strings.TrimLeft(avatar, "/")
+}
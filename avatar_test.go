@@ -0,0 +1,34 @@
+package gozulipbot
+
+import "testing"
+
+func TestAvatarURLResolved(t *testing.T) {
+	site := "https://example.zulipchat.com/"
+	type C struct {
+		E        EventMessage
+		Expected string
+	}
+	cases := map[string]C{
+		"absolute": C{E: EventMessage{AvatarURL: "https://cdn.example.com/a.png"},
+			Expected: "https://cdn.example.com/a.png"},
+		"relative": C{E: EventMessage{AvatarURL: "/user_avatars/2/a.png?x=1"},
+			Expected: "https://example.zulipchat.com/user_avatars/2/a.png?x=1"},
+		"hash": C{E: EventMessage{GravatarHash: "abc123"},
+			Expected: "https://secure.gravatar.com/avatar/abc123?d=identicon"},
+		"email": C{E: EventMessage{SenderEmail: " Test@Example.com"},
+			Expected: "https://secure.gravatar.com/avatar/55502f40dc8b7c769880b10874abc9d0?d=identicon"},
+		"nothing": C{E: EventMessage{}, Expected: ""},
+	}
+
+	for name, c := range cases {
+		got := c.E.AvatarURLResolved(site)
+		if got != c.Expected {
+			t.Errorf("got %q, expected %q, case %q", got, c.Expected, name)
+		}
+	}
+
+	u := User{Email: "test@example.com"}
+	if got := u.AvatarURLResolved(site); got != "https://secure.gravatar.com/avatar/55502f40dc8b7c769880b10874abc9d0?d=identicon" {
+		t.Errorf("got %q for user with no avatar", got)
+	}
+}
@@ -1,11 +1,18 @@
 package gozulipbot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Bot struct {
@@ -14,125 +21,207 @@ type Bot struct {
 	Queues  []*Queue
 	Streams []string
 	Client  Doer
+
+	// APIURL is the base url of the Zulip api, such as
+	// "https://myrealm.example.com/api/v1/". If it is empty, Init sets it to
+	// DefaultAPIURL.
+	APIURL string
+
+	// MaxContentLength is the longest message content, in characters, the
+	// bot will post. If it is 0, DefaultMaxContentLength is used.
+	MaxContentLength int
+
+	// RegisterTimeout bounds how long registering a queue may take,
+	// including fetching its initial state, which can be slow on large realms.
+	// It is separate from how long any other request may take. If it is 0,
+	// DefaultRegisterTimeout is used. Any timeout set on Client still applies.
+	RegisterTimeout time.Duration
+
+	// AllowedSenders limits the messages the bot receives from its queues to
+	// those sent by the given users, listed by email or user id. Messages
+	// from anyone else are dropped. If it is empty, all messages are received.
+	AllowedSenders []string
+
+	// SkipSelf drops the messages the bot sent itself from its queues, so a
+	// bot that replies to every message doesn't reply to its own. Messages
+	// are matched by the bot's email, its user id once GetProfile has been
+	// called, and the ids of the messages it recently sent, which still match
+	// when the realm hides the bot's email from other users.
+	SkipSelf bool
+
+	// PinEmoji is the reaction SendAndPin uses to mark a message as pinned.
+	// If it is empty, DefaultPinEmoji is used.
+	PinEmoji string
+
+	// OnRequest, if set, is called after every request the bot makes, with
+	// the request's endpoint and method, how long it took, and the response's
+	// status code, or the error if there was no response. Numeric ids in the
+	// endpoint are replaced with ":id", such as "messages/:id/reactions".
+	OnRequest func(endpoint string, method string, duration time.Duration, status int, err error)
+
+	// OnQueueRecovered, if set, is called when a queue that the server
+	// expired has been registered again while polling, with the expired
+	// queue's id. The queue has the new id, and starts from its new
+	// LastEventID.
+	OnQueueRecovered func(oldID string, q *Queue)
+
+	// StallTimeout is how long a polling loop, such as OnMessage, waits for
+	// a queue to receive an event before deciding its connection is wedged.
+	// Zulip sends a heartbeat event about once a minute when there are no
+	// others, so a healthy queue never waits that long. A stalled request is
+	// abandoned, OnStall is called, and polling reconnects. If StallTimeout is
+	// 0, DefaultStallTimeout is used when OnStall is set; otherwise stalls
+	// aren't watched for.
+	StallTimeout time.Duration
+
+	// OnStall, if set, is called when a polling loop finds a queue stalled,
+	// with how long it has been since the queue's last event, such as to
+	// alert an operator. It is called again each StallTimeout the queue stays
+	// stalled.
+	OnStall func(q *Queue, since time.Duration)
+
+	// SendQueueSize is the number of messages Enqueue will hold before
+	// returning ErrSendQueueFull. If it is 0, DefaultSendQueueSize is used.
+	SendQueueSize int
+
+	// Retry configures retrying requests that Zulip rate limits. By default,
+	// requests are sent once, and a rate limited response is returned as is.
+	Retry RetryConfig
+
+	// RateLimiter, if set, limits how often the bot sends requests. Each
+	// request, including each retry, waits for the limiter before it is sent.
+	RateLimiter *RateLimiter
+
+	// QueueStore, if set, saves the bot's queues as they are polled, so a
+	// restarted bot resumes them, and receives the events it missed while
+	// it was down. With a QueueStore, polling loops and Stop leave their
+	// queues on the server for the bot to resume, rather than deleting them.
+	QueueStore QueueStore
+
+	// PresenceInterval, if set, is how often the bot reports itself as
+	// active while any of its polling loops, such as OnMessage, runs, so
+	// users see it as online. A minute keeps it shown as active.
+	PresenceInterval time.Duration
+
+	// Logger, if set, receives structured logs of what the bot does: queue
+	// registration and polling, retries, dropped messages and failures to
+	// send. If it is nil, the bot only logs panics it recovers from handlers,
+	// with the log package.
+	Logger *slog.Logger
+
+	// Metrics, if set, receives counts of the events the bot receives, the
+	// messages it sends, errors and retries, and the durations of its
+	// requests.
+	Metrics Metrics
+
+	mu         sync.Mutex
+	sendQueue  *sendQueue
+	commands   *Router
+	middleware []Middleware
+	loops      map[*loop]bool
+	userAgent  string
+
+	// stopPresence stops reporting the bot's presence, while it is.
+	stopPresence context.CancelFunc
+
+	// userID is the bot's user id, once GetProfile has found it, and sent
+	// holds the ids of recently sent messages; both are used by SkipSelf.
+	// fullName is the bot's name, found with userID.
+	userID   int
+	fullName string
+	sent     sentIDs
+
+	// subscribed holds the streams the bot has subscribed to itself, so
+	// subscribing to them again makes no request.
+	subscribed map[string]bool
+
+	// storeKeys holds the QueueStore keys of the saved queues the bot has
+	// open, so two loops with the same options don't poll one queue.
+	storeKeys map[string]bool
+
+	// featureLevel is the server's feature level, once featureLevelKnown.
+	featureLevel      int
+	featureLevelKnown bool
 }
 
 type Doer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-// Init adds an http client to an existing bot struct.
-func (b *Bot) Init() *Bot {
-	b.Client = &http.Client{}
-	return b
-}
+// A DoerFunc is a function that is a Doer.
+type DoerFunc func(*http.Request) (*http.Response, error)
 
-// GetStreamList gets the raw http response when requesting all public streams.
-func (b *Bot) GetStreamList() (*http.Response, error) {
-	req, err := b.constructRequest("GET", "streams", "")
-	if err != nil {
-		return nil, err
-	}
-
-	return b.Client.Do(req)
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
 
-type StreamJSON struct {
-	Msg     string `json:msg`
-	Streams []struct {
-		StreamID    int    `json:stream_id`
-		InviteOnly  bool   `json:invite_only`
-		Description string `json:description`
-		Name        string `json:name`
-	} `json:streams`
-	Result string `json:result`
+// A Middleware wraps the Doer that sends the bot's requests, such as to log
+// them or add tracing headers. It calls next to send the request onwards.
+type Middleware func(next Doer) Doer
+
+// Use adds middleware that wraps every request the bot sends, including each
+// retry. Middleware added first is outermost, so it sees requests first and
+// responses last. The bot's Client is the innermost Doer.
+func (b *Bot) Use(mw ...Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.middleware = append(b.middleware, mw...)
 }
 
-// GetStreams returns a list of all public streams
-func (b *Bot) GetStreams() ([]string, error) {
-	resp, err := b.GetStreamList()
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var sj StreamJSON
-	err = json.Unmarshal(body, &sj)
-	if err != nil {
-		return nil, err
-	}
+// client returns the bot's Client, wrapped in its middleware.
+func (b *Bot) client() Doer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
-	var streams []string
-	for _, s := range sj.Streams {
-		streams = append(streams, s.Name)
+	d := b.Client
+	for i := len(b.middleware) - 1; i >= 0; i-- {
+		d = b.middleware[i](d)
 	}
-
-	return streams, nil
+	return d
 }
 
-// Subscribe will set the bot to receive messages from the given streams.
-// If no streams are given, it will subscribe the bot to the streams in the bot struct.
-func (b *Bot) Subscribe(streams []string) (*http.Response, error) {
-	if streams == nil {
-		streams = b.Streams
+// Init adds an http client, configured by the options, to an existing bot
+// struct, and checks the bot's APIURL, setting it to DefaultAPIURL if it is
+// empty.
+func (b *Bot) Init(opts ...Option) (*Bot, error) {
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
-
-	var toSubStreams []map[string]string
-	for _, name := range streams {
-		toSubStreams = append(toSubStreams, map[string]string{"name": name})
-	}
-
-	bodyBts, err := json.Marshal(toSubStreams)
+	client, err := o.newClient()
 	if err != nil {
-		return nil, err
+		return b, err
 	}
+	b.Client = client
+	b.userAgent = o.userAgent
 
-	body := "subscriptions=" + string(bodyBts)
-
-	req, err := b.constructRequest("POST", "users/me/subscriptions", body)
-	if err != nil {
-		return nil, err
+	if b.APIURL == "" {
+		b.APIURL = DefaultAPIURL
 	}
-
-	return b.Client.Do(req)
-}
-
-// Unsubscribe will remove the bot from the given streams.
-// If no streams are given, nothing will happen and the function will error.
-func (b *Bot) Unsubscribe(streams []string) (*http.Response, error) {
-	if len(streams) == 0 {
-		return nil, fmt.Errorf("No streams were provided")
-	}
-
-	body := `delete=["` + strings.Join(streams, `","`) + `"]`
-
-	req, err := b.constructRequest("PATCH", "users/me/subscriptions", body)
+	u, err := url.Parse(b.APIURL)
 	if err != nil {
-		return nil, err
+		return b, err
 	}
-
-	return b.Client.Do(req)
-}
-
-func (b *Bot) ListSubscriptions() (*http.Response, error) {
-	req, err := b.constructRequest("GET", "users/me/subscriptions", "")
-	if err != nil {
-		return nil, err
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return b, fmt.Errorf("api url %q must be an absolute http or https url", b.APIURL)
+	}
+	if !strings.HasSuffix(b.APIURL, "/") {
+		b.APIURL += "/"
 	}
 
-	return b.Client.Do(req)
+	return b, nil
 }
 
 type EventType string
 
 const (
-	Messages      EventType = "messages"
-	Subscriptions EventType = "subscriptions"
+	Messages      EventType = "message"
+	Subscriptions EventType = "subscription"
 	RealmUser     EventType = "realm_user"
 	Pointer       EventType = "pointer"
+	Reactions     EventType = "reaction"
+	Presence      EventType = "presence"
+	Typing        EventType = "typing"
 )
 
 type Narrow string
@@ -142,31 +231,95 @@ const (
 	NarrowAt      Narrow = `[["is", "mentioned"]]`
 )
 
+// NewNarrow returns a narrow matching the messages that match every term, such
+// as NewNarrow(NarrowTerm{"stream", "ops"}, NarrowTerm{"topic", "alerts"}).
+func NewNarrow(terms ...NarrowTerm) Narrow {
+	pairs := make([][2]string, len(terms))
+	for i, t := range terms {
+		pairs[i] = [2]string{t.Operator, t.Operand}
+	}
+	// marshalling strings can't fail
+	bts, _ := json.Marshal(pairs)
+	return Narrow(bts)
+}
+
+// RegisterOptions configures a queue registered with RegisterEventsWithOptions.
+type RegisterOptions struct {
+	// EventTypes are the types of event the queue receives. If it is
+	// empty, the queue receives messages.
+	EventTypes []EventType
+
+	// Narrow limits the messages the queue receives, such as to one stream.
+	Narrow Narrow
+
+	// AllPublicStreams includes messages from every public stream, not only
+	// the ones the bot is subscribed to.
+	AllPublicStreams bool
+
+	// ApplyMarkdown has message content sent as rendered HTML, rather than
+	// the markdown it was written in.
+	ApplyMarkdown bool
+
+	// ClientGravatar leaves the avatar urls of users using gravatar empty,
+	// to be computed by the client, such as with AvatarURLResolved.
+	ClientGravatar bool
+}
+
 // RegisterEvents adds a queue to the bot. It includes the EventTypes and
 // Narrow given. If neither is given, it will default to all Messages.
 func (b *Bot) RegisterEvents(ets []EventType, n Narrow) (*Queue, error) {
-	resp, err := b.RawRegisterEvents(ets, n)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return b.RegisterEventsCtx(context.Background(), ets, n)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// RegisterEventsCtx is RegisterEvents, with a context that can cancel the
+// registration.
+func (b *Bot) RegisterEventsCtx(ctx context.Context, ets []EventType, n Narrow) (*Queue, error) {
+	return b.RegisterEventsWithOptions(ctx, RegisterOptions{EventTypes: ets, Narrow: n})
+}
 
-	q := &Queue{Bot: b}
-	err = json.Unmarshal(body, q)
-	if err != nil {
-		return nil, err
+// RegisterEventsWithOptions adds a queue to the bot, configured by opts.
+// Filtering with a narrow happens on the server, so the bot only receives
+// the messages it needs.
+//
+// If the bot has a QueueStore with a queue saved for the same options, that
+// queue is resumed instead of registering a new one. While the saved queue is
+// open, further queues with the same options are registered without being
+// saved.
+func (b *Bot) RegisterEventsWithOptions(ctx context.Context, opts RegisterOptions) (*Queue, error) {
+	return b.registerQueue(ctx, opts, b.QueueStore != nil)
+}
+
+// registerQueue adds a queue to the bot, configured by opts. If stored is
+// set, the queue is resumed from, and saved in, the bot's QueueStore.
+func (b *Bot) registerQueue(ctx context.Context, opts RegisterOptions, stored bool) (*Queue, error) {
+	q := &Queue{Bot: b, opts: opts}
+	if stored {
+		key, err := b.queueKey(opts)
+		if err != nil {
+			return nil, err
+		}
+		// a saved queue another loop has open is left to it, and this
+		// queue is registered without being saved
+		if b.claimStoreKey(key) {
+			if resumed := b.resumeQueue(key, opts); resumed != nil {
+				q = resumed
+			} else {
+				q.storeKey = key
+			}
+		}
 	}
 
-	if q.LastEventID < q.MaxMessageID {
-		q.LastEventID = q.MaxMessageID
+	if q.ID == "" {
+		err := q.register(ctx)
+		if err != nil {
+			b.releaseStoreKey(q)
+			return nil, err
+		}
 	}
 
+	b.mu.Lock()
 	b.Queues = append(b.Queues, q)
+	b.mu.Unlock()
 
 	return q, nil
 }
@@ -191,41 +344,222 @@ func (b *Bot) RegisterChannels() (*Queue, error) {
 // RawRegisterEvents tells Zulip to include message events in the bots events queue.
 // Passing nil as the slice of EventType will default to receiving Messages
 func (b *Bot) RawRegisterEvents(ets []EventType, n Narrow) (*http.Response, error) {
-	// default to Messages if no EventTypes given
-	query := `event_types=["message"]`
-
-	if len(ets) != 0 {
-		query = `event_types=["`
-		for i, s := range ets {
-			query += fmt.Sprintf("%s", s)
-			if i != len(ets)-1 {
-				query += `", "`
-			}
+	return b.RawRegisterEventsCtx(context.Background(), ets, n)
+}
+
+// RawRegisterEventsCtx is RawRegisterEvents, with a context that can cancel
+// the request. The bot's RegisterTimeout still applies.
+func (b *Bot) RawRegisterEventsCtx(ctx context.Context, ets []EventType, n Narrow) (*http.Response, error) {
+	return b.rawRegister(ctx, RegisterOptions{EventTypes: ets, Narrow: n})
+}
+
+// rawRegister sends a request registering a queue configured by opts.
+func (b *Bot) rawRegister(ctx context.Context, opts RegisterOptions) (*http.Response, error) {
+	req, err := b.constructRegisterRequest(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doRegister(req.WithContext(ctx))
+}
+
+// DefaultRegisterTimeout is how long registering a queue may take when the
+// bot's RegisterTimeout is unset.
+const DefaultRegisterTimeout = 2 * time.Minute
+
+// doRegister sends a register request, limited by the bot's RegisterTimeout.
+// The timeout covers reading the response body as well.
+func (b *Bot) doRegister(req *http.Request) (*http.Response, error) {
+	timeout := b.RegisterTimeout
+	if timeout <= 0 {
+		timeout = DefaultRegisterTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := b.do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels a request's context once its response body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelBody) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// constructRegisterRequest makes the request to register a queue configured
+// by opts.
+func (b *Bot) constructRegisterRequest(opts RegisterOptions) (*http.Request, error) {
+	values, err := registerValues(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.constructRequest("POST", "register", values.Encode())
+}
+
+// registerValues returns the form values registering a queue with opts.
+func registerValues(opts RegisterOptions) (url.Values, error) {
+	values := url.Values{}
+
+	// default to Messages if no EventTypes given, and leave out
+	// event_types to register for every type
+	ets := opts.EventTypes
+	if len(ets) == 0 {
+		ets = []EventType{Messages}
+	}
+	all := false
+	for _, et := range ets {
+		if et == AllEvents {
+			all = true
 		}
-		query += `"]`
+	}
+	if !all {
+		bts, err := json.Marshal(ets)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("event_types", string(bts))
 	}
 
-	if n != "" {
-		query += fmt.Sprintf("&narrow=%s", n)
+	if opts.Narrow != "" {
+		values.Set("narrow", string(opts.Narrow))
+	}
+	if opts.AllPublicStreams {
+		values.Set("all_public_streams", "true")
+	}
+	if opts.ApplyMarkdown {
+		values.Set("apply_markdown", "true")
 	}
+	if opts.ClientGravatar {
+		values.Set("client_gravatar", "true")
+	}
+
+	return values, nil
+}
 
-	req, err := b.constructRequest("POST", "register", query)
+// do sends a request with the bot's client. Every request the bot makes goes
+// through do.
+func (b *Bot) do(req *http.Request) (*http.Response, error) {
+	if b.Retry.MaxAttempts > 1 {
+		return b.doWithRetry(req)
+	}
+	return b.doOnce(req)
+}
+
+// doOnce sends a request a single time.
+func (b *Bot) doOnce(req *http.Request) (*http.Response, error) {
+	if b.RateLimiter != nil {
+		if err := b.RateLimiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := b.client().Do(req)
+	if err != nil {
+		// make sure cancellation can be told apart from other failures,
+		// whatever the client returns
+		if ctxErr := req.Context().Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+			err = fmt.Errorf("%v: %w", err, ctxErr)
+		}
+	}
+
+	b.recordResponse(req, resp, time.Since(start))
+	if b.OnRequest != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		b.OnRequest(b.requestEndpoint(req), req.Method, time.Since(start), status, err)
+	}
+
+	return resp, err
+}
+
+// requestEndpoint returns the api endpoint of a request, without its query,
+// and with numeric ids replaced by ":id".
+func (b *Bot) requestEndpoint(req *http.Request) string {
+	path := req.URL.Path
+	if base, err := url.Parse(b.apiURL()); err == nil {
+		path = strings.TrimPrefix(path, base.Path)
+	}
+	path = strings.Trim(path, "/")
+
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if _, err := strconv.Atoi(p); err == nil {
+			parts[i] = ":id"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// doJSON sends a request and unmarshals the response body into v.
+// If Zulip responds with an error result, the error's message is returned.
+func (b *Bot) doJSON(req *http.Request, v interface{}) error {
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(resp, v)
+}
+
+// doChecked sends a request, and returns a *ZulipError along with the
+// response if Zulip responds with an error. The response body can still be
+// read by the caller.
+func (b *Bot) doChecked(req *http.Request) (*http.Response, error) {
+	resp, err := b.do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	return b.Client.Do(req)
+	return resp, parseResponse(resp)
 }
 
 // constructRequest makes a zulip request and ensures the proper headers are set.
 func (b *Bot) constructRequest(method, endpoint, body string) (*http.Request, error) {
-	url := "https://api.zulip.com/v1/" + endpoint
-	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	return b.newRequest(method, endpoint, strings.NewReader(body), "application/x-www-form-urlencoded")
+}
+
+// DefaultAPIURL is the base url of Zulip's public api.
+const DefaultAPIURL = "https://api.zulip.com/v1/"
+
+// apiURL returns the bot's APIURL, or DefaultAPIURL if it isn't set.
+func (b *Bot) apiURL() string {
+	if b.APIURL == "" {
+		return DefaultAPIURL
+	}
+	if !strings.HasSuffix(b.APIURL, "/") {
+		return b.APIURL + "/"
+	}
+	return b.APIURL
+}
+
+// newRequest makes an authenticated zulip request with the given body and content type.
+func (b *Bot) newRequest(method, endpoint string, body io.Reader, contentType string) (*http.Request, error) {
+	url := b.apiURL() + endpoint
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
+	if b.userAgent != "" {
+		req.Header.Set("User-Agent", b.userAgent)
+	}
 	req.SetBasicAuth(b.Email, b.APIKey)
 
 	return req, nil
@@ -1,17 +1,72 @@
 package gozulipbot
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBot_Init(t *testing.T) {
 	bot := Bot{}
-	bot.Init()
+	_, err := bot.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if bot.Client == nil {
 		t.Error("expected bot to have client")
 	}
+	if bot.APIURL != DefaultAPIURL {
+		t.Errorf("got %q, expected the default api url", bot.APIURL)
+	}
+}
+
+func TestAPIURL(t *testing.T) {
+	type C struct {
+		APIURL   string
+		Path     string
+		Endpoint string
+		Err      bool
+	}
+
+	cases := map[string]C{
+		"default":     C{APIURL: "", Path: "/v1/messages/5", Endpoint: "messages/:id"},
+		"self-hosted": C{APIURL: "https://myrealm.example.com/api/v1", Path: "/api/v1/messages/5", Endpoint: "messages/:id"},
+		"relative":    C{APIURL: "myrealm.example.com/api/v1/", Err: true},
+		"malformed":   C{APIURL: "https://my realm.example.com:port/", Err: true},
+	}
+
+	for k, c := range cases {
+		bot := &Bot{APIURL: c.APIURL}
+		_, err := bot.Init()
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+
+		var endpoint string
+		tc := &testClient{}
+		bot.Client = tc
+		bot.OnRequest = func(e, _ string, _ time.Duration, _ int, _ error) { endpoint = e }
+		bot.EditMessage(5, "hi")
+
+		if tc.Request.URL.Path != c.Path {
+			t.Errorf("got %q, expected %q, case %q", tc.Request.URL.Path, c.Path, k)
+		}
+		if endpoint != c.Endpoint {
+			t.Errorf("got endpoint %q, expected %q, case %q", endpoint, c.Endpoint, k)
+		}
+	}
 }
 
 func getTestBot() *Bot {
@@ -23,12 +78,198 @@ func getTestBot() *Bot {
 	}
 }
 
+// testClient records requests. It responds with each of Responses in turn,
+// and then with Response once they run out.
 type testClient struct {
-	Request  *http.Request
-	Response *http.Response
+	Request   *http.Request
+	Requests  []*http.Request
+	Response  *http.Response
+	Responses []*http.Response
 }
 
 func (t *testClient) Do(r *http.Request) (*http.Response, error) {
+	// read the body like a real client would, so streamed bodies complete
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
 	t.Request = r
+	t.Requests = append(t.Requests, r)
+	if len(t.Responses) > 0 {
+		resp := t.Responses[0]
+		t.Responses = t.Responses[1:]
+		return resp, nil
+	}
 	return t.Response, nil
 }
+
+// jsonResponse makes a response with the given status code and json body.
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// getTestBotWithResponses returns a test bot whose client responds with the
+// given json bodies, in order, with a 200 status.
+func getTestBotWithResponses(bodies ...string) *Bot {
+	bot := getTestBot()
+	tc := bot.Client.(*testClient)
+	for _, b := range bodies {
+		tc.Responses = append(tc.Responses, jsonResponse(200, b))
+	}
+	return bot
+}
+
+func TestRegisterTimeout(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","queue_id":"1","last_event_id":-1}`)
+	bot.RegisterTimeout = time.Minute
+
+	before := time.Now()
+	_, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		t.Fatal("expected the register request to have a deadline")
+	}
+	if d := deadline.Sub(before); d < 59*time.Second || d > 61*time.Second {
+		t.Errorf("got a deadline %v away, expected about a minute", d)
+	}
+	// the deadline is released once the response has been read
+	if req.Context().Err() == nil {
+		t.Error("expected the register context to be done after reading the response")
+	}
+}
+
+func TestOnRequest(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`, `{"result":"success","msg":""}`)
+	type call struct {
+		Endpoint, Method string
+		Status           int
+	}
+	var calls []call
+	bot.OnRequest = func(endpoint, method string, d time.Duration, status int, err error) {
+		calls = append(calls, call{endpoint, method, status})
+	}
+
+	bot.AddReaction(12, "eyes")
+	(&Queue{ID: "q", Bot: bot}).GetEvents()
+
+	expected := []call{
+		{"messages/:id/reactions", "POST", 200},
+		{"events", "GET", 200},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got %v, expected %v", calls, expected)
+	}
+}
+
+func TestRegisterEventsWithOptions(t *testing.T) {
+	type C struct {
+		Opts     RegisterOptions
+		Expected map[string]string
+	}
+
+	cases := map[string]C{
+		"default": C{
+			Opts:     RegisterOptions{},
+			Expected: map[string]string{"event_types": `["message"]`},
+		},
+		"stream and topic": C{
+			Opts: RegisterOptions{
+				Narrow: NewNarrow(NarrowTerm{"stream", "ops"}, NarrowTerm{"topic", "alerts"}),
+			},
+			Expected: map[string]string{
+				"event_types": `["message"]`,
+				"narrow":      `[["stream","ops"],["topic","alerts"]]`,
+			},
+		},
+		"flags": C{
+			Opts: RegisterOptions{
+				EventTypes:       []EventType{Messages, Reactions},
+				Narrow:           NewNarrow(NarrowTerm{"sender", "boss@example.com"}),
+				AllPublicStreams: true,
+				ApplyMarkdown:    true,
+				ClientGravatar:   true,
+			},
+			Expected: map[string]string{
+				"event_types":        `["message","reaction"]`,
+				"narrow":             `[["sender","boss@example.com"]]`,
+				"all_public_streams": "true",
+				"apply_markdown":     "true",
+				"client_gravatar":    "true",
+			},
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBotWithResponses(
+			`{"result":"success","msg":"","queue_id":"1","last_event_id":-1}`,
+			`{"result":"error","msg":"Bad event queue id: 1","code":"BAD_EVENT_QUEUE_ID"}`,
+			`{"result":"success","msg":"","queue_id":"2","last_event_id":-1}`,
+			`{"result":"success","msg":"","events":[]}`,
+		)
+		q, err := bot.RegisterEventsWithOptions(context.Background(), c.Opts)
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+		q.reregistered = time.Now().Add(-reregisterInterval)
+		if _, err := q.GetEvents(); err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+
+		tc := bot.Client.(*testClient)
+		// the queue registered again when it expired, with the same options
+		for _, i := range []int{0, 2} {
+			req := tc.Requests[i]
+			req.ParseForm()
+			if len(req.PostForm) != len(c.Expected) {
+				t.Errorf("got %v, expected %v, case %q", req.PostForm, c.Expected, k)
+			}
+			for key, v := range c.Expected {
+				if got := req.PostForm.Get(key); got != v {
+					t.Errorf("got %s %q, expected %q, case %q", key, got, v, k)
+				}
+			}
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`)
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+" before")
+				req.Header.Add("X-Trace", name)
+				resp, err := next.Do(req)
+				order = append(order, name+" after")
+				return resp, err
+			})
+		}
+	}
+	bot.Use(trace("outer"), trace("inner"))
+
+	if _, err := bot.MarkAsRead([]int{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"outer before", "inner before", "inner after", "outer after"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("got %q, expected %q", order, expected)
+	}
+	req := bot.Client.(*testClient).Request
+	if got := req.Header["X-Trace"]; !reflect.DeepEqual(got, []string{"outer", "inner"}) {
+		t.Errorf("got headers %q, expected the client to get the request from the middleware", got)
+	}
+}
@@ -0,0 +1,90 @@
+package gozulipbot
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// catchupBatchSize is how many messages are fetched at a time while catching up.
+const catchupBatchSize = 100
+
+// RunWithCatchup calls handler with every message sent after the message with
+// id sinceID, first catching up on history, and then receiving messages live
+// as they are sent. It runs until the context is done or handler returns an
+// error, and returns that error.
+//
+// The queue for live messages is registered before catching up. Its initial
+// max_message_id is the boundary between the two: every message up to and
+// including it is read from history, and every message after it arrives live.
+// Each message after sinceID is handled exactly once, in order, with no gap
+// between history and the live messages.
+//
+// Temporary failures while polling are retried on the same queue. If the
+// queue is lost, an error is returned, since messages may have been missed.
+func (b *Bot) RunWithCatchup(ctx context.Context, sinceID int, handler func(EventMessage) error) error {
+	ctx, finish := b.startLoop(ctx)
+	defer finish()
+
+	// the queue's max_message_id must be the boundary with the history, so
+	// it is never resumed from the QueueStore
+	q, err := b.registerQueue(ctx, RegisterOptions{}, false)
+	if err != nil {
+		return err
+	}
+	// a new queue would leave a gap after the history
+	q.noReregister = true
+	defer b.closeQueue(context.Background(), q)
+
+	err = b.catchup(ctx, q, sinceID, handler)
+	if err != nil {
+		return err
+	}
+
+	return b.pollQueue(ctx, q, handler)
+}
+
+// catchup calls handler with the messages after sinceID, up to the queue's MaxMessageID.
+func (b *Bot) catchup(ctx context.Context, q *Queue, sinceID int, handler func(EventMessage) error) error {
+	anchor := sinceID
+	for anchor < q.MaxMessageID {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		values := url.Values{}
+		values.Set("anchor", strconv.Itoa(anchor))
+		values.Set("include_anchor", "false")
+		values.Set("num_before", "0")
+		values.Set("num_after", strconv.Itoa(catchupBatchSize))
+
+		mr, err := b.getMessages(values)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range mr.Messages {
+			if m.ID <= anchor {
+				// servers without include_anchor return the anchor too
+				continue
+			}
+			if m.ID > q.MaxMessageID {
+				// the queue delivers this one
+				return nil
+			}
+			anchor = m.ID
+			m.Queue = q
+			if !b.receives(m) {
+				continue
+			}
+			if err := handler(m); err != nil {
+				return err
+			}
+		}
+
+		if mr.FoundNewest || len(mr.Messages) == 0 {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunWithCatchup(t *testing.T) {
+	bot := getTestBotWithResponses(
+		// register
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1,"max_message_id":12}`,
+		// history, which already includes a message the queue will deliver
+		`{"result":"success","msg":"","found_newest":true,"messages":[{"id":11},{"id":12},{"id":13}]}`,
+		// live events
+		`{"result":"success","msg":"","events":[{"id":0,"type":"heartbeat"}]}`,
+		`{"result":"success","msg":"","events":[{"id":1,"type":"message","message":{"id":13}}]}`,
+		// deleting the queue
+		`{"result":"success","msg":""}`,
+	)
+
+	stop := errors.New("stop")
+	var handled []int
+	err := bot.RunWithCatchup(context.Background(), 10, func(m EventMessage) error {
+		handled = append(handled, m.ID)
+		if m.ID == 13 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("got %v, expected the handler's error", err)
+	}
+
+	if !reflect.DeepEqual(handled, []int{11, 12, 13}) {
+		t.Errorf("got %v, expected each message once, in order", handled)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	history := reqs[1].URL.Query()
+	if history.Get("anchor") != "10" || history.Get("include_anchor") != "false" {
+		t.Errorf("got history query %v", history)
+	}
+	// the poll after the heartbeat continues from its event id
+	if id := reqs[3].URL.Query().Get("last_event_id"); id != "0" {
+		t.Errorf("got last_event_id %q after the heartbeat, expected 0", id)
+	}
+	if reqs[len(reqs)-1].Method != "DELETE" {
+		t.Error("expected the queue to be deleted")
+	}
+}
+
+func TestRunWithCatchupRetries(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1,"max_message_id":0}`,
+	)
+	tc := bot.Client.(*testClient)
+	tc.Responses = append(tc.Responses,
+		jsonResponse(500, `<html>Internal Server Error</html>`),
+		rateLimited(),
+		jsonResponse(200, `{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`),
+	)
+	tc.Response = jsonResponse(200, `{"result":"success","msg":""}`)
+	old := pollRetryDelay
+	pollRetryDelay = time.Millisecond
+	defer func() { pollRetryDelay = old }()
+
+	stop := errors.New("stop")
+	err := bot.RunWithCatchup(context.Background(), 0, func(m EventMessage) error {
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("got %v, expected temporary failures to be retried on the same queue", err)
+	}
+	for _, req := range tc.Requests[1:4] {
+		if id := req.URL.Query().Get("queue_id"); id != "q1" {
+			t.Errorf("got queue %q, expected q1", id)
+		}
+	}
+}
+
+func TestRunWithCatchupLostQueue(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1,"max_message_id":0}`,
+		`{"result":"error","msg":"Bad event queue id: q1","code":"BAD_EVENT_QUEUE_ID","queue_id":"q1"}`,
+		`{"result":"success","msg":""}`,
+	)
+
+	err := bot.RunWithCatchup(context.Background(), 0, func(m EventMessage) error { return nil })
+	var ze *ZulipError
+	if !errors.As(err, &ze) || ze.Code != CodeBadEventQueueID {
+		t.Errorf("got %v, expected the lost queue's error", err)
+	}
+}
@@ -0,0 +1,223 @@
+package gozulipbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A CommandHandler runs a command. args are the words following the command name.
+type CommandHandler func(e EventMessage, args []string)
+
+// A Router dispatches messages to command handlers, using the first word of
+// the message as the command name.
+type Router struct {
+	prefix       string
+	handlers     map[string]CommandHandler
+	descriptions map[string]string
+}
+
+// zulipSlashCommands are handled by Zulip itself, and are never routed when
+// the prefix is "/".
+var zulipSlashCommands = map[string]bool{
+	"me":   true,
+	"poll": true,
+	"todo": true,
+}
+
+// NewRouter returns a router with no commands and no prefix.
+func NewRouter() *Router {
+	return &Router{
+		handlers:     map[string]CommandHandler{},
+		descriptions: map[string]string{},
+	}
+}
+
+// Handle registers the handler for the command with the given name.
+// Command names are case insensitive.
+func (r *Router) Handle(name string, h CommandHandler) {
+	r.handlers[strings.ToLower(name)] = h
+}
+
+// Describe sets the description of a command, shown in the router's Help.
+func (r *Router) Describe(name, description string) {
+	r.descriptions[strings.ToLower(name)] = description
+}
+
+// Help lists the router's commands, in alphabetical order, with their
+// descriptions.
+func (r *Router) Help() string {
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n* `%s%s`", r.prefix, name)
+		if d := r.descriptions[name]; d != "" {
+			b.WriteString(": " + d)
+		}
+	}
+	return b.String()
+}
+
+// SetPrefix sets the prefix a message must start with to be treated as a
+// command, such as "!", "/", or the bot's mention "@**Bot Name**".
+// The prefix is removed before the command name is read.
+// Messages without the prefix are ignored.
+func (r *Router) SetPrefix(prefix string) {
+	r.prefix = prefix
+}
+
+// Route runs the handler for the command in the message, and reports whether
+// there was one.
+func (r *Router) Route(e EventMessage) bool {
+	name, args, ok := r.parse(e.Content)
+	if !ok {
+		return false
+	}
+
+	h, ok := r.handlers[name]
+	if !ok {
+		return false
+	}
+
+	h(e, args)
+	return true
+}
+
+// parse splits content into a command name and its arguments.
+func (r *Router) parse(content string) (string, []string, bool) {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, r.prefix) {
+		return "", nil, false
+	}
+
+	fields := splitArgs(strings.TrimPrefix(content, r.prefix))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	name := strings.ToLower(fields[0])
+	if r.prefix == "/" && zulipSlashCommands[name] {
+		return "", nil, false
+	}
+
+	return name, fields[1:], true
+}
+
+// splitArgs splits s into words, separated by whitespace. Words can be quoted
+// with double quotes to include whitespace, like "release notes".
+func splitArgs(s string) []string {
+	args := []string{}
+	var cur strings.Builder
+	inWord, quoted := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// Commands returns the bot's command router, which HandleCommand routes
+// messages with.
+func (b *Bot) Commands() *Router {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.commands == nil {
+		b.commands = NewRouter()
+	}
+	return b.commands
+}
+
+// Command registers the handler for a command on the bot's router.
+func (b *Bot) Command(name string, h CommandHandler) {
+	b.Commands().Handle(name, h)
+}
+
+// HandleCommand runs the command in the message with the bot's router, and
+// reports whether there was one. A mention of the bot at the start of the
+// message is removed first, unless the router's prefix is a mention itself;
+// messages starting with a mention of anyone else are left as they are.
+// Unless the router has a "help" command, "help" responds with the router's
+// Help.
+//
+// It can be used as a handler for OnMessage:
+//
+//	bot.OnMessage(ctx, func(b *gozulipbot.Bot, e gozulipbot.EventMessage) {
+//		b.HandleCommand(e)
+//	})
+func (b *Bot) HandleCommand(e EventMessage) bool {
+	r := b.Commands()
+	if !isMention(r.prefix) {
+		e.Content = b.stripOwnMention(e.Content)
+	}
+	if r.Route(e) {
+		return true
+	}
+
+	name, _, ok := r.parse(e.Content)
+	if !ok || name != "help" {
+		return false
+	}
+	resp, _ := b.Respond(e, r.Help())
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return true
+}
+
+// isMention reports whether s starts with a mention, like "@**Test Bot**".
+func isMention(s string) bool {
+	return strings.HasPrefix(s, "@**") || strings.HasPrefix(s, "@_**")
+}
+
+// stripOwnMention removes a mention of the bot, like "@**Test Bot**" or
+// "@_**Test Bot|12**", from the start of content. A mention of anyone else
+// is left, as is the content if the bot's profile can't be fetched.
+func (b *Bot) stripOwnMention(content string) string {
+	trimmed := strings.TrimSpace(content)
+	for _, start := range []string{"@**", "@_**"} {
+		if !strings.HasPrefix(trimmed, start) {
+			continue
+		}
+		end := strings.Index(trimmed[len(start):], "**")
+		if end < 0 {
+			return content
+		}
+		id, name, err := b.ownUser()
+		if err != nil {
+			b.logger().Warn("checking mention failed", "error", err)
+			return content
+		}
+		mention := trimmed[len(start) : len(start)+end]
+		if i := strings.LastIndex(mention, "|"); i >= 0 {
+			if mention[i+1:] != strconv.Itoa(id) {
+				return content
+			}
+		} else if mention != name {
+			return content
+		}
+		return trimmed[len(start)+end+2:]
+	}
+	return content
+}
@@ -0,0 +1,129 @@
+package gozulipbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRouterPrefix(t *testing.T) {
+	type C struct {
+		Prefix  string
+		Content string
+		Routed  bool
+		Args    []string
+	}
+	cases := map[string]C{
+		"no args":      C{Prefix: "!", Content: "!deploy", Routed: true, Args: []string{}},
+		"args":         C{Prefix: "!", Content: " !deploy prod  now ", Routed: true, Args: []string{"prod", "now"}},
+		"no prefix":    C{Prefix: "!", Content: "deploy prod", Routed: false},
+		"mention":      C{Prefix: "@**Test Bot**", Content: "@**Test Bot** Deploy prod", Routed: true, Args: []string{"prod"}},
+		"slash":        C{Prefix: "/", Content: "/deploy", Routed: true, Args: []string{}},
+		"zulip slash":  C{Prefix: "/", Content: "/me deploys", Routed: false},
+		"unknown":      C{Prefix: "!", Content: "!rollback", Routed: false},
+		"empty":        C{Prefix: "!", Content: "!", Routed: false},
+		"empty prefix": C{Prefix: "", Content: "deploy x", Routed: true, Args: []string{"x"}},
+	}
+
+	for name, c := range cases {
+		var args []string
+		r := NewRouter()
+		r.SetPrefix(c.Prefix)
+		r.Handle("deploy", func(e EventMessage, a []string) { args = a })
+		r.Handle("me", func(e EventMessage, a []string) { args = a })
+
+		routed := r.Route(EventMessage{Content: c.Content})
+		if routed != c.Routed {
+			t.Errorf("got routed %v, expected %v, case %q", routed, c.Routed, name)
+		}
+		if routed && !reflect.DeepEqual(args, c.Args) {
+			t.Errorf("got args %q, expected %q, case %q", args, c.Args, name)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	type C struct {
+		Input    string
+		Expected []string
+	}
+	cases := map[string]C{
+		"empty":  C{Input: "  ", Expected: []string{}},
+		"words":  C{Input: "deploy  prod\tnow", Expected: []string{"deploy", "prod", "now"}},
+		"quoted": C{Input: `note "release notes" done`, Expected: []string{"note", "release notes", "done"}},
+		"inner":  C{Input: `tag name="v1 rc"`, Expected: []string{"tag", "name=v1 rc"}},
+		"blank":  C{Input: `say ""`, Expected: []string{"say", ""}},
+	}
+
+	for k, c := range cases {
+		got := splitArgs(c.Input)
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("got %q, expected %q, case %q", got, c.Expected, k)
+		}
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`)
+	var args []string
+	bot.Command("deploy", func(e EventMessage, a []string) { args = a })
+	bot.Command("status", func(e EventMessage, a []string) {})
+	bot.Commands().Describe("deploy", "deploy an app")
+	bot.userID, bot.fullName = 12, "Test Bot"
+
+	e := EventMessage{Type: "stream", DisplayRecipient: DisplayRecipient{Topic: "ops"}, Subject: "deploys"}
+
+	e.Content = `@**Test Bot** deploy "my app"`
+	if !bot.HandleCommand(e) {
+		t.Error("expected the mentioned command to be routed")
+	}
+	if !reflect.DeepEqual(args, []string{"my app"}) {
+		t.Errorf("got args %q, expected %q", args, []string{"my app"})
+	}
+
+	e.Content = "rollback"
+	if bot.HandleCommand(e) {
+		t.Error("expected an unknown command not to be routed")
+	}
+
+	e.Content = "@_**Test Bot|12** help"
+	if !bot.HandleCommand(e) {
+		t.Fatal("expected help to be handled")
+	}
+	tc := bot.Client.(*testClient)
+	tc.Request.ParseForm()
+	expected := "Commands:\n* `deploy`: deploy an app\n* `status`"
+	if got := tc.Request.PostForm.Get("content"); got != expected {
+		t.Errorf("got help %q, expected %q", got, expected)
+	}
+}
+
+func TestHandleCommandMentions(t *testing.T) {
+	bot := getTestBot()
+	bot.userID, bot.fullName = 12, "Test Bot"
+	ran := false
+	bot.Command("deploy", func(e EventMessage, a []string) { ran = true })
+
+	type C struct {
+		Prefix   string
+		Content  string
+		Expected bool
+	}
+
+	cases := map[string]C{
+		"own mention":            C{Content: "@**Test Bot** deploy prod", Expected: true},
+		"own mention with id":    C{Content: "@**Test Bot|12** deploy prod", Expected: true},
+		"own silent mention":     C{Content: "@_**Test Bot|12** deploy prod", Expected: true},
+		"other user":             C{Content: "@**Alice** deploy prod", Expected: false},
+		"other user with id":     C{Content: "@**Test Bot|13** deploy prod", Expected: false},
+		"mention prefix":         C{Prefix: "@**Test Bot**", Content: "@**Test Bot** deploy prod", Expected: true},
+		"mention prefix missing": C{Prefix: "@**Test Bot**", Content: "deploy prod", Expected: false},
+	}
+
+	for k, c := range cases {
+		ran = false
+		bot.Commands().SetPrefix(c.Prefix)
+		if got := bot.HandleCommand(EventMessage{Content: c.Content}); got != c.Expected || ran != c.Expected {
+			t.Errorf("got %v, expected %v, case %q", got, c.Expected, k)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gozulipbot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadFromZuliprc sets the bot's Email, APIKey, APIURL and Streams from the
+// [api] section of a zuliprc file, like the one Zulip offers to download for
+// each bot:
+//
+//	[api]
+//	email=bot@example.com
+//	key=abcdef
+//	site=https://myrealm.example.com
+//
+// An optional streams key lists the bot's streams, separated by commas.
+// An empty path reads ~/.zuliprc. The email and key are required.
+func (b *Bot) LoadFromZuliprc(path string) error {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".zuliprc")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return fmt.Errorf("%s:%d: expected a key and value", path, n)
+		}
+		if section == "api" {
+			key := strings.ToLower(strings.TrimSpace(line[:i]))
+			values[key] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	err = b.loadConfig(values["email"], values["key"], values["site"], values["streams"])
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadFromEnv sets the bot's Email, APIKey, APIURL and Streams from the
+// ZULIP_EMAIL, ZULIP_API_KEY, ZULIP_SITE and ZULIP_STREAMS environment
+// variables. ZULIP_STREAMS lists the bot's streams, separated by commas.
+// The email and api key are required.
+func (b *Bot) LoadFromEnv() error {
+	return b.loadConfig(os.Getenv("ZULIP_EMAIL"), os.Getenv("ZULIP_API_KEY"),
+		os.Getenv("ZULIP_SITE"), os.Getenv("ZULIP_STREAMS"))
+}
+
+// loadConfig checks and sets the bot's configuration. site is the realm's
+// url, such as "https://myrealm.example.com"; if it is empty, the APIURL is
+// left as it is.
+func (b *Bot) loadConfig(email, key, site, streams string) error {
+	if email == "" {
+		return errors.New("email is required")
+	}
+	if key == "" {
+		return errors.New("api key is required")
+	}
+
+	b.Email = email
+	b.APIKey = key
+	if site != "" {
+		b.APIURL = siteAPIURL(site)
+	}
+	if streams != "" {
+		b.Streams = nil
+		for _, s := range strings.Split(streams, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				b.Streams = append(b.Streams, s)
+			}
+		}
+	}
+	return nil
+}
+
+// siteAPIURL returns the api url of a realm's site. Sites without a scheme
+// use https.
+func siteAPIURL(site string) string {
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	site = strings.TrimRight(site, "/")
+	if !strings.HasSuffix(site, "/api/v1") {
+		site += "/api/v1"
+	}
+	return site + "/"
+}
@@ -0,0 +1,108 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadFromZuliprc(t *testing.T) {
+	type C struct {
+		Contents string
+		Email    string
+		APIKey   string
+		APIURL   string
+		Streams  []string
+		Err      bool
+	}
+
+	cases := map[string]C{
+		"full": C{
+			Contents: "# downloaded from zulip\n[api]\nemail=bot@example.com\nkey = abcdef\nsite=https://myrealm.example.com/\nstreams=ops, alerts\n",
+			Email:    "bot@example.com", APIKey: "abcdef",
+			APIURL:  "https://myrealm.example.com/api/v1/",
+			Streams: []string{"ops", "alerts"},
+		},
+		"no site": C{
+			Contents: "[api]\nemail: bot@example.com\nkey: abcdef\n",
+			Email:    "bot@example.com", APIKey: "abcdef",
+			Streams: []string{"stream a", "test bots"},
+		},
+		"other section": C{
+			Contents: "[other]\nemail=x@example.com\n[api]\nemail=bot@example.com\nkey=abcdef\nsite=chat.example.com\n",
+			Email:    "bot@example.com", APIKey: "abcdef",
+			APIURL:  "https://chat.example.com/api/v1/",
+			Streams: []string{"stream a", "test bots"},
+		},
+		"missing key": C{Contents: "[api]\nemail=bot@example.com\n", Err: true},
+		"malformed":   C{Contents: "[api]\nemail\n", Err: true},
+	}
+
+	dir, err := ioutil.TempDir("", "zuliprc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for k, c := range cases {
+		path := filepath.Join(dir, "zuliprc")
+		if err := ioutil.WriteFile(path, []byte(c.Contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		bot := getTestBot()
+		err := bot.LoadFromZuliprc(path)
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+		if bot.Email != c.Email || bot.APIKey != c.APIKey || bot.APIURL != c.APIURL {
+			t.Errorf("got %q %q %q, expected %q %q %q, case %q",
+				bot.Email, bot.APIKey, bot.APIURL, c.Email, c.APIKey, c.APIURL, k)
+		}
+		if !reflect.DeepEqual(bot.Streams, c.Streams) {
+			t.Errorf("got streams %q, expected %q, case %q", bot.Streams, c.Streams, k)
+		}
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	setenv := func(key, value string) {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+	setenv("ZULIP_EMAIL", "bot@example.com")
+	setenv("ZULIP_API_KEY", "")
+	setenv("ZULIP_SITE", "https://myrealm.example.com")
+	setenv("ZULIP_STREAMS", "ops")
+
+	bot := &Bot{}
+	if err := bot.LoadFromEnv(); err == nil {
+		t.Error("expected an error without an api key")
+	}
+
+	os.Setenv("ZULIP_API_KEY", "abcdef")
+	if err := bot.LoadFromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if bot.Email != "bot@example.com" || bot.APIKey != "abcdef" || bot.APIURL != "https://myrealm.example.com/api/v1/" {
+		t.Errorf("got %q %q %q", bot.Email, bot.APIKey, bot.APIURL)
+	}
+	if !reflect.DeepEqual(bot.Streams, []string{"ops"}) {
+		t.Errorf("got streams %q, expected %q", bot.Streams, []string{"ops"})
+	}
+}
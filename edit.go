@@ -0,0 +1,133 @@
+package gozulipbot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// A PropagateMode selects which messages in a topic an edit applies to.
+type PropagateMode string
+
+const (
+	ChangeOne   PropagateMode = "change_one"
+	ChangeLater PropagateMode = "change_later"
+	ChangeAll   PropagateMode = "change_all"
+)
+
+// EditMessage replaces the content of the message with the given id,
+// such as the ID of an EventMessage the bot sent.
+func (b *Bot) EditMessage(id int, newContent string) (*http.Response, error) {
+	return b.EditMessageTopic(id, newContent, "", "")
+}
+
+// EditMessageTopic replaces the content and topic of the stream message with
+// the given id. Either newContent or newTopic may be empty to leave it as is.
+// When the topic changes, mode selects which messages in the topic are moved
+// along with it. An empty mode changes just the one message.
+func (b *Bot) EditMessageTopic(id int, newContent, newTopic string, mode PropagateMode) (*http.Response, error) {
+	if newContent == "" && newTopic == "" {
+		return nil, errors.New("new content or a new topic is required")
+	}
+
+	values := url.Values{}
+	if newContent != "" {
+		values.Set("content", newContent)
+	}
+	if newTopic != "" {
+		if mode == "" {
+			mode = ChangeOne
+		}
+		values.Set(b.topicParam(), newTopic)
+		values.Set("propagate_mode", string(mode))
+	}
+
+	return b.updateMessage(id, values)
+}
+
+// UpdateMessage replaces the content of the message with the given id.
+// It is the same as EditMessage.
+func (b *Bot) UpdateMessage(id int, newContent string) (*http.Response, error) {
+	if newContent == "" {
+		return nil, errors.New("new content cannot be empty")
+	}
+	return b.EditMessage(id, newContent)
+}
+
+// UpdateMessageTopic moves the stream message with the given id to a new
+// topic, along with the other messages in the topic that mode selects.
+func (b *Bot) UpdateMessageTopic(id int, newTopic string, mode PropagateMode) (*http.Response, error) {
+	if newTopic == "" {
+		return nil, errors.New("new topic cannot be empty")
+	}
+	switch mode {
+	case ChangeOne, ChangeLater, ChangeAll:
+	default:
+		return nil, fmt.Errorf("unknown propagate mode %q", mode)
+	}
+	return b.EditMessageTopic(id, "", newTopic, mode)
+}
+
+// DeleteMessage permanently deletes the message with the given id. Bots can
+// delete their own messages when the realm allows it; see CanDeleteMessage.
+func (b *Bot) DeleteMessage(id int) (*http.Response, error) {
+	req, err := b.constructRequest("DELETE", fmt.Sprintf("messages/%d", id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// A Move is the destination of moved messages. Leaving StreamID or Topic
+// unset keeps the messages' current stream or topic.
+//
+// Zulip posts a notification about the move in both the old and the new
+// topic, unless NoNotifyOldThread or NoNotifyNewThread are set.
+type Move struct {
+	StreamID          int
+	Topic             string
+	NoNotifyOldThread bool
+	NoNotifyNewThread bool
+}
+
+// MoveMessage moves the message with the given id, and the messages selected
+// by mode, to another stream or topic. An empty mode moves just the one message.
+func (b *Bot) MoveMessage(id int, mv Move, mode PropagateMode) (*http.Response, error) {
+	if mv.StreamID == 0 && mv.Topic == "" {
+		return nil, errors.New("a stream or topic to move to is required")
+	}
+	if mode == "" {
+		mode = ChangeOne
+	}
+
+	values := url.Values{}
+	if mv.StreamID != 0 {
+		values.Set("stream_id", strconv.Itoa(mv.StreamID))
+	}
+	if mv.Topic != "" {
+		values.Set(b.topicParam(), mv.Topic)
+	}
+	values.Set("propagate_mode", string(mode))
+	values.Set("send_notification_to_old_thread", strconv.FormatBool(!mv.NoNotifyOldThread))
+	values.Set("send_notification_to_new_thread", strconv.FormatBool(!mv.NoNotifyNewThread))
+
+	return b.updateMessage(id, values)
+}
+
+// MoveTopic moves the whole topic containing the message with the given id.
+func (b *Bot) MoveTopic(id int, mv Move) (*http.Response, error) {
+	return b.MoveMessage(id, mv, ChangeAll)
+}
+
+// updateMessage sends an update for the message with the given id.
+func (b *Bot) updateMessage(id int, values url.Values) (*http.Response, error) {
+	req, err := b.constructRequest("PATCH", fmt.Sprintf("messages/%d", id), values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
@@ -0,0 +1,126 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMoveMessage(t *testing.T) {
+	bot := getTestBot()
+	type C struct {
+		ID   int
+		Move Move
+		Mode PropagateMode
+		Body string
+	}
+	cases := map[string]C{
+		"defaults": C{ID: 1, Move: Move{Topic: "new"},
+			Body: "propagate_mode=change_one&send_notification_to_new_thread=true&send_notification_to_old_thread=true&topic=new"},
+		"quiet": C{ID: 2, Move: Move{StreamID: 5, NoNotifyOldThread: true, NoNotifyNewThread: true}, Mode: ChangeLater,
+			Body: "propagate_mode=change_later&send_notification_to_new_thread=false&send_notification_to_old_thread=false&stream_id=5"},
+	}
+
+	for name, c := range cases {
+		_, err := bot.MoveMessage(c.ID, c.Move, c.Mode)
+		if err != nil {
+			t.Fatalf("got %q, case %q", err, name)
+		}
+		req := bot.Client.(*testClient).Request
+		if req.Method != "PATCH" {
+			t.Errorf("got method %q, case %q", req.Method, name)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != c.Body {
+			t.Errorf("got %q, expected %q, case %q", string(body), c.Body, name)
+		}
+	}
+
+	_, err := bot.MoveTopic(3, Move{Topic: "elsewhere", NoNotifyOldThread: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	if req.URL.Path != "/v1/messages/3" {
+		t.Errorf("got path %q", req.URL.Path)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	expected := "propagate_mode=change_all&send_notification_to_new_thread=true&send_notification_to_old_thread=false&topic=elsewhere"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+
+	if _, err := bot.MoveTopic(3, Move{}); err == nil {
+		t.Error("expected an error for an empty move")
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	bot := getTestBot()
+	type C struct {
+		Content string
+		Topic   string
+		Mode    PropagateMode
+		Body    string
+	}
+	cases := map[string]C{
+		"content":      C{Content: "fixed typo", Body: "content=fixed+typo"},
+		"topic":        C{Topic: "renamed", Mode: ChangeLater, Body: "propagate_mode=change_later&topic=renamed"},
+		"default mode": C{Topic: "renamed", Body: "propagate_mode=change_one&topic=renamed"},
+		"both":         C{Content: "x", Topic: "y", Mode: ChangeAll, Body: "content=x&propagate_mode=change_all&topic=y"},
+	}
+
+	for name, c := range cases {
+		_, err := bot.EditMessageTopic(9, c.Content, c.Topic, c.Mode)
+		if err != nil {
+			t.Fatalf("got %q, case %q", err, name)
+		}
+		req := bot.Client.(*testClient).Request
+		if req.Method != "PATCH" || req.URL.Path != "/v1/messages/9" {
+			t.Errorf("got %s %s, case %q", req.Method, req.URL.Path, name)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != c.Body {
+			t.Errorf("got %q, expected %q, case %q", string(body), c.Body, name)
+		}
+	}
+
+	if _, err := bot.EditMessage(9, ""); err == nil {
+		t.Error("expected an error for an empty edit")
+	}
+}
+
+func TestUpdateMessageTopic(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.UpdateMessageTopic(9, "renamed", "change_all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	if expected := "propagate_mode=change_all&topic=renamed"; string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+
+	if _, err := bot.UpdateMessageTopic(9, "renamed", "change_some"); err == nil {
+		t.Error("expected an error for an unknown propagate mode")
+	}
+	if _, err := bot.UpdateMessageTopic(9, "", ChangeOne); err == nil {
+		t.Error("expected an error for an empty topic")
+	}
+	if _, err := bot.UpdateMessage(9, ""); err == nil {
+		t.Error("expected an error for empty content")
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.DeleteMessage(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	if req.Method != "DELETE" || req.URL.Path != "/v1/messages/9" {
+		t.Errorf("got %s %s", req.Method, req.URL.Path)
+	}
+}
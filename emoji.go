@@ -0,0 +1,68 @@
+package gozulipbot
+
+// unicodeEmoji maps the names of commonly used unicode emoji, as Zulip
+// names them, to their emoji codes.
+var unicodeEmoji = map[string]string{
+	"+1":            "1f44d",
+	"thumbs_up":     "1f44d",
+	"-1":            "1f44e",
+	"thumbs_down":   "1f44e",
+	"100":           "1f4af",
+	"check":         "2705",
+	"check_mark":    "2714",
+	"cross_mark":    "274c",
+	"x":             "274c",
+	"heart":         "2764",
+	"tada":          "1f389",
+	"eyes":          "1f440",
+	"rocket":        "1f680",
+	"fire":          "1f525",
+	"star":          "2b50",
+	"warning":       "26a0",
+	"thinking":      "1f914",
+	"joy":           "1f602",
+	"grinning":      "1f600",
+	"slight_smile":  "1f642",
+	"laughing":      "1f606",
+	"heart_eyes":    "1f60d",
+	"wave":          "1f44b",
+	"clap":          "1f44f",
+	"pray":          "1f64f",
+	"muscle":        "1f4aa",
+	"ok":            "1f197",
+	"question":      "2753",
+	"exclamation":   "2757",
+	"hourglass":     "231b",
+	"stopwatch":     "23f1",
+	"working_on_it": "1f6e0",
+	"push_pin":      "1f4cc",
+	"pin":           "1f4cc",
+	"bulb":          "1f4a1",
+	"bug":           "1f41b",
+	"lock":          "1f512",
+	"unlock":        "1f513",
+	"bell":          "1f514",
+	"no_bell":       "1f515",
+	"octopus":       "1f419",
+	"sparkles":      "2728",
+	"zap":           "26a1",
+	"skull":         "1f480",
+	"sob":           "1f62d",
+	"confused":      "1f615",
+	"cool":          "1f192",
+	"new":           "1f195",
+	"up":            "1f199",
+	"repeat":        "1f501",
+	"stop_sign":     "1f6d1",
+	"construction":  "1f6a7",
+	"calendar":      "1f4c5",
+	"memo":          "1f4dd",
+	"link":          "1f517",
+}
+
+// UnicodeEmojiCode returns the emoji code of the unicode emoji with the given
+// name, if it is in the package's emoji table.
+func UnicodeEmojiCode(name string) (string, bool) {
+	code, ok := unicodeEmoji[name]
+	return code, ok
+}
@@ -0,0 +1,115 @@
+package gozulipbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Codes of the errors Zulip returns, for comparing to a ZulipError's Code.
+const (
+	CodeBadRequest             = "BAD_REQUEST"
+	CodeBadEventQueueID        = "BAD_EVENT_QUEUE_ID"
+	CodeRateLimitHit           = "RATE_LIMIT_HIT"
+	CodeStreamDoesNotExist     = "STREAM_DOES_NOT_EXIST"
+	CodeReactionDoesNotExist   = "REACTION_DOES_NOT_EXIST"
+	CodeUnauthorized           = "UNAUTHORIZED"
+	CodeUserDeactivated        = "USER_DEACTIVATED"
+	CodeRealmDeactivated       = "REALM_DEACTIVATED"
+	CodeInvalidAPIKey          = "INVALID_API_KEY"
+	CodeAuthenticationFailed   = "AUTHENTICATION_FAILED"
+	CodeRequestVariableMissing = "REQUEST_VARIABLE_MISSING"
+	CodeRequestVariableInvalid = "REQUEST_VARIABLE_INVALID"
+	CodeInvalidJSON            = "INVALID_JSON"
+	CodeReactionAlreadyExists  = "REACTION_ALREADY_EXISTS"
+)
+
+// A ZulipError is an error result returned by the Zulip API, such as
+// a STREAM_DOES_NOT_EXIST or RATE_LIMIT_HIT error, which can be found with
+// errors.As:
+//
+//	var ze *gozulipbot.ZulipError
+//	if errors.As(err, &ze) && ze.Code == gozulipbot.CodeRateLimitHit {
+//		time.Sleep(ze.RetryAfter)
+//	}
+//
+// Responses with an error status that aren't error results, such as a proxy's
+// error page, are also a ZulipError, with an empty Code.
+type ZulipError struct {
+	Code       string `json:"code"`
+	Msg        string `json:"msg"`
+	HTTPStatus int    `json:"-"`
+
+	// RetryAfter is how long to wait before retrying a rate limited
+	// request, or 0 if the response didn't say.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *ZulipError) Error() string {
+	return e.Msg
+}
+
+// parseResponse returns a *ZulipError if the response is an error result.
+// The response body is left intact, so it can still be read by the caller.
+func parseResponse(resp *http.Response) error {
+	if resp == nil || resp.Body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	return responseError(resp, body)
+}
+
+// decodeResponse reads and closes the response body, and unmarshals it into v.
+// If the response is an error result, a *ZulipError is returned instead.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = responseError(resp, body)
+	if err != nil {
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// responseError returns a *ZulipError if body, the body of resp, is an error
+// result, or resp has an error status.
+func responseError(resp *http.Response, body []byte) error {
+	var result struct {
+		Result     string   `json:"result"`
+		RetryAfter *float64 `json:"retry-after"`
+		ZulipError
+	}
+	err := json.Unmarshal(body, &result)
+	if err == nil && result.Result == "error" || resp.StatusCode >= 400 {
+		ze := &result.ZulipError
+		ze.HTTPStatus = resp.StatusCode
+		if ze.Msg == "" {
+			ze.Msg = http.StatusText(resp.StatusCode)
+		}
+		if result.RetryAfter != nil && *result.RetryAfter >= 0 {
+			ze.RetryAfter = time.Duration(*result.RetryAfter * float64(time.Second))
+		} else if d, ok := retryAfterHeader(resp.Header); ok {
+			ze.RetryAfter = d
+		}
+		return ze
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gozulipbot
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResponseError(t *testing.T) {
+	type C struct {
+		Status     int
+		Header     string
+		Body       string
+		Err        bool
+		Code       string
+		Msg        string
+		RetryAfter time.Duration
+	}
+
+	cases := map[string]C{
+		"success":  C{Status: 200, Body: `{"result":"success","msg":""}`},
+		"not json": C{Status: 200, Body: `ok`},
+		"error result": C{
+			Status: 400, Body: `{"result":"error","msg":"Stream 'a' does not exist","code":"STREAM_DOES_NOT_EXIST"}`,
+			Err: true, Code: CodeStreamDoesNotExist, Msg: "Stream 'a' does not exist",
+		},
+		"rate limit body": C{
+			Status: 429, Header: "5", Body: `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT","retry-after":1.5}`,
+			Err: true, Code: CodeRateLimitHit, Msg: "API usage exceeded rate limit", RetryAfter: 1500 * time.Millisecond,
+		},
+		"rate limit header": C{
+			Status: 429, Header: "2", Body: `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT"}`,
+			Err: true, Code: CodeRateLimitHit, Msg: "API usage exceeded rate limit", RetryAfter: 2 * time.Second,
+		},
+		"error page": C{
+			Status: 502, Body: `<html>Bad Gateway</html>`,
+			Err: true, Msg: "Bad Gateway",
+		},
+	}
+
+	for k, c := range cases {
+		resp := jsonResponse(c.Status, c.Body)
+		if c.Header != "" {
+			resp.Header.Set("Retry-After", c.Header)
+		}
+
+		err := parseResponse(resp)
+		var ze *ZulipError
+		if !c.Err {
+			if err != nil {
+				t.Errorf("got %q, expected nil, case %q", err, k)
+			}
+			continue
+		}
+		if !errors.As(err, &ze) {
+			t.Errorf("got %v, expected a ZulipError, case %q", err, k)
+			continue
+		}
+		if ze.Code != c.Code || ze.Msg != c.Msg || ze.HTTPStatus != c.Status || ze.RetryAfter != c.RetryAfter {
+			t.Errorf("got %+v, expected %q %q %d %v, case %q", *ze, c.Code, c.Msg, c.Status, c.RetryAfter, k)
+		}
+	}
+}
+
+func TestZulipErrorWrapped(t *testing.T) {
+	bot := getTestBot()
+	bot.Client.(*testClient).Response = jsonResponse(http.StatusBadRequest,
+		`{"result":"error","msg":"Invalid message(s)","code":"BAD_REQUEST"}`)
+
+	_, err := bot.DeleteMessage(4)
+	var ze *ZulipError
+	if !errors.As(err, &ze) || ze.Code != CodeBadRequest {
+		t.Errorf("got %v, expected a %s error", err, CodeBadRequest)
+	}
+}
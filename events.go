@@ -0,0 +1,324 @@
+package gozulipbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// AllEvents registers a queue for every type of event.
+const AllEvents EventType = "*"
+
+// An Event is any event from a queue, such as a new message, a reaction or a
+// heartbeat. Type is Zulip's name for the kind of event, like "reaction", and
+// Op is what happened, like "add", for the kinds of event that have one.
+//
+// Message is set for message events. The payloads of other kinds of event can
+// be decoded with the method for their type, such as Reaction, or from Raw,
+// which holds the whole event.
+type Event struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Op      string          `json:"op"`
+	Message *EventMessage   `json:"-"`
+	Raw     json.RawMessage `json:"-"`
+}
+
+// A ReactionEvent is an emoji reaction added to or removed from a message.
+type ReactionEvent struct {
+	Op           string `json:"op"`
+	UserID       int    `json:"user_id"`
+	MessageID    int    `json:"message_id"`
+	EmojiName    string `json:"emoji_name"`
+	EmojiCode    string `json:"emoji_code"`
+	ReactionType string `json:"reaction_type"`
+}
+
+// A SubscriptionEvent is a change to the bot's subscriptions. For "add" and
+// "remove" events, Subscriptions lists the streams; for "update" events,
+// Property of the stream with StreamID changed to Value.
+type SubscriptionEvent struct {
+	Op            string          `json:"op"`
+	Subscriptions []Stream        `json:"subscriptions"`
+	StreamID      int             `json:"stream_id"`
+	Property      string          `json:"property"`
+	Value         json.RawMessage `json:"value"`
+}
+
+// A PresenceEvent is a change in whether a user is active, with the user's
+// presence on each of their clients.
+type PresenceEvent struct {
+	UserID          int                       `json:"user_id"`
+	Email           string                    `json:"email"`
+	ServerTimestamp float64                   `json:"server_timestamp"`
+	Presence        map[string]ClientPresence `json:"presence"`
+}
+
+// A ClientPresence is a user's presence on one client.
+// Status is "active" or "idle".
+type ClientPresence struct {
+	Client    string `json:"client"`
+	Status    string `json:"status"`
+	Timestamp int    `json:"timestamp"`
+}
+
+// A TypingEvent is a user starting or stopping typing to the bot.
+type TypingEvent struct {
+	Op          string `json:"op"`
+	MessageType string `json:"message_type"`
+	Sender      User   `json:"sender"`
+	Recipients  []User `json:"recipients"`
+	StreamID    int    `json:"stream_id"`
+	Topic       string `json:"topic"`
+}
+
+// Reaction decodes a "reaction" event.
+func (e Event) Reaction() (ReactionEvent, error) {
+	var r ReactionEvent
+	err := e.decode("reaction", &r)
+	return r, err
+}
+
+// Subscription decodes a "subscription" event.
+func (e Event) Subscription() (SubscriptionEvent, error) {
+	var s SubscriptionEvent
+	err := e.decode("subscription", &s)
+	return s, err
+}
+
+// Presence decodes a "presence" event.
+func (e Event) Presence() (PresenceEvent, error) {
+	var p PresenceEvent
+	err := e.decode("presence", &p)
+	return p, err
+}
+
+// Typing decodes a "typing" event.
+func (e Event) Typing() (TypingEvent, error) {
+	var t TypingEvent
+	err := e.decode("typing", &t)
+	return t, err
+}
+
+// decode unmarshals the event into v, if it has the given type.
+func (e Event) decode(eventType string, v interface{}) error {
+	if e.Type != eventType {
+		return fmt.Errorf("event %d is a %q event, not %q", e.ID, e.Type, eventType)
+	}
+	return json.Unmarshal(e.Raw, v)
+}
+
+// ParseEvents parses every event out of a response to a request for events,
+// and advances the queue's LastEventID past them. Messages from senders not
+// in the bot's AllowedSenders, or from the bot itself if SkipSelf is set, are
+// dropped.
+func (q *Queue) ParseEvents(rawEventResponse []byte) ([]Event, error) {
+	var rawResponse struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	err := json.Unmarshal(rawEventResponse, &rawResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	for _, raw := range rawResponse.Events {
+		var e Event
+		err = json.Unmarshal(raw, &e)
+		if err != nil {
+			return nil, err
+		}
+		e.Raw = raw
+		q.advance(e.ID)
+		if q.Bot != nil {
+			q.Bot.metrics().EventReceived(e.Type)
+		}
+
+		if e.Type == "message" {
+			var em struct {
+				Message *EventMessage `json:"message"`
+			}
+			err = json.Unmarshal(raw, &em)
+			if err != nil {
+				return nil, err
+			}
+			if em.Message == nil {
+				continue
+			}
+			em.Message.Queue = q
+			if q.Bot != nil && !q.Bot.receives(*em.Message) {
+				continue
+			}
+			e.Message = em.Message
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// GetAllEvents is a blocking call that waits for and parses the next events
+// on the queue, of every type the queue was registered for, including
+// heartbeats. Like GetEvents, an expired queue is registered again.
+func (q *Queue) GetAllEvents() ([]Event, error) {
+	return q.GetAllEventsCtx(context.Background())
+}
+
+// GetAllEventsCtx is GetAllEvents, with a context that can cancel the request.
+func (q *Queue) GetAllEventsCtx(ctx context.Context) ([]Event, error) {
+	body, err := q.fetchEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.ParseEvents(body)
+}
+
+// A Dispatcher calls handlers for the events from a queue, by type.
+// Events without a handler for their type go to the OnEvent handler, if set.
+type Dispatcher struct {
+	message      func(EventMessage)
+	reaction     func(ReactionEvent)
+	subscription func(SubscriptionEvent)
+	presence     func(PresenceEvent)
+	heartbeat    func(Event)
+	typing       func(TypingEvent)
+	event        func(Event)
+}
+
+// NewDispatcher returns a dispatcher with no handlers.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// OnMessage sets the handler for new messages.
+func (d *Dispatcher) OnMessage(h func(EventMessage)) {
+	d.message = h
+}
+
+// OnReaction sets the handler for reactions being added and removed.
+func (d *Dispatcher) OnReaction(h func(ReactionEvent)) {
+	d.reaction = h
+}
+
+// OnSubscription sets the handler for changes to the bot's subscriptions.
+func (d *Dispatcher) OnSubscription(h func(SubscriptionEvent)) {
+	d.subscription = h
+}
+
+// OnPresence sets the handler for changes in users' presence.
+func (d *Dispatcher) OnPresence(h func(PresenceEvent)) {
+	d.presence = h
+}
+
+// OnHeartbeat sets the handler for heartbeats, which Zulip sends to show the
+// queue is alive when there are no other events.
+func (d *Dispatcher) OnHeartbeat(h func(Event)) {
+	d.heartbeat = h
+}
+
+// OnTyping sets the handler for users starting and stopping typing.
+func (d *Dispatcher) OnTyping(h func(TypingEvent)) {
+	d.typing = h
+}
+
+// OnEvent sets the handler for events without a more specific handler.
+// With an OnEvent handler, RunDispatcher registers for every type of event.
+func (d *Dispatcher) OnEvent(h func(Event)) {
+	d.event = h
+}
+
+// EventTypes returns the types of event the dispatcher has handlers for,
+// for registering a queue.
+func (d *Dispatcher) EventTypes() []EventType {
+	if d.event != nil {
+		return []EventType{AllEvents}
+	}
+
+	var ets []EventType
+	if d.message != nil {
+		ets = append(ets, Messages)
+	}
+	if d.reaction != nil {
+		ets = append(ets, Reactions)
+	}
+	if d.subscription != nil {
+		ets = append(ets, Subscriptions)
+	}
+	if d.presence != nil {
+		ets = append(ets, Presence)
+	}
+	if d.typing != nil {
+		ets = append(ets, Typing)
+	}
+	if len(ets) == 0 {
+		// heartbeats come with any queue
+		ets = append(ets, Messages)
+	}
+	return ets
+}
+
+// Dispatch calls the handler for the event's type, or the OnEvent handler if
+// there isn't one, and reports whether a handler was called. An error is
+// returned if the event can't be decoded.
+func (d *Dispatcher) Dispatch(e Event) (bool, error) {
+	switch {
+	case e.Type == "message" && d.message != nil && e.Message != nil:
+		d.message(*e.Message)
+	case e.Type == "reaction" && d.reaction != nil:
+		r, err := e.Reaction()
+		if err != nil {
+			return false, err
+		}
+		d.reaction(r)
+	case e.Type == "subscription" && d.subscription != nil:
+		s, err := e.Subscription()
+		if err != nil {
+			return false, err
+		}
+		d.subscription(s)
+	case e.Type == "presence" && d.presence != nil:
+		p, err := e.Presence()
+		if err != nil {
+			return false, err
+		}
+		d.presence(p)
+	case e.Type == "heartbeat" && d.heartbeat != nil:
+		d.heartbeat(e)
+	case e.Type == "typing" && d.typing != nil:
+		t, err := e.Typing()
+		if err != nil {
+			return false, err
+		}
+		d.typing(t)
+	case d.event != nil:
+		d.event(e)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// RunDispatcher registers a queue for the dispatcher's event types, and
+// dispatches each event the bot receives until the context is done. Events
+// that can't be decoded are skipped.
+//
+// Like OnMessage, failures are retried, an expired queue is replaced, and the
+// queue is deleted when RunDispatcher returns. An error registering the first
+// queue is returned immediately; otherwise the context's error is returned.
+func (b *Bot) RunDispatcher(ctx context.Context, d *Dispatcher) error {
+	ctx, finish := b.startLoop(ctx)
+	defer finish()
+
+	q, err := b.RegisterEventsCtx(ctx, d.EventTypes(), "")
+	if err != nil {
+		return err
+	}
+	defer b.closeQueue(context.Background(), q)
+
+	return b.pollAllEvents(ctx, q, func(e Event) error {
+		d.Dispatch(e)
+		return nil
+	})
+}
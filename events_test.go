@@ -0,0 +1,148 @@
+package gozulipbot
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+const mixedEvents = `{"result":"success","msg":"","events":[
+	{"id":0,"type":"message","message":{"id":10,"content":"hi"}},
+	{"id":1,"type":"reaction","op":"add","user_id":5,"message_id":10,"emoji_name":"tada","emoji_code":"1f389","reaction_type":"unicode_emoji"},
+	{"id":2,"type":"subscription","op":"add","subscriptions":[{"stream_id":3,"name":"general"}]},
+	{"id":3,"type":"presence","user_id":5,"email":"a@example.com","server_timestamp":1700000000.5,
+	 "presence":{"website":{"client":"website","status":"active","timestamp":1700000000}}},
+	{"id":4,"type":"heartbeat"},
+	{"id":5,"type":"typing","op":"start","message_type":"private","sender":{"user_id":5,"email":"a@example.com"},
+	 "recipients":[{"user_id":1,"email":"testbot@example.com"}]},
+	{"id":6,"type":"update_message_flags","op":"add","flag":"read","messages":[10]}
+]}`
+
+func TestParseEvents(t *testing.T) {
+	q := &Queue{}
+	events, err := q.ParseEvents([]byte(mixedEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	expected := []string{"message", "reaction", "subscription", "presence", "heartbeat", "typing", "update_message_flags"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("got %q, expected %q", types, expected)
+	}
+	if q.LastEventID != 6 {
+		t.Errorf("got last event id %d, expected 6", q.LastEventID)
+	}
+	if m := events[0].Message; m == nil || m.ID != 10 || m.Queue != q {
+		t.Errorf("got message %+v", m)
+	}
+
+	r, err := events[1].Reaction()
+	if err != nil || r.Op != "add" || r.MessageID != 10 || r.EmojiName != "tada" {
+		t.Errorf("got %+v, %v", r, err)
+	}
+	s, err := events[2].Subscription()
+	if err != nil || len(s.Subscriptions) != 1 || s.Subscriptions[0].Name != "general" {
+		t.Errorf("got %+v, %v", s, err)
+	}
+	p, err := events[3].Presence()
+	if err != nil || p.UserID != 5 || p.Presence["website"].Status != "active" {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	ty, err := events[5].Typing()
+	if err != nil || ty.Sender.ID != 5 || len(ty.Recipients) != 1 || ty.Recipients[0].ID != 1 {
+		t.Errorf("got %+v, %v", ty, err)
+	}
+
+	if _, err := events[1].Typing(); err == nil {
+		t.Error("expected an error decoding a reaction as typing")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	q := &Queue{}
+	events, err := q.ParseEvents([]byte(mixedEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	d := NewDispatcher()
+	d.OnMessage(func(e EventMessage) { got = append(got, "message") })
+	d.OnReaction(func(r ReactionEvent) { got = append(got, "reaction "+r.EmojiName) })
+	d.OnSubscription(func(s SubscriptionEvent) { got = append(got, "subscription "+s.Op) })
+	d.OnPresence(func(p PresenceEvent) { got = append(got, "presence "+p.Email) })
+	d.OnHeartbeat(func(e Event) { got = append(got, "heartbeat") })
+	d.OnTyping(func(ty TypingEvent) { got = append(got, "typing "+ty.Op) })
+
+	expectedTypes := []EventType{Messages, Reactions, Subscriptions, Presence, Typing}
+	if ets := d.EventTypes(); !reflect.DeepEqual(ets, expectedTypes) {
+		t.Errorf("got %q, expected %q", ets, expectedTypes)
+	}
+
+	for _, e := range events {
+		ok, err := d.Dispatch(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != (e.Type != "update_message_flags") {
+			t.Errorf("got %t dispatching %q", ok, e.Type)
+		}
+	}
+
+	d.OnEvent(func(e Event) { got = append(got, "event "+e.Type) })
+	d.Dispatch(events[6])
+
+	expected := []string{"message", "reaction tada", "subscription add", "presence a@example.com",
+		"heartbeat", "typing start", "event update_message_flags"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if ets := d.EventTypes(); !reflect.DeepEqual(ets, []EventType{AllEvents}) {
+		t.Errorf("got %q, expected every type with a catch-all handler", ets)
+	}
+}
+
+func TestRunDispatcher(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[
+			{"id":0,"type":"heartbeat"},
+			{"id":1,"type":"reaction","op":"remove","message_id":10,"emoji_name":"tada"}
+		]}`,
+		`{"result":"success","msg":""}`,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []string
+	d := NewDispatcher()
+	d.OnEvent(func(e Event) {
+		got = append(got, e.Type+" "+e.Op)
+		if e.Type == "reaction" {
+			cancel()
+		}
+	})
+
+	err := bot.RunDispatcher(ctx, d)
+	if err != context.Canceled {
+		t.Fatalf("got %v, expected the context's error", err)
+	}
+	if !reflect.DeepEqual(got, []string{"heartbeat ", "reaction remove"}) {
+		t.Errorf("got %q", got)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	body, _ := ioutil.ReadAll(reqs[0].Body)
+	if string(body) != "" {
+		t.Errorf("got register body %q, expected no event_types to register for every type", string(body))
+	}
+	if last := reqs[len(reqs)-1]; last.Method != "DELETE" {
+		t.Errorf("got %s %s, expected the queue to be deleted", last.Method, last.URL)
+	}
+}
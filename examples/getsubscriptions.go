@@ -21,7 +21,9 @@ func main() {
 		APIKey: apiKey,
 	}
 
-	bot.Init()
+	if _, err := bot.Init(); err != nil {
+		log.Fatal(err)
+	}
 
 	bts := listSubscriptions(&bot)
 	fmt.Printf(bts.String())
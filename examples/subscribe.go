@@ -21,7 +21,9 @@ func main() {
 		APIKey: apiKey,
 	}
 
-	bot.Init()
+	if _, err := bot.Init(); err != nil {
+		log.Fatal(err)
+	}
 
 	streams, err := bot.GetStreams()
 	if err != nil {
@@ -34,11 +36,11 @@ func main() {
 	}
 
 	// subscribe
-	subResp := subscribeToStreams(bot, streams)
+	subResp := subscribeToStreams(&bot, streams)
 	fmt.Println(subResp.String())
 }
 
-func subscribeToStreams(bot gzb.Bot, streams []string) bytes.Buffer {
+func subscribeToStreams(bot *gzb.Bot, streams []string) bytes.Buffer {
 	resp, err := bot.Subscribe(streams)
 	if err != nil {
 		log.Fatal(err)
@@ -18,7 +18,9 @@ func main() {
 		APIKey: apiKey,
 	}
 
-	bot.Init()
+	if _, err := bot.Init(); err != nil {
+		log.Fatal(err)
+	}
 
 	q, err := bot.RegisterAt()
 	if err != nil {
@@ -0,0 +1,107 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Operations on message flags, for UpdateMessageFlags.
+const (
+	FlagAdd    = "add"
+	FlagRemove = "remove"
+)
+
+// Message flags that can be changed with UpdateMessageFlags.
+const (
+	FlagRead      = "read"
+	FlagStarred   = "starred"
+	FlagCollapsed = "collapsed"
+)
+
+// MarkAsRead adds the read flag to the given messages, so they no longer show
+// as unread for the bot.
+func (b *Bot) MarkAsRead(messageIDs []int) (*http.Response, error) {
+	return b.UpdateMessageFlags(messageIDs, FlagAdd, FlagRead)
+}
+
+// MarkRead marks an EventMessage as read, such as once the bot has handled it.
+func (b *Bot) MarkRead(e EventMessage) (*http.Response, error) {
+	return b.MarkAsRead([]int{e.ID})
+}
+
+// MarkStreamRead is MarkStreamAsRead.
+func (b *Bot) MarkStreamRead(stream string) (*http.Response, error) {
+	return b.MarkStreamAsRead(stream)
+}
+
+// Star stars an EventMessage, so it shows in its recipients' starred messages.
+func (b *Bot) Star(e EventMessage) (*http.Response, error) {
+	return b.UpdateMessageFlags([]int{e.ID}, FlagAdd, FlagStarred)
+}
+
+// Unstar removes the star from an EventMessage.
+func (b *Bot) Unstar(e EventMessage) (*http.Response, error) {
+	return b.UpdateMessageFlags([]int{e.ID}, FlagRemove, FlagStarred)
+}
+
+// MarkStreamAsRead marks every message in the named stream as read.
+func (b *Bot) MarkStreamAsRead(stream string) (*http.Response, error) {
+	if stream == "" {
+		return nil, errors.New("stream cannot be empty")
+	}
+
+	id, err := b.GetStreamID(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("stream_id", strconv.Itoa(id))
+
+	req, err := b.constructRequest("POST", "mark_stream_as_read", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// MarkAsUnread removes the read flag from the given messages, moving them
+// back into the bot's unread messages.
+func (b *Bot) MarkAsUnread(messageIDs []int) (*http.Response, error) {
+	return b.UpdateMessageFlags(messageIDs, FlagRemove, FlagRead)
+}
+
+// UpdateMessageFlags adds or removes a flag, such as FlagStarred, on the
+// given messages. op must be FlagAdd or FlagRemove.
+func (b *Bot) UpdateMessageFlags(messageIDs []int, op, flag string) (*http.Response, error) {
+	if len(messageIDs) == 0 {
+		return nil, errors.New("there must be at least one message id")
+	}
+	if op != FlagAdd && op != FlagRemove {
+		return nil, errors.New(`flag op must be "add" or "remove"`)
+	}
+	if flag == "" {
+		return nil, errors.New("flag cannot be empty")
+	}
+
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("messages", string(ids))
+	values.Set("op", op)
+	values.Set("flag", flag)
+
+	req, err := b.constructRequest("POST", "messages/flags", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
@@ -0,0 +1,119 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMarkAsUnread(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.MarkAsUnread(nil)
+	if err == nil {
+		t.Fatal("expected an error for an empty id list")
+	}
+
+	_, err = bot.MarkAsUnread([]int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	if req.URL.Path != "/v1/messages/flags" {
+		t.Errorf("got path %q", req.URL.Path)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	expected := "flag=read&messages=%5B1%2C2%5D&op=remove"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+}
+
+func TestMarkAsRead(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.MarkAsRead([]int{})
+	if err == nil {
+		t.Fatal("expected an error for an empty id list")
+	}
+
+	_, err = bot.MarkAsRead([]int{3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	expected := "flag=read&messages=%5B3%5D&op=add"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+}
+
+func TestMarkStreamAsRead(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","stream_id":15}`,
+		`{"result":"success","msg":""}`,
+	)
+
+	_, err := bot.MarkStreamAsRead("general")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.URL.Path != "/v1/mark_stream_as_read" || string(body) != "stream_id=15" {
+		t.Errorf("got %s %q", req.URL.Path, string(body))
+	}
+}
+
+func TestUpdateMessageFlags(t *testing.T) {
+	type C struct {
+		IDs  []int
+		Op   string
+		Flag string
+		Body string
+		Err  bool
+	}
+
+	cases := map[string]C{
+		"star":     C{IDs: []int{4}, Op: FlagAdd, Flag: FlagStarred, Body: "flag=starred&messages=%5B4%5D&op=add"},
+		"collapse": C{IDs: []int{4, 5}, Op: FlagRemove, Flag: FlagCollapsed, Body: "flag=collapsed&messages=%5B4%2C5%5D&op=remove"},
+		"bad op":   C{IDs: []int{4}, Op: "toggle", Flag: FlagRead, Err: true},
+		"no flag":  C{IDs: []int{4}, Op: FlagAdd, Err: true},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		_, err := bot.UpdateMessageFlags(c.IDs, c.Op, c.Flag)
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+
+		body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+		if string(body) != c.Body {
+			t.Errorf("got %q, expected %q, case %q", string(body), c.Body, k)
+		}
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.MarkRead(EventMessage{ID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	expected := "flag=read&messages=%5B9%5D&op=add"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+}
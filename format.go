@@ -0,0 +1,268 @@
+package gozulipbot
+
+import "strings"
+
+// Spoiler returns detail wrapped in a spoiler block, which Zulip shows
+// collapsed beneath summary.
+func Spoiler(summary, detail string) string {
+	fence := codeFence(detail)
+	summary = strings.Join(strings.Fields(summary), " ")
+	return fence + "spoiler " + summary + "\n" + strings.TrimRight(detail, "\n") + "\n" + fence
+}
+
+// Quote returns content in a quote block, attributed to the named sender with
+// a silent mention, in the form Zulip uses when quoting a message.
+func Quote(sender, content string) string {
+	fence := codeFence(content)
+	quote := fence + "quote\n" + strings.TrimRight(content, "\n") + "\n" + fence
+	if sender == "" {
+		return quote
+	}
+	return "@_**" + sender + "** said:\n" + quote
+}
+
+// CodeBlock returns code in a code block, highlighted as lang if it is set.
+func CodeBlock(lang, code string) string {
+	fence := codeFence(code)
+	return fence + lang + "\n" + strings.TrimRight(code, "\n") + "\n" + fence
+}
+
+// StreamLink returns the markdown linking to a stream.
+func StreamLink(stream string) string {
+	return "#**" + stream + "**"
+}
+
+// TopicLink returns the markdown linking to a topic in a stream.
+func TopicLink(stream, topic string) string {
+	return "#**" + stream + ">" + topic + "**"
+}
+
+// Table returns a markdown table with the given header and rows. Cells are
+// escaped with EscapeMarkdown, and rows shorter than the header are padded.
+func Table(header []string, rows [][]string) string {
+	var out strings.Builder
+	writeRow := func(cells []string) {
+		out.WriteString("|")
+		for i := range header {
+			cell := ""
+			if i < len(cells) {
+				cell = strings.Join(strings.Fields(cells[i]), " ")
+			}
+			out.WriteString(" " + strings.Replace(EscapeMarkdown(cell), "|", "\\|", -1) + " |")
+		}
+		out.WriteString("\n")
+	}
+
+	writeRow(header)
+	out.WriteString("|")
+	for range header {
+		out.WriteString(" --- |")
+	}
+	out.WriteString("\n")
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// A MessageBuilder builds message content from text, mentions, links and
+// blocks. Text is escaped, so it is displayed as written. Blocks, such as
+// code blocks and tables, always start on their own line.
+// The zero value is an empty message, ready to use.
+type MessageBuilder struct {
+	out strings.Builder
+}
+
+// Text adds text, escaped with EscapeMarkdown.
+func (m *MessageBuilder) Text(s string) *MessageBuilder {
+	m.out.WriteString(EscapeMarkdown(s))
+	return m
+}
+
+// Markdown adds markdown as it is, without escaping it.
+func (m *MessageBuilder) Markdown(s string) *MessageBuilder {
+	m.out.WriteString(s)
+	return m
+}
+
+// Line ends the current line.
+func (m *MessageBuilder) Line() *MessageBuilder {
+	m.out.WriteString("\n")
+	return m
+}
+
+// Mention adds a mention of the user.
+func (m *MessageBuilder) Mention(u User) *MessageBuilder {
+	return m.Markdown(Mention(u))
+}
+
+// SilentMention adds a mention of the user that doesn't notify them.
+func (m *MessageBuilder) SilentMention(u User) *MessageBuilder {
+	return m.Markdown(SilentMention(u))
+}
+
+// MentionGroup adds a mention of the named user group.
+func (m *MessageBuilder) MentionGroup(name string) *MessageBuilder {
+	return m.Markdown(MentionGroup(name))
+}
+
+// StreamLink adds a link to the stream.
+func (m *MessageBuilder) StreamLink(stream string) *MessageBuilder {
+	return m.Markdown(StreamLink(stream))
+}
+
+// TopicLink adds a link to the topic.
+func (m *MessageBuilder) TopicLink(stream, topic string) *MessageBuilder {
+	return m.Markdown(TopicLink(stream, topic))
+}
+
+// CodeBlock adds a code block.
+func (m *MessageBuilder) CodeBlock(lang, code string) *MessageBuilder {
+	return m.block(CodeBlock(lang, code))
+}
+
+// Spoiler adds a spoiler block.
+func (m *MessageBuilder) Spoiler(summary, detail string) *MessageBuilder {
+	return m.block(Spoiler(summary, detail))
+}
+
+// Quote adds a quote block, attributed to sender if it is set.
+func (m *MessageBuilder) Quote(sender, content string) *MessageBuilder {
+	return m.block(Quote(sender, content))
+}
+
+// Table adds a table.
+func (m *MessageBuilder) Table(header []string, rows [][]string) *MessageBuilder {
+	return m.block(Table(header, rows))
+}
+
+// block adds s on lines of its own.
+func (m *MessageBuilder) block(s string) *MessageBuilder {
+	if cur := m.out.String(); cur != "" && !strings.HasSuffix(cur, "\n") {
+		m.out.WriteString("\n")
+	}
+	m.out.WriteString(s + "\n")
+	return m
+}
+
+// String returns the message content.
+func (m *MessageBuilder) String() string {
+	return strings.TrimRight(m.out.String(), "\n")
+}
+
+// codeFence returns a fence of backticks longer than any run of backticks in
+// content, so the content can't close the block early.
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// EscapeMarkdown escapes the characters Zulip's markdown would treat as
+// formatting, so that s is displayed literally. This includes the asterisks
+// and underscores of mention syntax, so "@**all**" doesn't notify anyone.
+func EscapeMarkdown(s string) string {
+	var out strings.Builder
+	lineStart := true
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte("\\`*_{}[]()", c) != -1:
+			out.WriteByte('\\')
+		case lineStart && strings.IndexByte("#>-+", c) != -1:
+			out.WriteByte('\\')
+		case lineStart && c >= '0' && c <= '9':
+			// escape the period of a numbered list
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			out.WriteString(s[i:j])
+			if j < len(s) && s[j] == '.' {
+				out.WriteString("\\.")
+				j++
+			}
+			i = j - 1
+			lineStart = false
+			continue
+		}
+		out.WriteByte(c)
+
+		switch c {
+		case '\n':
+			lineStart = true
+		case ' ', '\t':
+		default:
+			lineStart = false
+		}
+	}
+	return out.String()
+}
+
+// A MessageTemplate builds message content from a template with named
+// placeholders, such as "{name} opened {title}". Values are escaped with
+// EscapeMarkdown as they are interpolated, so user supplied text can't add
+// formatting or mentions. Use "{{" and "}}" for literal braces.
+type MessageTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is literal text, or a placeholder if name is set.
+type templatePart struct {
+	text string
+	name string
+}
+
+// NewMessageTemplate parses a template. A "{" without a matching "}" is
+// treated as literal text.
+func NewMessageTemplate(text string) *MessageTemplate {
+	t := &MessageTemplate{}
+	var lit strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if (c == '{' || c == '}') && i+1 < len(text) && text[i+1] == c {
+			lit.WriteByte(c)
+			i++
+			continue
+		}
+		if c == '{' {
+			end := strings.IndexByte(text[i:], '}')
+			if end != -1 {
+				t.parts = append(t.parts, templatePart{text: lit.String()})
+				lit.Reset()
+				t.parts = append(t.parts, templatePart{name: strings.TrimSpace(text[i+1 : i+end])})
+				i += end
+				continue
+			}
+		}
+		lit.WriteByte(c)
+	}
+	t.parts = append(t.parts, templatePart{text: lit.String()})
+	return t
+}
+
+// Render returns the template's content with each placeholder replaced by its
+// escaped value. Placeholders without a value are left empty.
+func (t *MessageTemplate) Render(values map[string]string) string {
+	var out strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			out.WriteString(p.text)
+			continue
+		}
+		out.WriteString(EscapeMarkdown(values[p.name]))
+	}
+	return out.String()
+}
@@ -0,0 +1,94 @@
+package gozulipbot
+
+import "testing"
+
+func TestSpoiler(t *testing.T) {
+	type C struct {
+		Summary  string
+		Detail   string
+		Expected string
+	}
+	cases := map[string]C{
+		"simple": C{Summary: "Stack trace", Detail: "line 1\nline 2\n",
+			Expected: "```spoiler Stack trace\nline 1\nline 2\n```"},
+		"multi line summary": C{Summary: "a\nb", Detail: "c",
+			Expected: "```spoiler a b\nc\n```"},
+		"fenced detail": C{Summary: "Output", Detail: "```\ncode\n```",
+			Expected: "````spoiler Output\n```\ncode\n```\n````"},
+	}
+
+	for name, c := range cases {
+		got := Spoiler(c.Summary, c.Detail)
+		if got != c.Expected {
+			t.Errorf("got %q, expected %q, case %q", got, c.Expected, name)
+		}
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	cases := map[string]string{
+		"@**admin**":        `@\*\*admin\*\*`,
+		"@_**all**":         `@\_\*\*all\*\*`,
+		"[link](http://x)":  `\[link\]\(http://x\)`,
+		"`code`":            "\\`code\\`",
+		"# not a header":    `\# not a header`,
+		"a # b - c":         "a # b - c",
+		"1. one\n  - two":   "1\\. one\n  \\- two",
+		"> quote":           `\> quote`,
+		"version 1.2":       "version 1.2",
+		`C:\path`:           `C:\\path`,
+		"plain text, 100%.": "plain text, 100%.",
+	}
+	for in, expected := range cases {
+		got := EscapeMarkdown(in)
+		if got != expected {
+			t.Errorf("got %q, expected %q, case %q", got, expected, in)
+		}
+	}
+}
+
+func TestMessageTemplate(t *testing.T) {
+	tmpl := NewMessageTemplate("**{user}** opened {{#{id}}}: {title}{missing}")
+	got := tmpl.Render(map[string]string{
+		"user":  "Ann",
+		"id":    "12",
+		"title": "ping @**admin** now",
+	})
+	expected := `**Ann** opened {#12}: ping @\*\*admin\*\* now`
+	if got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+
+	if got := NewMessageTemplate("unclosed {brace").Render(nil); got != "unclosed {brace" {
+		t.Errorf("got %q for an unclosed placeholder", got)
+	}
+}
+
+func TestTable(t *testing.T) {
+	got := Table([]string{"App", "Status"}, [][]string{
+		{"web", "up"},
+		{"a|b", "*down*"},
+		{"worker"},
+	})
+	expected := "| App | Status |\n| --- | --- |\n| web | up |\n| a\\|b | \\*down\\* |\n| worker |  |"
+	if got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestMessageBuilder(t *testing.T) {
+	var m MessageBuilder
+	m.Mention(User{FullName: "Ann", ID: 4}).
+		Text(" deployed *web* to ").
+		TopicLink("ops", "deploys").
+		CodeBlock("sh", "make deploy\n").
+		Text("cc ").
+		MentionGroup("oncall").
+		Line().
+		StreamLink("general")
+
+	expected := "@**Ann|4** deployed \\*web\\* to #**ops>deploys**\n```sh\nmake deploy\n```\ncc @*oncall*\n#**general**"
+	if got := m.String(); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
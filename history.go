@@ -0,0 +1,337 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// A NarrowTerm is one filter of a narrow, such as {"stream", "general"},
+// {"topic", "deploys"} or {"sender", "someone@example.com"}.
+type NarrowTerm struct {
+	Operator string `json:"operator"`
+	Operand  string `json:"operand"`
+}
+
+// GetMessagesOptions selects the messages fetched by GetMessages.
+type GetMessagesOptions struct {
+	// Anchor is the message to fetch around: a message id, or "newest",
+	// "oldest" or "first_unread". If it is empty, "newest" is used.
+	Anchor string
+
+	// ExcludeAnchor leaves the anchor message out of the results, which is
+	// useful when paging from a message that has already been fetched.
+	ExcludeAnchor bool
+
+	// NumBefore and NumAfter are how many messages to fetch before and
+	// after the anchor.
+	NumBefore int
+	NumAfter  int
+
+	// Narrow filters the messages. If it is empty, all messages the bot can
+	// see are fetched.
+	Narrow []NarrowTerm
+}
+
+// A MessagesPage is a page of message history, oldest first.
+//
+// FoundOldest and FoundNewest report whether the page reaches the oldest and
+// newest messages matching the narrow, so paging backward can stop once
+// FoundOldest is true. FoundAnchor reports whether the anchor message
+// itself was found.
+type MessagesPage struct {
+	Messages    []EventMessage `json:"messages"`
+	FoundAnchor bool           `json:"found_anchor"`
+	FoundOldest bool           `json:"found_oldest"`
+	FoundNewest bool           `json:"found_newest"`
+}
+
+// GetMessages fetches message history, oldest first.
+func (b *Bot) GetMessages(opts GetMessagesOptions) ([]EventMessage, error) {
+	page, err := b.GetMessagesPage(opts)
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// GetMessagesAround fetches the messages matching narrow around the message
+// with the given id, oldest first, such as to read the rest of a topic after
+// a restart. The anchor message is included if it matches.
+func (b *Bot) GetMessagesAround(anchor, numBefore, numAfter int, narrow []NarrowTerm) ([]EventMessage, error) {
+	return b.GetMessages(GetMessagesOptions{
+		Anchor:    strconv.Itoa(anchor),
+		NumBefore: numBefore,
+		NumAfter:  numAfter,
+		Narrow:    narrow,
+	})
+}
+
+// GetMessagesPage fetches message history like GetMessages, along with
+// whether the oldest and newest messages were reached. To page backward
+// through a stream, start with an Anchor of "newest", then anchor each
+// following page on the ID of the first message of the last one, with
+// ExcludeAnchor set, until FoundOldest is true.
+func (b *Bot) GetMessagesPage(opts GetMessagesOptions) (*MessagesPage, error) {
+	if opts.NumBefore < 0 || opts.NumAfter < 0 {
+		return nil, errors.New("number of messages cannot be negative")
+	}
+
+	anchor := opts.Anchor
+	if anchor == "" {
+		anchor = "newest"
+	}
+
+	values := url.Values{}
+	values.Set("anchor", anchor)
+	values.Set("num_before", strconv.Itoa(opts.NumBefore))
+	values.Set("num_after", strconv.Itoa(opts.NumAfter))
+	if opts.ExcludeAnchor {
+		values.Set("include_anchor", "false")
+	}
+	if len(opts.Narrow) != 0 {
+		narrow, err := json.Marshal(opts.Narrow)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("narrow", string(narrow))
+	}
+
+	return b.getMessages(values)
+}
+
+// getMessages fetches message history with the given query values.
+func (b *Bot) getMessages(values url.Values) (*MessagesPage, error) {
+	req, err := b.constructRequest("GET", "messages?"+values.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var mp MessagesPage
+	err = b.doJSON(req, &mp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mp, nil
+}
+
+// GetMessage fetches the message with the given id. Its Content is the
+// markdown it was written in, rather than the rendered html.
+func (b *Bot) GetMessage(id int) (*EventMessage, error) {
+	values := url.Values{}
+	values.Set("apply_markdown", "false")
+
+	req, err := b.constructRequest("GET", fmt.Sprintf("messages/%d?%s", id, values.Encode()), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var mj struct {
+		Message EventMessage `json:"message"`
+	}
+	err = b.doJSON(req, &mj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mj.Message, nil
+}
+
+// A MessageEdit is one version of a message in its edit history. The first
+// version is the message as it was sent. For each later version, the Prev
+// fields are set for what the edit changed, such as PrevContent and
+// PrevTopic, and are empty otherwise.
+type MessageEdit struct {
+	UserID      int    `json:"user_id"`
+	Timestamp   int64  `json:"timestamp"`
+	Topic       string `json:"topic"`
+	PrevTopic   string `json:"prev_topic"`
+	Content     string `json:"content"`
+	PrevContent string `json:"prev_content"`
+	StreamID    int    `json:"stream"`
+	PrevStream  int    `json:"prev_stream"`
+}
+
+// Time returns when the version was made, in UTC.
+func (e MessageEdit) Time() time.Time {
+	return time.Unix(e.Timestamp, 0).UTC()
+}
+
+// GetMessageEditHistory fetches the edit history of the message with the
+// given id, oldest first, such as to see what a reported message said before
+// it was edited.
+func (b *Bot) GetMessageEditHistory(id int) ([]MessageEdit, error) {
+	req, err := b.constructRequest("GET", fmt.Sprintf("messages/%d/history", id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var hj struct {
+		MessageHistory []MessageEdit `json:"message_history"`
+	}
+	err = b.doJSON(req, &hj)
+	if err != nil {
+		return nil, err
+	}
+
+	return hj.MessageHistory, nil
+}
+
+// MySentMessages returns up to limit of the most recent messages sent by the
+// bot, newest first.
+func (b *Bot) MySentMessages(limit int) ([]EventMessage, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be positive")
+	}
+
+	msgs, err := b.GetMessages(GetMessagesOptions{
+		Anchor:    "newest",
+		NumBefore: limit,
+		Narrow:    []NarrowTerm{{Operator: "sender", Operand: b.Email}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// history comes oldest first
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	return msgs, nil
+}
+
+// LatestMessageID returns the id of the newest message the bot can see, for
+// recording a point to catch up from later, such as with RunWithCatchup.
+// It returns 0 if the bot can't see any messages.
+func (b *Bot) LatestMessageID() (int, error) {
+	msgs, err := b.GetMessages(GetMessagesOptions{Anchor: "newest"})
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+	for _, m := range msgs {
+		if m.ID > latest {
+			latest = m.ID
+		}
+	}
+	return latest, nil
+}
+
+// DefaultPageSize is how many messages a MessageIterator fetches at a time
+// when its PageSize is 0.
+const DefaultPageSize = 100
+
+// A MessageIterator walks through message history a page at a time, fetching
+// each page as it is needed:
+//
+//	it := bot.IterMessages(narrow, "oldest")
+//	for it.Next() {
+//		archive(it.Message())
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle the error
+//	}
+type MessageIterator struct {
+	// PageSize is how many messages are fetched at a time. If it is 0,
+	// DefaultPageSize is used.
+	PageSize int
+
+	bot      *Bot
+	narrow   []NarrowTerm
+	anchor   string
+	backward bool
+	started  bool
+	done     bool
+
+	page []EventMessage
+	cur  EventMessage
+	err  error
+}
+
+// IterMessages returns an iterator over the messages matching narrow, oldest
+// first, starting from anchor: a message id, or "oldest", "newest" or
+// "first_unread". The anchor message is included if it matches.
+func (b *Bot) IterMessages(narrow []NarrowTerm, anchor string) *MessageIterator {
+	return &MessageIterator{bot: b, narrow: narrow, anchor: anchor}
+}
+
+// IterMessagesBackward is IterMessages, newest first, walking back from
+// anchor, such as "newest".
+func (b *Bot) IterMessagesBackward(narrow []NarrowTerm, anchor string) *MessageIterator {
+	return &MessageIterator{bot: b, narrow: narrow, anchor: anchor, backward: true}
+}
+
+// Next advances to the next message, and reports whether there is one. It
+// returns false at the end of the history, or on an error, which Err returns.
+func (it *MessageIterator) Next() bool {
+	if len(it.page) == 0 && !it.fetch() {
+		return false
+	}
+
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Message returns the message Next advanced to.
+func (it *MessageIterator) Message() EventMessage {
+	return it.cur
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *MessageIterator) Err() error {
+	return it.err
+}
+
+// fetch fetches the next page, and reports whether it has any messages.
+func (it *MessageIterator) fetch() bool {
+	for !it.done && len(it.page) == 0 {
+		size := it.PageSize
+		if size <= 0 {
+			size = DefaultPageSize
+		}
+
+		anchor := it.anchor
+		if anchor == "" {
+			anchor = "oldest"
+			if it.backward {
+				anchor = "newest"
+			}
+		}
+		opts := GetMessagesOptions{Anchor: anchor, ExcludeAnchor: it.started, Narrow: it.narrow}
+		if it.backward {
+			opts.NumBefore = size
+		} else {
+			opts.NumAfter = size
+		}
+
+		page, err := it.bot.GetMessagesPage(opts)
+		if err != nil {
+			it.err = err
+			it.done = true
+			return false
+		}
+		it.started = true
+
+		msgs := page.Messages
+		if n := len(msgs); n == 0 {
+			it.done = true
+		} else if it.backward {
+			it.anchor = strconv.Itoa(msgs[0].ID)
+			it.done = page.FoundOldest
+			for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+				msgs[i], msgs[j] = msgs[j], msgs[i]
+			}
+		} else {
+			it.anchor = strconv.Itoa(msgs[n-1].ID)
+			it.done = page.FoundNewest
+		}
+		it.page = msgs
+	}
+	return len(it.page) > 0
+}
@@ -0,0 +1,232 @@
+package gozulipbot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMySentMessages(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","found_newest":true,
+		"messages":[{"id":1,"content":"old"},{"id":2,"content":"new"}]}`)
+
+	msgs, err := bot.MySentMessages(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != 2 || msgs[1].ID != 1 {
+		t.Errorf("expected messages newest first, got %v", msgs)
+	}
+
+	q := bot.Client.(*testClient).Request.URL.Query()
+	if q.Get("anchor") != "newest" || q.Get("num_before") != "2" || q.Get("num_after") != "0" {
+		t.Errorf("got query %v", q)
+	}
+	expected := `[{"operator":"sender","operand":"testbot@example.com"}]`
+	if q.Get("narrow") != expected {
+		t.Errorf("got narrow %q, expected %q", q.Get("narrow"), expected)
+	}
+}
+
+func TestLatestMessageID(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","found_anchor":true,"messages":[{"id":345}]}`,
+		`{"result":"success","msg":"","found_anchor":false,"messages":[]}`,
+	)
+
+	id, err := bot.LatestMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 345 {
+		t.Errorf("got %d, expected 345", id)
+	}
+	q := bot.Client.(*testClient).Request.URL.Query()
+	if q.Get("anchor") != "newest" || q.Get("num_before") != "0" || q.Get("num_after") != "0" {
+		t.Errorf("got query %v", q)
+	}
+
+	id, err = bot.LatestMessageID()
+	if err != nil || id != 0 {
+		t.Errorf("got %d, %v, expected 0 with no messages", id, err)
+	}
+}
+
+func TestGetMessagesPage(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","found_anchor":true,"found_oldest":true,
+		"messages":[{"id":3,"subject":"deploys"},{"id":4,"subject":"deploys"}]}`)
+
+	page, err := bot.GetMessagesPage(GetMessagesOptions{
+		Anchor:        "5",
+		ExcludeAnchor: true,
+		NumBefore:     10,
+		Narrow: []NarrowTerm{
+			{Operator: "stream", Operand: "general"},
+			{Operator: "topic", Operand: "deploys"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].ID != 3 || !page.FoundOldest || page.FoundNewest {
+		t.Errorf("got %+v", page)
+	}
+
+	q := bot.Client.(*testClient).Request.URL.Query()
+	if q.Get("anchor") != "5" || q.Get("include_anchor") != "false" || q.Get("num_before") != "10" || q.Get("num_after") != "0" {
+		t.Errorf("got query %v", q)
+	}
+	expected := `[{"operator":"stream","operand":"general"},{"operator":"topic","operand":"deploys"}]`
+	if q.Get("narrow") != expected {
+		t.Errorf("got narrow %q, expected %q", q.Get("narrow"), expected)
+	}
+
+	_, err = bot.GetMessages(GetMessagesOptions{NumBefore: -1})
+	if err == nil {
+		t.Error("expected an error for a negative number of messages")
+	}
+}
+
+func TestGetMessagesAround(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","found_anchor":true,
+		"messages":[{"id":9},{"id":10},{"id":11}]}`)
+
+	msgs, err := bot.GetMessagesAround(10, 1, 1, []NarrowTerm{{Operator: "topic", Operand: "deploys"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[1].ID != 10 {
+		t.Errorf("got %v", msgs)
+	}
+
+	q := bot.Client.(*testClient).Request.URL.Query()
+	if q.Get("anchor") != "10" || q.Get("num_before") != "1" || q.Get("num_after") != "1" || q.Get("include_anchor") != "" {
+		t.Errorf("got query %v", q)
+	}
+	if q.Get("narrow") != `[{"operator":"topic","operand":"deploys"}]` {
+		t.Errorf("got narrow %q", q.Get("narrow"))
+	}
+}
+
+func TestIterMessages(t *testing.T) {
+	type C struct {
+		Backward bool
+		Pages    []string
+		IDs      []int
+		Anchors  []string
+	}
+
+	cases := map[string]C{
+		"forward": C{
+			Pages: []string{
+				`{"result":"success","msg":"","messages":[{"id":1},{"id":2}]}`,
+				`{"result":"success","msg":"","found_newest":true,"messages":[{"id":5}]}`,
+			},
+			IDs:     []int{1, 2, 5},
+			Anchors: []string{"oldest", "2"},
+		},
+		"backward": C{
+			Backward: true,
+			Pages: []string{
+				`{"result":"success","msg":"","messages":[{"id":8},{"id":9}]}`,
+				`{"result":"success","msg":"","messages":[]}`,
+			},
+			IDs:     []int{9, 8},
+			Anchors: []string{"newest", "8"},
+		},
+		"empty": C{
+			Pages:   []string{`{"result":"success","msg":"","found_newest":true,"messages":[]}`},
+			Anchors: []string{"oldest"},
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBotWithResponses(c.Pages...)
+		it := bot.IterMessages([]NarrowTerm{{"stream", "ops"}}, "")
+		if c.Backward {
+			it = bot.IterMessagesBackward([]NarrowTerm{{"stream", "ops"}}, "")
+		}
+		it.PageSize = 2
+
+		var ids []int
+		for it.Next() {
+			ids = append(ids, it.Message().ID)
+		}
+		if it.Err() != nil {
+			t.Fatalf("got %q, expected nil, case %q", it.Err(), k)
+		}
+		if !reflect.DeepEqual(ids, c.IDs) {
+			t.Errorf("got %v, expected %v, case %q", ids, c.IDs, k)
+		}
+
+		reqs := bot.Client.(*testClient).Requests
+		if len(reqs) != len(c.Anchors) {
+			t.Fatalf("got %d requests, expected %d, case %q", len(reqs), len(c.Anchors), k)
+		}
+		for i, req := range reqs {
+			q := req.URL.Query()
+			if q.Get("anchor") != c.Anchors[i] || (i > 0) != (q.Get("include_anchor") == "false") {
+				t.Errorf("got query %v, request %d, case %q", q, i, k)
+			}
+		}
+	}
+}
+
+func TestIterMessagesError(t *testing.T) {
+	bot := getTestBot()
+	bot.Client.(*testClient).Response = jsonResponse(400, `{"result":"error","msg":"Invalid narrow","code":"BAD_REQUEST"}`)
+
+	it := bot.IterMessages(nil, "oldest")
+	if it.Next() {
+		t.Error("expected no messages")
+	}
+	if it.Err() == nil || it.Err().Error() != "Invalid narrow" {
+		t.Errorf("got %v, expected the error", it.Err())
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","raw_content":"**hi**",
+		"message":{"id":12,"content":"**hi**","sender_email":"a@example.com","subject":"t"}}`)
+
+	m, err := bot.GetMessage(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 12 || m.Content != "**hi**" || m.SenderEmail != "a@example.com" {
+		t.Errorf("got %+v", *m)
+	}
+
+	req := bot.Client.(*testClient).Request
+	if req.URL.Path != "/v1/messages/12" || req.URL.Query().Get("apply_markdown") != "false" {
+		t.Errorf("got %s", req.URL)
+	}
+}
+
+func TestGetMessageEditHistory(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","message_history":[
+		{"topic":"t","content":"helo","rendered_content":"<p>helo</p>","user_id":5,"timestamp":100},
+		{"topic":"t","content":"hello","prev_content":"helo","user_id":5,"timestamp":160},
+		{"topic":"t2","prev_topic":"t","content":"hello","user_id":6,"timestamp":200}
+	]}`)
+
+	edits, err := bot.GetMessageEditHistory(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/messages/12/history" {
+		t.Errorf("got path %q", p)
+	}
+
+	expected := []MessageEdit{
+		{UserID: 5, Timestamp: 100, Topic: "t", Content: "helo"},
+		{UserID: 5, Timestamp: 160, Topic: "t", Content: "hello", PrevContent: "helo"},
+		{UserID: 6, Timestamp: 200, Topic: "t2", PrevTopic: "t", Content: "hello"},
+	}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("got %+v, expected %+v", edits, expected)
+	}
+	if got := edits[1].Time(); got.Unix() != 160 || got.Location() != time.UTC {
+		t.Errorf("got %v, expected the edit time in UTC", got)
+	}
+}
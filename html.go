@@ -0,0 +1,195 @@
+package gozulipbot
+
+import (
+	"html"
+	"strings"
+)
+
+// StripHTML converts the rendered html content of a Zulip message into
+// readable plain text. Paragraphs and line breaks become newlines, code
+// blocks keep their whitespace, links are written as "text (url)",
+// and images such as emoji are replaced with their alt text.
+//
+// It is meant for the html Zulip generates, not arbitrary html.
+func StripHTML(rendered string) string {
+	var out strings.Builder
+	var links []linkStart
+	pre := 0
+
+	s := rendered
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i == -1 {
+			writeText(&out, s, pre > 0)
+			break
+		}
+		writeText(&out, s[:i], pre > 0)
+		s = s[i:]
+
+		end := strings.IndexByte(s, '>')
+		if end == -1 {
+			// not a tag, write the rest as text
+			writeText(&out, s, pre > 0)
+			break
+		}
+		tag := s[1:end]
+		s = s[end+1:]
+
+		name, closing := tagName(tag)
+		switch name {
+		case "br":
+			out.WriteString("\n")
+		case "p", "div", "blockquote", "ul", "ol", "table",
+			"h1", "h2", "h3", "h4", "h5", "h6":
+			newBlock(&out)
+		case "li", "tr":
+			if closing {
+				newLine(&out)
+			} else {
+				newLine(&out)
+				if name == "li" {
+					out.WriteString("- ")
+				}
+			}
+		case "td", "th":
+			if closing {
+				out.WriteString(" ")
+			}
+		case "pre":
+			newBlock(&out)
+			if closing {
+				if pre > 0 {
+					pre--
+				}
+			} else {
+				pre++
+			}
+		case "img":
+			writeText(&out, tagAttr(tag, "alt"), false)
+		case "a":
+			if closing {
+				if len(links) == 0 {
+					continue
+				}
+				l := links[len(links)-1]
+				links = links[:len(links)-1]
+				text := strings.TrimSpace(out.String()[l.offset:])
+				if l.href != "" && l.href != text {
+					out.WriteString(" (" + l.href + ")")
+				}
+			} else {
+				links = append(links, linkStart{
+					href:   html.UnescapeString(tagAttr(tag, "href")),
+					offset: out.Len(),
+				})
+			}
+		}
+	}
+
+	return cleanText(out.String())
+}
+
+type linkStart struct {
+	href   string
+	offset int
+}
+
+// tagName returns the lower case name of a tag, and whether it is a closing tag.
+func tagName(tag string) (string, bool) {
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+	end := strings.IndexAny(tag, " \t\n/")
+	if end != -1 {
+		tag = tag[:end]
+	}
+	return strings.ToLower(tag), closing
+}
+
+// tagAttr returns the value of a quoted attribute within a tag.
+func tagAttr(tag, attr string) string {
+	for _, q := range []string{`"`, `'`} {
+		key := " " + attr + "=" + q
+		i := strings.Index(tag, key)
+		if i == -1 {
+			continue
+		}
+		v := tag[i+len(key):]
+		end := strings.Index(v, q)
+		if end == -1 {
+			return ""
+		}
+		return v[:end]
+	}
+	return ""
+}
+
+// writeText writes unescaped text, collapsing whitespace unless it is preformatted.
+func writeText(out *strings.Builder, text string, pre bool) {
+	text = html.UnescapeString(text)
+	if pre {
+		out.WriteString(text)
+		return
+	}
+	if strings.TrimSpace(text) == "" {
+		// whitespace between tags only matters within a line
+		if text != "" && !strings.HasSuffix(out.String(), "\n") && !strings.HasSuffix(out.String(), " ") && out.Len() > 0 {
+			out.WriteString(" ")
+		}
+		return
+	}
+	fields := strings.Fields(text)
+	if startsWithSpace(text) && out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") && !strings.HasSuffix(out.String(), " ") {
+		out.WriteString(" ")
+	}
+	out.WriteString(strings.Join(fields, " "))
+	if endsWithSpace(text) {
+		out.WriteString(" ")
+	}
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeft(s, " \t\n\r") != s
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRight(s, " \t\n\r") != s
+}
+
+// newLine ends the current line if it isn't already empty.
+func newLine(out *strings.Builder) {
+	if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString("\n")
+	}
+}
+
+// newBlock separates blocks with an empty line.
+func newBlock(out *strings.Builder) {
+	if out.Len() == 0 {
+		return
+	}
+	newLine(out)
+	if !strings.HasSuffix(out.String(), "\n\n") {
+		out.WriteString("\n")
+	}
+}
+
+// cleanText trims trailing spaces from each line and removes extra blank lines.
+func cleanText(s string) string {
+	lines := strings.Split(s, "\n")
+	var out []string
+	blank := 0
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		if strings.TrimSpace(l) == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+			l = ""
+		} else {
+			blank = 0
+		}
+		out = append(out, l)
+	}
+	return strings.Trim(strings.Join(out, "\n"), "\n")
+}
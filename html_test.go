@@ -0,0 +1,37 @@
+package gozulipbot
+
+import "testing"
+
+func TestStripHTML(t *testing.T) {
+	type C struct {
+		HTML     string
+		Expected string
+	}
+	cases := map[string]C{
+		"paragraphs": C{HTML: "<p>hello</p>\n<p>world &amp; friends</p>",
+			Expected: "hello\n\nworld & friends"},
+		"line break": C{HTML: "<p>one<br>\ntwo</p>",
+			Expected: "one\ntwo"},
+		"mention": C{HTML: `<p>hi <span class="user-mention" data-user-id="12">@Test Bot</span>, deploy?</p>`,
+			Expected: "hi @Test Bot, deploy?"},
+		"link": C{HTML: `<p>see <a href="https://example.com/a?b=1&amp;c=2">the docs</a></p>`,
+			Expected: "see the docs (https://example.com/a?b=1&c=2)"},
+		"bare link": C{HTML: `<p><a href="https://example.com">https://example.com</a></p>`,
+			Expected: "https://example.com"},
+		"code block": C{HTML: "<p>run:</p>\n<div class=\"codehilite\"><pre><span></span><code>go test ./...\n  &lt;ok&gt;\n</code></pre></div>",
+			Expected: "run:\n\ngo test ./...\n  <ok>"},
+		"inline code": C{HTML: "<p>use <code>x &lt; y</code> here</p>",
+			Expected: "use x < y here"},
+		"emoji": C{HTML: `<p>done <img alt=":check:" class="emoji" src="/user_avatars/1/emoji/check.png" title="check"></p>`,
+			Expected: "done :check:"},
+		"list": C{HTML: "<ul>\n<li>a</li>\n<li>b</li>\n</ul>",
+			Expected: "- a\n- b"},
+	}
+
+	for name, c := range cases {
+		got := StripHTML(c.HTML)
+		if got != c.Expected {
+			t.Errorf("got %q, expected %q, case %q", got, c.Expected, name)
+		}
+	}
+}
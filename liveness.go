@@ -0,0 +1,76 @@
+package gozulipbot
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultStallTimeout is the StallTimeout used when OnStall is set. It allows
+// for a couple of missed heartbeats.
+const DefaultStallTimeout = 3 * time.Minute
+
+// LastEventAt returns when the queue last received an event, including a
+// heartbeat, or when it was registered if it hasn't received one since.
+func (q *Queue) LastEventAt() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lastEventAt
+}
+
+// LastEventAt returns the most recent LastEventAt of the bot's queues, or
+// the zero time if it has none.
+func (b *Bot) LastEventAt() time.Time {
+	b.mu.Lock()
+	queues := append([]*Queue(nil), b.Queues...)
+	b.mu.Unlock()
+
+	var last time.Time
+	for _, q := range queues {
+		if t := q.LastEventAt(); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// stallTimeout returns how long to wait for an event before a queue is
+// stalled, or 0 if stalls aren't watched for.
+func (b *Bot) stallTimeout() time.Duration {
+	if b.StallTimeout > 0 {
+		return b.StallTimeout
+	}
+	if b.OnStall != nil {
+		return DefaultStallTimeout
+	}
+	return 0
+}
+
+// watchStall returns the context for one request for the queue's events,
+// which is canceled if the queue stalls, and a function to call once the
+// request is done. The timeout counts from the queue's last event, or, if
+// the queue has already stalled, from when the request starts, so each
+// reconnection gets a full StallTimeout.
+func (b *Bot) watchStall(ctx context.Context, q *Queue) (context.Context, func()) {
+	timeout := b.stallTimeout()
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	from := q.LastEventAt()
+	if now := time.Now(); now.Sub(from) > timeout {
+		from = now
+	}
+	t := time.AfterFunc(time.Until(from.Add(timeout)), func() {
+		since := time.Since(q.LastEventAt())
+		b.logger().Warn("queue stalled, reconnecting", "queue_id", q.queueID(), "since_last_event", since)
+		if b.OnStall != nil {
+			b.OnStall(q, since)
+		}
+		cancel()
+	})
+	return ctx, func() {
+		t.Stop()
+		cancel()
+	}
+}
@@ -0,0 +1,76 @@
+package gozulipbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLastEventAt(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"heartbeat"}]}`,
+	)
+	if !bot.LastEventAt().IsZero() {
+		t.Errorf("got %v, expected the zero time without queues", bot.LastEventAt())
+	}
+
+	before := time.Now()
+	q, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := q.LastEventAt()
+	if registered.Before(before) {
+		t.Errorf("got %v, expected the registration time", registered)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := q.GetEvents(); err != HeartbeatError {
+		t.Fatalf("got %v, expected a heartbeat", err)
+	}
+	if !q.LastEventAt().After(registered) {
+		t.Error("expected a heartbeat to update LastEventAt")
+	}
+	if !bot.LastEventAt().Equal(q.LastEventAt()) {
+		t.Errorf("got %v, expected %v", bot.LastEventAt(), q.LastEventAt())
+	}
+}
+
+func TestOnStall(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+	)
+	tc := bot.Client.(*testClient)
+	bot.Client = waitingClient{tc}
+	bot.StallTimeout = 20 * time.Millisecond
+	stalls := make(chan time.Duration, 10)
+	bot.OnStall = func(q *Queue, since time.Duration) {
+		stalls <- since
+	}
+
+	q, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bot.pollQueue(ctx, q, func(EventMessage) error { return nil })
+	}()
+
+	select {
+	case since := <-stalls:
+		if since < bot.StallTimeout {
+			t.Errorf("got %v since the last event, expected at least %v", since, bot.StallTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected OnStall to be called")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+}
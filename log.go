@@ -0,0 +1,28 @@
+package gozulipbot
+
+import (
+	"io"
+	"log"
+	"log/slog"
+)
+
+// discardLogger is used when the bot has no Logger.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// logger returns the bot's Logger, or a logger that discards everything.
+func (b *Bot) logger() *slog.Logger {
+	if b.Logger == nil {
+		return discardLogger
+	}
+	return b.Logger
+}
+
+// logRecovered logs a panic recovered from a handler of a message. Without a
+// Logger, it is logged with the log package, so panics are never silent.
+func (b *Bot) logRecovered(messageID int, r interface{}) {
+	if b.Logger == nil {
+		log.Printf("gozulipbot: recovered from panic handling message %d: %v", messageID, r)
+		return
+	}
+	b.Logger.Error("recovered from panic handling message", "message_id", messageID, "panic", r)
+}
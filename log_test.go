@@ -0,0 +1,50 @@
+package gozulipbot
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	bad := `{"result":"error","msg":"Bad event queue id: q1","code":"BAD_EVENT_QUEUE_ID","queue_id":"q1"}`
+	bot := getTestBotWithResponses(
+		bad,
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1,"sender_email":"other@example.com"}}]}`,
+		`{"result":"error","msg":"Stream 'nowhere' does not exist","code":"STREAM_DOES_NOT_EXIST"}`,
+	)
+	var buf bytes.Buffer
+	bot.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	bot.AllowedSenders = []string{"user@example.com"}
+
+	q := &Queue{Bot: bot, ID: "q1"}
+	if _, err := q.GetEvents(); err != nil {
+		t.Fatal(err)
+	}
+	bot.Message(Message{Stream: "nowhere", Topic: "t", Content: "hi"})
+
+	logs := buf.String()
+	for _, expected := range []string{
+		`level=WARN msg="queue expired, registering it again" queue_id=q1`,
+		`level=INFO msg="registered queue" queue_id=q2`,
+		`level=DEBUG msg="dropped message" message_id=1 sender_email=other@example.com reason="sender not allowed"`,
+		`level=WARN msg="sending message failed" error="Stream 'nowhere' does not exist"`,
+	} {
+		if !strings.Contains(logs, expected) {
+			t.Errorf("expected the logs to contain %q, got:\n%s", expected, logs)
+		}
+	}
+}
+
+func TestLogRecovered(t *testing.T) {
+	var buf bytes.Buffer
+	bot := getTestBot()
+	bot.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	bot.handleMessage(func(b *Bot, m EventMessage) { panic("boom") }, EventMessage{ID: 3})
+	if !strings.Contains(buf.String(), `level=ERROR msg="recovered from panic handling message" message_id=3 panic=boom`) {
+		t.Errorf("got %q", buf.String())
+	}
+}
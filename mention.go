@@ -0,0 +1,65 @@
+package gozulipbot
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Mention returns the markdown mentioning the user, notifying them.
+// If the user's ID is known, it is included, so the mention still works for
+// users sharing a name, or with names that can't be written in a mention,
+// such as ones containing asterisks. Without an ID, a name containing
+// asterisks can't be mentioned, and is written as plain text instead.
+func Mention(u User) string {
+	return mention("@", u)
+}
+
+// SilentMention returns the markdown mentioning the user without notifying them.
+func SilentMention(u User) string {
+	return mention("@_", u)
+}
+
+// MentionAll returns the markdown mentioning everyone in the stream.
+func MentionAll() string {
+	return "@**all**"
+}
+
+// MentionGroup returns the markdown mentioning the user group with the given
+// name, notifying its members.
+func MentionGroup(name string) string {
+	return "@*" + name + "*"
+}
+
+// SilentMentionGroup returns the markdown mentioning the named user group
+// without notifying its members.
+func SilentMentionGroup(name string) string {
+	return "@_*" + name + "*"
+}
+
+// MentionByEmail returns the markdown mentioning the user with the given
+// email, looking the user up so the mention includes their name and ID.
+func (b *Bot) MentionByEmail(email string) (string, error) {
+	u, err := b.GetUser(email)
+	if err != nil {
+		return "", err
+	}
+	return Mention(*u), nil
+}
+
+// mention returns a user's mention, starting with prefix.
+func mention(prefix string, u User) string {
+	name := u.FullName
+	if u.ID == 0 {
+		// the name would end the mention at its first asterisk
+		if strings.Contains(name, "*") {
+			return EscapeMarkdown("@" + name)
+		}
+		return prefix + "**" + name + "**"
+	}
+	// the name ends at the first asterisk, so leave it out and let the
+	// id alone pick out the user
+	if strings.Contains(name, "*") {
+		name = ""
+	}
+	return prefix + "**" + name + "|" + strconv.Itoa(u.ID) + "**"
+}
@@ -0,0 +1,49 @@
+package gozulipbot
+
+import "testing"
+
+func TestMention(t *testing.T) {
+	type C struct {
+		User    User
+		Mention string
+		Silent  string
+	}
+
+	cases := map[string]C{
+		"name": C{User: User{FullName: "Some One"},
+			Mention: "@**Some One**", Silent: "@_**Some One**"},
+		"id": C{User: User{FullName: "Some One", ID: 5},
+			Mention: "@**Some One|5**", Silent: "@_**Some One|5**"},
+		"asterisks": C{User: User{FullName: "*star* user", ID: 6},
+			Mention: "@**|6**", Silent: "@_**|6**"},
+		"asterisks without id": C{User: User{FullName: "*star* user"},
+			Mention: `@\*star\* user`, Silent: `@\*star\* user`},
+		"angle brackets": C{User: User{FullName: "<script>", ID: 7},
+			Mention: "@**<script>|7**", Silent: "@_**<script>|7**"},
+	}
+
+	for k, c := range cases {
+		if got := Mention(c.User); got != c.Mention {
+			t.Errorf("got %q, expected %q, case %q", got, c.Mention, k)
+		}
+		if got := SilentMention(c.User); got != c.Silent {
+			t.Errorf("got %q, expected %q, case %q", got, c.Silent, k)
+		}
+	}
+
+	if MentionAll() != "@**all**" {
+		t.Errorf("got %q", MentionAll())
+	}
+}
+
+func TestMentionByEmail(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","user":{"user_id":8,"full_name":"Some One","email":"a@example.com"}}`)
+
+	m, err := bot.MentionByEmail("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != "@**Some One|8**" {
+		t.Errorf("got %q", m)
+	}
+}
@@ -1,23 +1,52 @@
 package gozulipbot
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
+	"unicode/utf8"
 )
 
+// DefaultMaxContentLength is the longest message content, in characters,
+// that Zulip accepts by default.
+const DefaultMaxContentLength = 10000
+
 // A Message is all of the necessary metadata to post on Zulip.
 // It can be either a public message, where Topic is set, or a private message,
-// where there is at least one element in Emails.
+// where there is at least one element in Emails or UserIDs.
+//
+// If the length of Emails or UserIDs is not 0, functions will always assume it
+// is a private message. UserIDs is preferred by newer servers, and works on
+// realms that hide users' emails; it cannot be combined with Emails.
 //
-// If the length of Emails is not 0, functions will always assume it is a private message.
+// RawTo, when set, is sent verbatim as the message's "to" value, for recipient
+// formats the library doesn't know about. It disables the recipient type
+// inference, so the message "type" must be set in Extra, and it cannot be
+// combined with Stream or Emails. Topic is still sent if it is set.
+//
+// Extra holds additional form values to send with the message. They take
+// precedence over the values the library sets.
 type Message struct {
 	Stream  string
 	Topic   string
 	Emails  []string
+	UserIDs []int
 	Content string
+	RawTo   string
+	Extra   map[string]string
+}
+
+// A MessageResponse is Zulip's response to sending a message.
+type MessageResponse struct {
+	ID  int    `json:"id"`
+	Msg string `json:"msg"`
 }
 
 type EventMessage struct {
@@ -35,26 +64,89 @@ type EventMessage struct {
 	SenderID         int              `json:"sender_id"`
 	SenderShortName  string           `json:"sender_short_name"`
 	Subject          string           `json:"subject"`
-	SubjectLinks     []interface{}    `json:"subject_links"`
+	SubjectLinks     []SubjectLink    `json:"subject_links"`
+	TopicLinks       []SubjectLink    `json:"topic_links"`
 	Timestamp        int              `json:"timestamp"`
 	Type             string           `json:"type"`
 	Queue            *Queue           `json:"-"`
 }
 
+// ParticipantCount returns the number of users in a private conversation,
+// including the bot. It returns 0 for stream messages.
+func (e EventMessage) ParticipantCount() int {
+	if e.Type == "stream" || e.DisplayRecipient.Topic != "" {
+		return 0
+	}
+	return len(e.DisplayRecipient.Users)
+}
+
+// Time returns when the message was sent, in UTC.
+func (e EventMessage) Time() time.Time {
+	return time.Unix(int64(e.Timestamp), 0).UTC()
+}
+
+// IsGroupDM reports whether the message is a private message with more than
+// one other user.
+func (e EventMessage) IsGroupDM() bool {
+	return e.ParticipantCount() > 2
+}
+
+// A SubjectLink is a link Zulip found in a message's topic, such as an issue
+// reference matched by one of the realm's linkifiers.
+type SubjectLink struct {
+	URL  string `json:"url"`
+	Text string `json:"text"`
+}
+
+// UnmarshalJSON accepts both the {"url", "text"} objects sent by newer servers
+// and the bare url strings sent by older ones.
+func (l *SubjectLink) UnmarshalJSON(b []byte) error {
+	var u string
+	if err := json.Unmarshal(b, &u); err == nil {
+		*l = SubjectLink{URL: u, Text: u}
+		return nil
+	}
+
+	type link SubjectLink
+	return json.Unmarshal(b, (*link)(l))
+}
+
 type DisplayRecipient struct {
 	Users []User `json:"users,omitempty"`
 	Topic string `json:"topic,omitempty"`
 }
 
 type User struct {
+	AvatarURL     string `json:"avatar_url"`
 	Domain        string `json:"domain"`
 	Email         string `json:"email"`
 	FullName      string `json:"full_name"`
 	ID            int    `json:"id"`
+	IsActive      bool   `json:"is_active"`
+	IsAdmin       bool   `json:"is_admin"`
+	IsBot         bool   `json:"is_bot"`
 	IsMirrorDummy bool   `json:"is_mirror_dummy"`
+	Role          int    `json:"role"`
 	ShortName     string `json:"short_name"`
 }
 
+// UnmarshalJSON accepts both the "id" key used in messages and the "user_id"
+// key used by the users endpoints.
+func (u *User) UnmarshalJSON(b []byte) error {
+	type user User
+	aux := struct {
+		*user
+		UserID int `json:"user_id"`
+	}{user: (*user)(u)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if u.ID == 0 {
+		u.ID = aux.UserID
+	}
+	return nil
+}
+
 func (d *DisplayRecipient) UnmarshalJSON(b []byte) (err error) {
 	topic, users := "", make([]User, 1)
 	if err = json.Unmarshal(b, &topic); err == nil {
@@ -70,14 +162,34 @@ func (d *DisplayRecipient) UnmarshalJSON(b []byte) (err error) {
 
 // Message posts a message to Zulip. If any emails have been set on the message,
 // the message will be re-routed to the PrivateMessage function.
+//
+// If Zulip responds with an error, such as for a stream that doesn't exist,
+// the response is returned along with a *ZulipError describing it.
 func (b *Bot) Message(m Message) (*http.Response, error) {
+	return b.MessageCtx(context.Background(), m)
+}
+
+// MessageCtx is Message, with a context that can cancel the request.
+// If the context is done before the request completes, the error wraps
+// the context's error.
+func (b *Bot) MessageCtx(ctx context.Context, m Message) (*http.Response, error) {
 	if m.Content == "" {
 		return nil, errors.New("content cannot be empty")
 	}
 
-	// if any emails are set, this is a private message
-	if len(m.Emails) != 0 {
-		return b.PrivateMessage(m)
+	if m.RawTo != "" {
+		if m.Stream != "" || len(m.Emails) != 0 || len(m.UserIDs) != 0 {
+			return nil, errors.New("RawTo cannot be combined with a stream, emails or user ids")
+		}
+		if m.Extra["type"] == "" {
+			return nil, errors.New("a message with RawTo must set its type in Extra")
+		}
+		return b.sendMessageRequest(ctx, m)
+	}
+
+	// if any emails or user ids are set, this is a private message
+	if len(m.Emails) != 0 || len(m.UserIDs) != 0 {
+		return b.PrivateMessageCtx(ctx, m)
 	}
 
 	// otherwise it's a stream message
@@ -87,29 +199,134 @@ func (b *Bot) Message(m Message) (*http.Response, error) {
 	if m.Topic == "" {
 		return nil, errors.New("topic cannot be empty")
 	}
-	req, err := b.constructMessageRequest(m)
+	return b.sendMessageRequest(ctx, m)
+}
+
+// sendMessageRequest posts a message. If Zulip responds with an error, the
+// response is returned along with a *ZulipError.
+func (b *Bot) sendMessageRequest(ctx context.Context, m Message) (*http.Response, error) {
+	req, err := b.constructMessageRequest(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
 	if err != nil {
+		b.logger().Warn("sending message failed", "error", err)
 		return nil, err
 	}
-	return b.Client.Do(req)
+
+	err = parseResponse(resp)
+	if err != nil {
+		b.logger().Warn("sending message failed", "error", err)
+		return resp, err
+	}
+
+	b.metrics().MessageSent()
+	b.rememberSent(resp)
+	return resp, nil
 }
 
-// PrivateMessage sends a message to the users in the message email slice.
-func (b *Bot) PrivateMessage(m Message) (*http.Response, error) {
-	if len(m.Emails) == 0 {
-		return nil, errors.New("there must be at least one recipient")
+// SendMessage posts a message like Message, and returns Zulip's decoded
+// response, such as the new message's ID. Use Message for the raw response.
+func (b *Bot) SendMessage(m Message) (*MessageResponse, error) {
+	return b.SendMessageCtx(context.Background(), m)
+}
+
+// SendMessageCtx is SendMessage, with a context that can cancel the request.
+func (b *Bot) SendMessageCtx(ctx context.Context, m Message) (*MessageResponse, error) {
+	resp, err := b.MessageCtx(ctx, m)
+	return decodeMessageResponse(resp, err)
+}
+
+// Reply responds to an EventMessage like Respond, and returns Zulip's
+// decoded response. Use Respond for the raw response.
+func (b *Bot) Reply(e EventMessage, response string) (*MessageResponse, error) {
+	resp, err := b.Respond(e, response)
+	return decodeMessageResponse(resp, err)
+}
+
+// decodeMessageResponse decodes and closes the response to sending a message.
+func decodeMessageResponse(resp *http.Response, err error) (*MessageResponse, error) {
+	if resp != nil {
+		defer resp.Body.Close()
 	}
-	req, err := b.constructMessageRequest(m)
 	if err != nil {
 		return nil, err
 	}
 
-	return b.Client.Do(req)
+	var mr MessageResponse
+	err = decodeResponse(resp, &mr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mr, nil
+}
+
+// MessageFromReader posts a message whose content is read from r, ignoring
+// any Content already set on the message.
+//
+// If the content is longer than the bot's MaxContentLength, it is uploaded
+// as a file instead, and the message content is a link to the upload.
+// The rest of r is streamed to the upload rather than read into memory.
+func (b *Bot) MessageFromReader(m Message, r io.Reader) (*MessageResponse, error) {
+	limit := b.maxContentLength()
+
+	// a character is at most utf8.UTFMax bytes, so anything longer than
+	// this is certainly over the limit
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(limit*utf8.UTFMax+1)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) <= limit*utf8.UTFMax && utf8.RuneCount(buf) <= limit {
+		m.Content = string(buf)
+		return b.SendMessage(m)
+	}
+
+	const filename = "message.txt"
+	uri, _, err := b.Upload(filename, io.MultiReader(bytes.NewReader(buf), r))
+	if err != nil {
+		return nil, err
+	}
+
+	m.Content = fileLink(filename, uri)
+	return b.SendMessage(m)
+}
+
+// maxContentLength returns the configured content length limit, or the default.
+func (b *Bot) maxContentLength() int {
+	if b.MaxContentLength > 0 {
+		return b.MaxContentLength
+	}
+	return DefaultMaxContentLength
+}
+
+// PrivateMessage sends a message to the users in the message's Emails or UserIDs.
+func (b *Bot) PrivateMessage(m Message) (*http.Response, error) {
+	return b.PrivateMessageCtx(context.Background(), m)
+}
+
+// PrivateMessageCtx is PrivateMessage, with a context that can cancel the request.
+func (b *Bot) PrivateMessageCtx(ctx context.Context, m Message) (*http.Response, error) {
+	if len(m.Emails) == 0 && len(m.UserIDs) == 0 {
+		return nil, errors.New("there must be at least one recipient")
+	}
+	if len(m.Emails) != 0 && len(m.UserIDs) != 0 {
+		return nil, errors.New("emails and user ids cannot be combined")
+	}
+	return b.sendMessageRequest(ctx, m)
 }
 
 // Respond sends a given message as a response to whatever context from which
 // an EventMessage was received.
 func (b *Bot) Respond(e EventMessage, response string) (*http.Response, error) {
+	return b.RespondCtx(context.Background(), e, response)
+}
+
+// RespondCtx is Respond, with a context that can cancel the request.
+func (b *Bot) RespondCtx(ctx context.Context, e EventMessage, response string) (*http.Response, error) {
 	if response == "" {
 		return nil, errors.New("Message response cannot be blank")
 	}
@@ -119,20 +336,66 @@ func (b *Bot) Respond(e EventMessage, response string) (*http.Response, error) {
 		Content: response,
 	}
 	if m.Topic != "" {
-		return b.Message(m)
+		return b.MessageCtx(ctx, m)
 	}
-	// private message
+	// private message, addressed by user id where the ids are known, since
+	// emails may be hidden, unless the server is too old for ids
 	if m.Stream == "" {
+		if ids := b.privateResponseIDs(e); len(ids) != 0 && !b.legacyServer() {
+			m.UserIDs = ids
+			return b.MessageCtx(ctx, m)
+		}
 		emails, err := b.privateResponseList(e)
 		if err != nil {
 			return nil, err
 		}
 		m.Emails = emails
-		return b.Message(m)
+		return b.MessageCtx(ctx, m)
 	}
 	return nil, fmt.Errorf("EventMessage is not understood: %v\n", e)
 }
 
+// RespondSpoiler responds to an EventMessage with detail hidden in a spoiler
+// block titled summary, which keeps verbose output from cluttering the conversation.
+func (b *Bot) RespondSpoiler(e EventMessage, summary, detail string) (*http.Response, error) {
+	if summary == "" {
+		return nil, errors.New("spoiler summary cannot be blank")
+	}
+	if detail == "" {
+		return nil, errors.New("spoiler detail cannot be blank")
+	}
+	return b.Respond(e, Spoiler(summary, detail))
+}
+
+// RespondQuote responds to an EventMessage like Respond, quoting the
+// message's content above the response, so the reply keeps its context.
+func (b *Bot) RespondQuote(e EventMessage, response string) (*http.Response, error) {
+	if response == "" {
+		return nil, errors.New("Message response cannot be blank")
+	}
+	return b.Respond(e, Quote(e.SenderFullName, e.Content)+"\n"+response)
+}
+
+// privateResponseIDs returns the ids of the other users in a private
+// conversation, or nil if any of them are unknown. A conversation with only
+// the sender listed goes to the sender.
+func (b *Bot) privateResponseIDs(e EventMessage) []int {
+	var ids []int
+	for _, u := range e.DisplayRecipient.Users {
+		if u.Email == b.Email {
+			continue
+		}
+		if u.ID == 0 {
+			return nil
+		}
+		ids = append(ids, u.ID)
+	}
+	if len(ids) == 0 && e.SenderID != 0 && e.SenderEmail != b.Email {
+		ids = append(ids, e.SenderID)
+	}
+	return ids
+}
+
 // privateResponseList gets the list of other users in a private multiple
 // message conversation.
 func (b *Bot) privateResponseList(e EventMessage) ([]string, error) {
@@ -149,34 +412,54 @@ func (b *Bot) privateResponseList(e EventMessage) ([]string, error) {
 }
 
 // constructMessageRequest is a helper for simplifying sending a message.
-func (b *Bot) constructMessageRequest(m Message) (*http.Request, error) {
+func (b *Bot) constructMessageRequest(ctx context.Context, m Message) (*http.Request, error) {
 	to := m.Stream
 	mtype := "stream"
 
 	le := len(m.Emails)
-	if le != 0 {
+	if le != 0 || len(m.UserIDs) != 0 {
 		mtype = "private"
 	}
+	if len(m.UserIDs) != 0 {
+		ids, err := json.Marshal(m.UserIDs)
+		if err != nil {
+			return nil, err
+		}
+		to = string(ids)
+	}
 	if le == 1 {
 		to = m.Emails[0]
 	}
 	if le > 1 {
-		to = ""
-		for i, e := range m.Emails {
-			to += e
-			if i != le-1 {
-				to += ","
-			}
+		// a json array, so emails containing commas can't be split apart
+		emails, err := json.Marshal(m.Emails)
+		if err != nil {
+			return nil, err
 		}
+		to = string(emails)
 	}
 
 	values := url.Values{}
-	values.Set("type", mtype)
-	values.Set("to", to)
+	if m.RawTo != "" {
+		values.Set("to", m.RawTo)
+		if m.Topic != "" {
+			values.Set(b.topicParam(), m.Topic)
+		}
+	} else {
+		values.Set("type", mtype)
+		values.Set("to", to)
+		if mtype == "stream" {
+			values.Set(b.topicParam(), m.Topic)
+		}
+	}
 	values.Set("content", m.Content)
-	if mtype == "stream" {
-		values.Set("subject", m.Topic)
+	for k, v := range m.Extra {
+		values.Set(k, v)
 	}
 
-	return b.constructRequest("POST", "messages", values.Encode())
+	req, err := b.constructRequest("POST", "messages", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
 }
@@ -1,9 +1,16 @@
 package gozulipbot
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMessage(t *testing.T) {
@@ -23,9 +30,13 @@ func TestPrivateMessage(t *testing.T) {
 		"2": C{M: Message{Stream: "a", Topic: "a", Emails: []string{"a@example.com"}, Content: "hey"}, // topic is ignored
 			Body: "content=hey&to=a%40example.com&type=private", E: nil},
 		"3": C{M: Message{Stream: "a", Topic: "a", Emails: []string{"a@example.com", "b@example.com"}, Content: "hey"}, // multiple emails are fine
-			Body: "content=hey&to=a%40example.com%2Cb%40example.com&type=private", E: nil},
+			Body: "content=hey&to=%5B%22a%40example.com%22%2C%22b%40example.com%22%5D&type=private", E: nil},
 		"4": C{M: Message{Stream: "a", Content: "hey"}, // no email set
 			Body: "", E: errors.New("there must be at least one recipient")},
+		"5": C{M: Message{UserIDs: []int{8, 9}, Content: "hey"}, // user ids
+			Body: "content=hey&to=%5B8%2C9%5D&type=private", E: nil},
+		"6": C{M: Message{Emails: []string{"a@example.com"}, UserIDs: []int{8}, Content: "hey"}, // both
+			Body: "", E: errors.New("emails and user ids cannot be combined")},
 	}
 
 	for num, c := range cases {
@@ -41,16 +52,16 @@ func TestPrivateMessage(t *testing.T) {
 
 		default:
 			if err == nil {
-				t.Fatalf("got nil, expected %q, case %q", err, c.E, num)
+				t.Fatalf("got nil, expected %q, case %q", c.E, num)
 			}
 
 			if err.Error() != c.E.Error() {
 				t.Fatalf("got %q, expected %q, case %q", err, c.E, num)
 			}
 
-			// No request was created so the test has been completed and
-			// we won't check the request body, as there is none.
-			return
+			// No request was created so we won't check the
+			// request body, as there is none.
+			continue
 		}
 
 		// Check the request body matches our expectation
@@ -60,3 +71,365 @@ func TestPrivateMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestMessageFromReader(t *testing.T) {
+	m := Message{Stream: "a", Topic: "b"}
+
+	// inline
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","id":42}`)
+	bot.MaxContentLength = 10
+	mr, err := bot.MessageFromReader(m, strings.NewReader("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.ID != 42 {
+		t.Errorf("got id %d, expected 42", mr.ID)
+	}
+	tc := bot.Client.(*testClient)
+	if len(tc.Requests) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(tc.Requests))
+	}
+	body, _ := ioutil.ReadAll(tc.Request.Body)
+	values, _ := url.ParseQuery(string(body))
+	if values.Get("content") != "short" {
+		t.Errorf("got content %q, expected %q", values.Get("content"), "short")
+	}
+
+	// upload
+	bot = getTestBotWithResponses(
+		`{"result":"success","msg":"","uri":"/user_uploads/1/ab/message.txt"}`,
+		`{"result":"success","msg":"","id":43}`)
+	bot.MaxContentLength = 10
+	long := strings.Repeat("0123456789", 20)
+	mr, err = bot.MessageFromReader(m, strings.NewReader(long))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.ID != 43 {
+		t.Errorf("got id %d, expected 43", mr.ID)
+	}
+	tc = bot.Client.(*testClient)
+	if len(tc.Requests) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(tc.Requests))
+	}
+	upload := tc.Requests[0]
+	if upload.URL.Path != "/v1/user_uploads" {
+		t.Errorf("got upload path %q", upload.URL.Path)
+	}
+	if err := upload.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	f, _, err := upload.FormFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, _ := ioutil.ReadAll(f)
+	if string(uploaded) != long {
+		t.Errorf("uploaded %d bytes, expected %d", len(uploaded), len(long))
+	}
+	body, _ = ioutil.ReadAll(tc.Request.Body)
+	values, _ = url.ParseQuery(string(body))
+	if values.Get("content") != "[message.txt](/user_uploads/1/ab/message.txt)" {
+		t.Errorf("got content %q", values.Get("content"))
+	}
+}
+
+func TestSubjectLinks(t *testing.T) {
+	type C struct {
+		JSON     string
+		Expected []SubjectLink
+	}
+	cases := map[string]C{
+		"objects": C{JSON: `{"subject_links":[{"text":"#123","url":"https://example.com/issues/123"}]}`,
+			Expected: []SubjectLink{{URL: "https://example.com/issues/123", Text: "#123"}}},
+		"strings": C{JSON: `{"subject_links":["https://example.com/issues/123"]}`,
+			Expected: []SubjectLink{{URL: "https://example.com/issues/123", Text: "https://example.com/issues/123"}}},
+		"empty":   C{JSON: `{"subject_links":[]}`, Expected: []SubjectLink{}},
+		"missing": C{JSON: `{}`, Expected: nil},
+	}
+
+	for name, c := range cases {
+		var e EventMessage
+		if err := json.Unmarshal([]byte(c.JSON), &e); err != nil {
+			t.Fatalf("got %q, case %q", err, name)
+		}
+		if !reflect.DeepEqual(e.SubjectLinks, c.Expected) {
+			t.Errorf("got %v, expected %v, case %q", e.SubjectLinks, c.Expected, name)
+		}
+	}
+}
+
+func TestRespondSpoiler(t *testing.T) {
+	bot := getTestBot()
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Topic: "ops"}, Subject: "deploys"}
+
+	if _, err := bot.RespondSpoiler(e, "", "detail"); err == nil {
+		t.Error("expected an error for an empty summary")
+	}
+	if _, err := bot.RespondSpoiler(e, "summary", ""); err == nil {
+		t.Error("expected an error for an empty detail")
+	}
+
+	_, err := bot.RespondSpoiler(e, "Query results", "a | b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	values, _ := url.ParseQuery(string(body))
+	if values.Get("content") != "```spoiler Query results\na | b\n```" {
+		t.Errorf("got content %q", values.Get("content"))
+	}
+	if values.Get("to") != "ops" || values.Get("topic") != "deploys" {
+		t.Errorf("got %v, expected a reply in the same topic", values)
+	}
+}
+
+func TestParticipantCount(t *testing.T) {
+	bot, other, third := User{Email: "testbot@example.com"}, User{Email: "a@example.com"}, User{Email: "b@example.com"}
+	type C struct {
+		E     EventMessage
+		Count int
+		Group bool
+	}
+	cases := map[string]C{
+		"stream": C{E: EventMessage{Type: "stream", DisplayRecipient: DisplayRecipient{Topic: "general"}}},
+		"1:1": C{E: EventMessage{Type: "private", DisplayRecipient: DisplayRecipient{Users: []User{bot, other}}},
+			Count: 2},
+		"group": C{E: EventMessage{Type: "private", DisplayRecipient: DisplayRecipient{Users: []User{bot, other, third}}},
+			Count: 3, Group: true},
+	}
+
+	for name, c := range cases {
+		if got := c.E.ParticipantCount(); got != c.Count {
+			t.Errorf("got count %d, expected %d, case %q", got, c.Count, name)
+		}
+		if got := c.E.IsGroupDM(); got != c.Group {
+			t.Errorf("got group %v, expected %v, case %q", got, c.Group, name)
+		}
+	}
+}
+
+func TestMessageRawTo(t *testing.T) {
+	bot := getTestBot()
+	type C struct {
+		M    Message
+		Body string
+		E    string
+	}
+	cases := map[string]C{
+		"raw": C{M: Message{RawTo: "[8,9]", Content: "hi", Extra: map[string]string{"type": "direct"}},
+			Body: "content=hi&to=%5B8%2C9%5D&type=direct"},
+		"raw with topic": C{M: Message{RawTo: "5", Topic: "t", Content: "hi", Extra: map[string]string{"type": "channel"}},
+			Body: "content=hi&to=5&topic=t&type=channel"},
+		"no type": C{M: Message{RawTo: "[8]", Content: "hi"},
+			E: "a message with RawTo must set its type in Extra"},
+		"ambiguous": C{M: Message{RawTo: "[8]", Stream: "a", Content: "hi", Extra: map[string]string{"type": "direct"}},
+			E: "RawTo cannot be combined with a stream, emails or user ids"},
+		"extra": C{M: Message{Stream: "a", Topic: "b", Content: "hi", Extra: map[string]string{"read_by_sender": "true"}},
+			Body: "content=hi&read_by_sender=true&to=a&topic=b&type=stream"},
+	}
+
+	for name, c := range cases {
+		_, err := bot.Message(c.M)
+		if c.E != "" {
+			if err == nil || err.Error() != c.E {
+				t.Errorf("got %v, expected %q, case %q", err, c.E, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, case %q", err, name)
+		}
+		body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+		if string(body) != c.Body {
+			t.Errorf("got %q, expected %q, case %q", string(body), c.Body, name)
+		}
+	}
+}
+
+func TestMessageZulipError(t *testing.T) {
+	bot := getTestBot()
+	bot.Client.(*testClient).Response = jsonResponse(400,
+		`{"result":"error","msg":"Stream 'nope' does not exist","code":"STREAM_DOES_NOT_EXIST","stream":"nope"}`)
+
+	resp, err := bot.Message(Message{Stream: "nope", Topic: "a", Content: "hi"})
+	ze, ok := err.(*ZulipError)
+	if !ok {
+		t.Fatalf("got %v, expected a *ZulipError", err)
+	}
+	if ze.Code != "STREAM_DOES_NOT_EXIST" || ze.HTTPStatus != 400 || ze.Msg != "Stream 'nope' does not exist" {
+		t.Errorf("got %+v", ze)
+	}
+	if resp == nil {
+		t.Fatal("expected the response to be returned with the error")
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "STREAM_DOES_NOT_EXIST") {
+		t.Errorf("expected the response body to still be readable, got %q", string(body))
+	}
+}
+
+// contextClient waits for the request's context to be done.
+type contextClient struct{}
+
+func (contextClient) Do(r *http.Request) (*http.Response, error) {
+	<-r.Context().Done()
+	return nil, errors.New("request canceled")
+}
+
+func TestMessageCtx(t *testing.T) {
+	bot := getTestBot()
+	bot.Client = contextClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := bot.MessageCtx(ctx, Message{Stream: "a", Topic: "b", Content: "hi"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected an error wrapping %v", err, context.Canceled)
+	}
+
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Topic: "a"}, Subject: "b"}
+	_, err = bot.RespondCtx(ctx, e, "hi")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected an error wrapping %v", err, context.Canceled)
+	}
+}
+
+func TestPrivateMessageRecipients(t *testing.T) {
+	bot := getTestBot()
+	emails := []string{"a@example.com", "b@example.com", "c,d@example.com"}
+
+	_, err := bot.PrivateMessage(Message{Emails: emails, Content: "hey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to []string
+	err = json.Unmarshal([]byte(values.Get("to")), &to)
+	if err != nil {
+		t.Fatalf("got %q, expected a json array, error %v", values.Get("to"), err)
+	}
+	if !reflect.DeepEqual(to, emails) {
+		t.Errorf("got %q, expected %q", to, emails)
+	}
+}
+
+func TestEventMessageTime(t *testing.T) {
+	type C struct {
+		Timestamp int
+		Expected  time.Time
+	}
+
+	cases := map[string]C{
+		"known": C{Timestamp: 1700000000, Expected: time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC)},
+		"zero":  C{Timestamp: 0, Expected: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for k, c := range cases {
+		got := EventMessage{Timestamp: c.Timestamp}.Time()
+		if !got.Equal(c.Expected) || got.Location() != time.UTC {
+			t.Errorf("got %v, expected %v, case %q", got, c.Expected, k)
+		}
+	}
+}
+
+func TestRespondQuote(t *testing.T) {
+	bot := getTestBot()
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Topic: "ops"}, Subject: "deploys",
+		SenderFullName: "Someone", Content: "is it up?\n```\nping\n```"}
+
+	if _, err := bot.RespondQuote(e, ""); err == nil {
+		t.Error("expected an error for an empty response")
+	}
+
+	_, err := bot.RespondQuote(e, "it is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	values, _ := url.ParseQuery(string(body))
+	expected := "@_**Someone** said:\n````quote\nis it up?\n```\nping\n```\n````\nit is"
+	if values.Get("content") != expected {
+		t.Errorf("got content %q, expected %q", values.Get("content"), expected)
+	}
+	if values.Get("to") != "ops" || values.Get("topic") != "deploys" {
+		t.Errorf("got %v, expected a reply in the same topic", values)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","id":42}`,
+		`{"result":"error","msg":"Stream 'b' does not exist","code":"STREAM_DOES_NOT_EXIST"}`,
+		`{"result":"success","msg":"","id":43}`,
+	)
+
+	mr, err := bot.SendMessage(Message{Stream: "a", Topic: "b", Content: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.ID != 42 {
+		t.Errorf("got id %d, expected 42", mr.ID)
+	}
+
+	_, err = bot.SendMessage(Message{Stream: "b", Topic: "b", Content: "hi"})
+	if ze, ok := err.(*ZulipError); !ok || ze.Code != "STREAM_DOES_NOT_EXIST" {
+		t.Errorf("got %v, expected a *ZulipError", err)
+	}
+
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Topic: "a"}, Subject: "b"}
+	mr, err = bot.Reply(e, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.ID != 43 {
+		t.Errorf("got id %d, expected 43", mr.ID)
+	}
+}
+
+func TestRespondPrivate(t *testing.T) {
+	type C struct {
+		E  EventMessage
+		To string
+	}
+
+	cases := map[string]C{
+		"ids": C{
+			E: EventMessage{SenderID: 8, SenderEmail: "user8@example.com", DisplayRecipient: DisplayRecipient{Users: []User{
+				{Email: "testbot@example.com", ID: 1}, {Email: "user8@example.com", ID: 8}, {Email: "user9@example.com", ID: 9},
+			}}},
+			To: "[8,9]",
+		},
+		"hidden emails": C{
+			E: EventMessage{SenderID: 8, SenderEmail: "user8@zulip.example.com", DisplayRecipient: DisplayRecipient{Users: []User{
+				{Email: "user8@zulip.example.com", ID: 8},
+			}}},
+			To: "[8]",
+		},
+		"no ids": C{
+			E: EventMessage{DisplayRecipient: DisplayRecipient{Users: []User{
+				{Email: "testbot@example.com"}, {Email: "a@example.com"},
+			}}},
+			To: "a@example.com",
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		if _, err := bot.Respond(c.E, "hi"); err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+		req := bot.Client.(*testClient).Request
+		req.ParseForm()
+		if got := req.PostForm.Get("to"); got != c.To || req.PostForm.Get("type") != "private" {
+			t.Errorf("got %v, expected a private message to %q, case %q", req.PostForm, c.To, k)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package gozulipbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Metrics receives counts and timings of what the bot does, to export to a
+// monitoring system such as Prometheus. Endpoints have numeric ids replaced
+// with ":id", as for OnRequest, so they can be used as labels. The methods
+// are called from the goroutines making requests, so they must be safe for
+// concurrent use.
+type Metrics interface {
+	// EventReceived is called for each event received from a queue,
+	// including heartbeats, with the event's type.
+	EventReceived(eventType string)
+	// MessageSent is called for each message the bot sends.
+	MessageSent()
+	// APIError is called for each error response from Zulip, with its
+	// error code, or "" if it has none.
+	APIError(endpoint, code string)
+	// Retry is called each time a rate limited request is retried.
+	Retry(endpoint string)
+	// RequestDuration is called after every request, with how long it took
+	// and its status code, or 0 if there was no response.
+	RequestDuration(endpoint, method string, d time.Duration, status int)
+}
+
+// NopMetrics is a Metrics that discards everything. It is used when the bot
+// has no Metrics.
+type NopMetrics struct{}
+
+func (NopMetrics) EventReceived(string)                               {}
+func (NopMetrics) MessageSent()                                       {}
+func (NopMetrics) APIError(string, string)                            {}
+func (NopMetrics) Retry(string)                                       {}
+func (NopMetrics) RequestDuration(string, string, time.Duration, int) {}
+
+// metrics returns the bot's Metrics, or NopMetrics if it has none.
+func (b *Bot) metrics() Metrics {
+	if b.Metrics == nil {
+		return NopMetrics{}
+	}
+	return b.Metrics
+}
+
+// recordResponse reports a request's duration, and, if the response is an
+// error, the error's code, to the bot's Metrics. An error response's body is
+// read to find its code, and left intact.
+func (b *Bot) recordResponse(req *http.Request, resp *http.Response, d time.Duration) {
+	if b.Metrics == nil {
+		return
+	}
+
+	endpoint := b.requestEndpoint(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	b.Metrics.RequestDuration(endpoint, req.Method, d, status)
+	if status < 400 {
+		return
+	}
+
+	var result struct {
+		Code string `json:"code"`
+	}
+	if resp.Body != nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err == nil {
+			json.Unmarshal(body, &result)
+		}
+	}
+	b.Metrics.APIError(endpoint, result.Code)
+}
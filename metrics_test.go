@@ -0,0 +1,75 @@
+package gozulipbot
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records the metrics it receives, as strings.
+type recordingMetrics struct {
+	calls []string
+}
+
+func (m *recordingMetrics) EventReceived(t string) { m.calls = append(m.calls, "event "+t) }
+func (m *recordingMetrics) MessageSent()           { m.calls = append(m.calls, "sent") }
+func (m *recordingMetrics) APIError(endpoint, code string) {
+	m.calls = append(m.calls, "error "+endpoint+" "+code)
+}
+func (m *recordingMetrics) Retry(endpoint string) { m.calls = append(m.calls, "retry "+endpoint) }
+func (m *recordingMetrics) RequestDuration(endpoint, method string, d time.Duration, status int) {
+	m.calls = append(m.calls, "request "+method+" "+endpoint)
+}
+
+func TestMetrics(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}},{"id":1,"type":"heartbeat"}]}`,
+		`{"result":"success","msg":"","id":2}`,
+	)
+	tc := bot.Client.(*testClient)
+	tc.Responses = append(tc.Responses,
+		jsonResponse(400, `{"result":"error","msg":"Stream 'nowhere' does not exist","code":"STREAM_DOES_NOT_EXIST"}`))
+	m := &recordingMetrics{}
+	bot.Metrics = m
+
+	q := &Queue{Bot: bot, ID: "q1"}
+	if _, err := q.GetEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.Message(Message{Stream: "ops", Topic: "t", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := bot.Message(Message{Stream: "nowhere", Topic: "t", Content: "hi"})
+	if err == nil || err.Error() != "Stream 'nowhere' does not exist" {
+		t.Errorf("got %v, expected the error to still be read from the body", err)
+	}
+
+	expected := []string{
+		"request GET events", "event message", "event heartbeat",
+		"request POST messages", "sent",
+		"request POST messages", "error messages STREAM_DOES_NOT_EXIST",
+	}
+	if !reflect.DeepEqual(m.calls, expected) {
+		t.Errorf("got %q, expected %q", m.calls, expected)
+	}
+}
+
+func TestMetricsRetry(t *testing.T) {
+	bot := getTestBot()
+	bot.Client.(*testClient).Responses = []*http.Response{rateLimited(), jsonResponse(200, `{"result":"success","msg":""}`)}
+	bot.Retry = RetryConfig{MaxAttempts: 2}
+	m := &recordingMetrics{}
+	bot.Metrics = m
+
+	if _, err := bot.DeleteMessage(5); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"request DELETE messages/:id", "error messages/:id RATE_LIMIT_HIT",
+		"retry messages/:id", "request DELETE messages/:id",
+	}
+	if !reflect.DeepEqual(m.calls, expected) {
+		t.Errorf("got %q, expected %q", m.calls, expected)
+	}
+}
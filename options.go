@@ -0,0 +1,78 @@
+package gozulipbot
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// An Option configures the http client Init gives the bot.
+type Option func(*clientOptions)
+
+// clientOptions are the settings gathered from the Options given to Init.
+type clientOptions struct {
+	timeout   time.Duration
+	proxy     *url.URL
+	tls       *tls.Config
+	transport http.RoundTripper
+	userAgent string
+}
+
+// WithTimeout limits how long each request may take, including reading the
+// response body. Long polls for events take up to a minute and a half, so the
+// timeout should be longer than that.
+func WithTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.timeout = d }
+}
+
+// WithProxy sends requests through the proxy at proxyURL, such as
+// "http://proxy.example.com:3128". By default, the proxy in the environment's
+// HTTPS_PROXY or HTTP_PROXY is used.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(o *clientOptions) { o.proxy = proxyURL }
+}
+
+// WithTLSConfig sets the tls configuration used to connect to the server,
+// such as to trust a self-hosted server's own certificate authority.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *clientOptions) { o.tls = config }
+}
+
+// WithTransport sends requests with the given transport. WithProxy and
+// WithTLSConfig can only be combined with it if it is an *http.Transport,
+// which they modify a copy of.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *clientOptions) { o.transport = rt }
+}
+
+// WithUserAgent sets the User-Agent header of the bot's requests.
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) { o.userAgent = userAgent }
+}
+
+// newClient returns an http client configured by the options.
+func (o *clientOptions) newClient() (*http.Client, error) {
+	rt := o.transport
+	if o.proxy != nil || o.tls != nil {
+		base := http.DefaultTransport
+		if rt != nil {
+			base = rt
+		}
+		t, ok := base.(*http.Transport)
+		if !ok {
+			return nil, errors.New("a proxy or tls config needs the transport to be an *http.Transport")
+		}
+		t = t.Clone()
+		if o.proxy != nil {
+			t.Proxy = http.ProxyURL(o.proxy)
+		}
+		if o.tls != nil {
+			t.TLSClientConfig = o.tls
+		}
+		rt = t
+	}
+
+	return &http.Client{Transport: rt, Timeout: o.timeout}, nil
+}
@@ -0,0 +1,58 @@
+package gozulipbot
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type roundTripper struct{}
+
+func (roundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, nil
+}
+
+func TestInitOptions(t *testing.T) {
+	proxy, _ := url.Parse("http://proxy.example.com:3128")
+	config := &tls.Config{ServerName: "zulip.internal"}
+
+	bot := &Bot{}
+	_, err := bot.Init(WithTimeout(2*time.Minute), WithProxy(proxy), WithTLSConfig(config), WithUserAgent("deploybot/1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := bot.Client.(*http.Client)
+	if client.Timeout != 2*time.Minute {
+		t.Errorf("got timeout %v, expected %v", client.Timeout, 2*time.Minute)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig != config {
+		t.Error("expected the tls config to be set")
+	}
+	if transport == http.DefaultTransport {
+		t.Error("expected the default transport to be copied, not changed")
+	}
+	got, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.zulip.com"}})
+	if err != nil || got.String() != proxy.String() {
+		t.Errorf("got proxy %v, expected %v", got, proxy)
+	}
+
+	req, err := bot.constructRequest("GET", "users/me", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua := req.Header.Get("User-Agent"); ua != "deploybot/1.0" {
+		t.Errorf("got user agent %q, expected %q", ua, "deploybot/1.0")
+	}
+
+	rt := roundTripper{}
+	if _, err := (&Bot{}).Init(WithTransport(rt)); err != nil {
+		t.Errorf("got %q, expected a custom transport alone to work", err)
+	}
+	if _, err := (&Bot{}).Init(WithTransport(rt), WithProxy(proxy)); err == nil {
+		t.Error("expected an error combining a proxy with a custom transport")
+	}
+}
@@ -0,0 +1,264 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultPoolWorkers is the number of goroutines a Pool handles messages
+// with when its Workers is 0.
+const DefaultPoolWorkers = 4
+
+// A Pool runs several bots in one process, such as bots on different realms,
+// or with different credentials, bridging several Zulip instances. The bots'
+// messages are handled by one set of workers, with handlers shared by every
+// bot:
+//
+//	p := gozulipbot.NewPool(ops, support)
+//	p.Command("status", statusCommand)
+//	if err := p.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer p.Stop(context.Background())
+//
+// Each bot polls its own queue, like OnMessage, and the error it stops with
+// is sent on its Errors channel.
+type Pool struct {
+	// Workers is the number of goroutines handling messages, shared by all
+	// the bots. If it is 0, DefaultPoolWorkers is used.
+	Workers int
+
+	mu       sync.Mutex
+	bots     []*Bot
+	errs     map[*Bot]chan error
+	handler  func(*Bot, EventMessage)
+	commands []poolCommand
+
+	// ran is set once the pool has been started, so a restart makes new
+	// error channels in place of the closed ones
+	ran bool
+
+	// set while the pool runs
+	run *poolRun
+}
+
+// poolRun is the state of one run of a pool, from Start to Stop, so the
+// goroutines of a run Stop is still waiting on aren't mixed up with those of
+// the next run.
+type poolRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan poolJob
+	loops  sync.WaitGroup
+	work   sync.WaitGroup
+}
+
+type poolCommand struct {
+	name string
+	h    CommandHandler
+}
+
+// poolJob is a message for a worker to handle, with the bot that received it.
+type poolJob struct {
+	bot *Bot
+	msg EventMessage
+}
+
+// NewPool returns a pool of the given bots.
+func NewPool(bots ...*Bot) *Pool {
+	p := &Pool{errs: map[*Bot]chan error{}}
+	for _, b := range bots {
+		p.Add(b)
+	}
+	return p
+}
+
+// Add adds a bot to the pool, with the pool's commands. If the pool is
+// running, the bot is started. Adding a bot twice has no effect.
+func (p *Pool) Add(b *Bot) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.errs[b]; ok {
+		return
+	}
+	p.bots = append(p.bots, b)
+	p.errs[b] = make(chan error, 1)
+	for _, c := range p.commands {
+		b.Command(c.name, c.h)
+	}
+	if p.run != nil {
+		p.startBot(p.run, b)
+	}
+}
+
+// Bots returns the bots in the pool.
+func (p *Pool) Bots() []*Bot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Bot(nil), p.bots...)
+}
+
+// Errors returns the channel the error a bot stopped with is sent on, such
+// as failing to register its queue with bad credentials. The channel is
+// closed once the bot has stopped; a bot stopped by Stop sends no error.
+// Each run of the pool has new channels, so Errors is called again after
+// restarting it. It returns nil for a bot that isn't in the pool.
+func (p *Pool) Errors(b *Bot) <-chan error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch, ok := p.errs[b]; ok {
+		return ch
+	}
+	return nil
+}
+
+// OnMessage sets the handler for every bot's messages. It is called with the
+// bot that received the message. Without a handler, messages are routed to
+// the bots' commands, with HandleCommand. It can be called while the pool
+// runs, and applies from the next message.
+func (p *Pool) OnMessage(h func(*Bot, EventMessage)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handler = h
+}
+
+// Command registers the handler for a command on every bot's router,
+// including bots added later.
+func (p *Pool) Command(name string, h CommandHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands = append(p.commands, poolCommand{name: name, h: h})
+	for _, b := range p.bots {
+		b.Command(name, h)
+	}
+}
+
+// Start starts the pool's workers, and each bot's polling loop. The loops
+// run until the context is done or Stop is called, and the workers until
+// Stop is called. It returns an error if the pool is already running.
+func (p *Pool) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.run != nil {
+		return errors.New("pool is already running")
+	}
+
+	if p.ran {
+		for _, b := range p.bots {
+			p.errs[b] = make(chan error, 1)
+		}
+	}
+	p.ran = true
+	run := &poolRun{jobs: make(chan poolJob)}
+	run.ctx, run.cancel = context.WithCancel(ctx)
+	p.run = run
+	workers := p.Workers
+	if workers < 1 {
+		workers = DefaultPoolWorkers
+	}
+	for i := 0; i < workers; i++ {
+		run.work.Add(1)
+		go p.worker(run)
+	}
+	for _, b := range p.bots {
+		p.startBot(run, b)
+	}
+	return nil
+}
+
+// startBot starts a bot's polling loop for the run. It is called with p.mu
+// held.
+func (p *Pool) startBot(run *poolRun, b *Bot) {
+	errs := p.errs[b]
+	run.loops.Add(1)
+	go func() {
+		defer run.loops.Done()
+		defer close(errs)
+		err := b.poolLoop(run.ctx, run.jobs)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			errs <- err
+		}
+	}()
+}
+
+// poolLoop polls a queue for the bot's messages, and sends them to the
+// pool's workers, until the context is done.
+func (b *Bot) poolLoop(ctx context.Context, jobs chan<- poolJob) error {
+	ctx, finish := b.startLoop(ctx)
+	defer finish()
+
+	q, err := b.RegisterEventsCtx(ctx, nil, "")
+	if err != nil {
+		return err
+	}
+	defer b.closeQueue(context.Background(), q)
+
+	return b.pollQueue(ctx, q, func(m EventMessage) error {
+		select {
+		case jobs <- poolJob{bot: b, msg: m}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// worker handles the run's messages until its jobs channel is closed, with
+// the pool's handler at the time each message arrives.
+func (p *Pool) worker(run *poolRun) {
+	defer run.work.Done()
+	for j := range run.jobs {
+		p.mu.Lock()
+		handler := p.handler
+		p.mu.Unlock()
+		if handler == nil {
+			handler = func(b *Bot, e EventMessage) { b.HandleCommand(e) }
+		}
+		j.bot.handleMessage(handler, j.msg)
+	}
+}
+
+// Stop stops every bot in the pool, as Bot.Stop does, and waits for the
+// workers to finish the messages they are handling. The pool can be started
+// again afterwards. If the context is done first, Stop returns its error,
+// and the bots go on stopping in the background; otherwise the first error
+// stopping a bot is returned.
+func (p *Pool) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	run := p.run
+	if run == nil {
+		p.mu.Unlock()
+		return nil
+	}
+	run.cancel()
+	bots := append([]*Bot(nil), p.bots...)
+	p.run = nil
+	p.mu.Unlock()
+
+	errs := make(chan error, len(bots))
+	for _, b := range bots {
+		go func(b *Bot) { errs <- b.Stop(ctx) }(b)
+	}
+	var firstErr error
+	for range bots {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		run.loops.Wait()
+		close(run.jobs)
+		run.work.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return firstErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
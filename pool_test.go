@@ -0,0 +1,152 @@
+package gozulipbot
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	newBot := func(email string, responses ...string) *Bot {
+		b := getTestBotWithResponses(responses...)
+		b.Email = email
+		b.Client = waitingClient{b.Client.(*testClient)}
+		return b
+	}
+	ops := newBot("ops-bot@example.com",
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`,
+	)
+	support := newBot("support-bot@example.com",
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":2}}]}`,
+	)
+	broken := newBot("broken-bot@example.com",
+		`{"result":"error","msg":"Invalid API key","code":"INVALID_API_KEY"}`,
+	)
+
+	p := NewPool(ops, support, broken)
+	p.Workers = 2
+	handled := make(chan string, 2)
+	p.OnMessage(func(b *Bot, e EventMessage) {
+		handled <- b.Email
+	})
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(context.Background()); err == nil {
+		t.Error("expected an error starting a running pool")
+	}
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case email := <-handled:
+			got = append(got, email)
+		case <-time.After(time.Second):
+			t.Fatalf("got messages for %q, expected one for each working bot", got)
+		}
+	}
+	sort.Strings(got)
+	if got[0] != "ops-bot@example.com" || got[1] != "support-bot@example.com" {
+		t.Errorf("got messages for %q", got)
+	}
+
+	select {
+	case err := <-p.Errors(broken):
+		if err == nil || err.Error() != "Invalid API key" {
+			t.Errorf("got %v, expected the registration error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the broken bot's error")
+	}
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := <-p.Errors(ops); ok {
+		t.Errorf("got %v, expected no error from a stopped bot", err)
+	}
+	if len(ops.Queues) != 0 || len(support.Queues) != 0 {
+		t.Error("expected the bots' queues to be deleted")
+	}
+}
+
+func TestPoolCommands(t *testing.T) {
+	p := NewPool(getTestBot())
+	ran := 0
+	p.Command("ping", func(e EventMessage, args []string) { ran++ })
+
+	late := getTestBot()
+	p.Add(late)
+	p.Add(late)
+	if n := len(p.Bots()); n != 2 {
+		t.Errorf("got %d bots, expected 2", n)
+	}
+
+	for _, b := range p.Bots() {
+		if !b.HandleCommand(EventMessage{Content: "ping"}) {
+			t.Error("expected every bot to have the pool's command")
+		}
+	}
+	if ran != 2 {
+		t.Errorf("got %d runs, expected 2", ran)
+	}
+	if p.Errors(getTestBot()) != nil {
+		t.Error("expected no errors channel for a bot outside the pool")
+	}
+}
+
+func TestPoolRestartAndLateHandler(t *testing.T) {
+	release := make(chan struct{})
+	b := getTestBot()
+	b.Client = DoerFunc(func(r *http.Request) (*http.Response, error) {
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/register"):
+			return jsonResponse(200, `{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`), nil
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/events") && r.URL.Query().Get("last_event_id") == "-1":
+			select {
+			case <-release:
+				return jsonResponse(200, `{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`), nil
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			}
+		case r.Method == "GET":
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		}
+		return jsonResponse(200, `{"result":"success","msg":""}`), nil
+	})
+
+	p := NewPool(b)
+	for i := 0; i < 2; i++ {
+		if err := p.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan int, 1)
+	p.OnMessage(func(b *Bot, e EventMessage) { handled <- e.ID })
+	close(release)
+	select {
+	case id := <-handled:
+		if id != 1 {
+			t.Errorf("got message %d, expected 1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler set after Start to be called")
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,65 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Presence statuses, for UpdatePresence.
+const (
+	PresenceActive = "active"
+	PresenceIdle   = "idle"
+)
+
+// UpdatePresence reports the bot's presence to Zulip, which shows it to
+// users. status must be PresenceActive or PresenceIdle. Zulip shows a user
+// as offline once it hasn't heard from them for a couple of minutes, so the
+// presence must be reported regularly; set PresenceInterval to have the
+// bot's polling loops do so.
+func (b *Bot) UpdatePresence(status string) (*http.Response, error) {
+	if status != PresenceActive && status != PresenceIdle {
+		return nil, errors.New(`presence status must be "active" or "idle"`)
+	}
+
+	values := url.Values{}
+	values.Set("status", status)
+
+	req, err := b.constructRequest("POST", "users/me/presence", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// startPresence starts reporting the bot as active every PresenceInterval,
+// if it is set and the bot isn't already doing so. It is called with b.mu
+// held, as a polling loop starts.
+func (b *Bot) startPresence() {
+	if b.PresenceInterval <= 0 || b.stopPresence != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.stopPresence = cancel
+	interval := b.PresenceInterval
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			// failures are ignored, and the next tick tries again
+			resp, _ := b.UpdatePresence(PresenceActive)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
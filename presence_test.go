@@ -0,0 +1,61 @@
+package gozulipbot
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdatePresence(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","presences":{}}`)
+
+	if _, err := bot.UpdatePresence("away"); err == nil {
+		t.Error("expected an error for an invalid status")
+	}
+
+	_, err := bot.UpdatePresence(PresenceActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	req.ParseForm()
+	if req.Method != "POST" || req.URL.Path != "/v1/users/me/presence" || req.PostForm.Get("status") != "active" {
+		t.Errorf("got %s %s %q", req.Method, req.URL.Path, req.PostForm.Encode())
+	}
+}
+
+func TestPresenceInterval(t *testing.T) {
+	var mu sync.Mutex
+	reports := 0
+	bot := getTestBot()
+	bot.PresenceInterval = 5 * time.Millisecond
+	bot.Client = DoerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		reports++
+		mu.Unlock()
+		return jsonResponse(200, `{"result":"success","msg":""}`), nil
+	})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return reports
+	}
+
+	_, finish1 := bot.startLoop(context.Background())
+	_, finish2 := bot.startLoop(context.Background())
+	time.Sleep(30 * time.Millisecond)
+	finish1()
+	if count() < 2 {
+		t.Errorf("got %d presence reports, expected them to repeat while loops run", count())
+	}
+
+	finish2()
+	time.Sleep(10 * time.Millisecond)
+	stopped := count()
+	time.Sleep(20 * time.Millisecond)
+	if count() != stopped {
+		t.Error("expected presence reports to stop once every loop finished")
+	}
+}
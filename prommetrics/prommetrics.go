@@ -0,0 +1,223 @@
+// Package prommetrics collects a bot's metrics, and serves them in the
+// Prometheus text exposition format, for Prometheus to scrape. It doesn't
+// depend on the Prometheus client library; bots that already use it can
+// implement gozulipbot.Metrics with their own collectors instead.
+//
+//	m := prommetrics.New("zulipbot")
+//	bot.Metrics = m
+//	http.Handle("/metrics", m)
+package prommetrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gzb "github.com/ifo/gozulipbot"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the request duration
+// histogram's buckets. They reach past the minute or so a request for events
+// waits before Zulip sends a heartbeat.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// Metrics is a gozulipbot.Metrics that keeps its counts in memory, and
+// serves them over http. Its methods are safe for concurrent use.
+type Metrics struct {
+	namespace string
+	buckets   []float64
+
+	mu        sync.Mutex
+	events    map[string]float64
+	sent      float64
+	apiErrors map[[2]string]float64
+	retries   map[string]float64
+	durations map[[2]string]*histogram
+}
+
+var _ gzb.Metrics = (*Metrics)(nil)
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// New returns Metrics whose metric names start with namespace, such as
+// "zulipbot_messages_sent_total". Requests are timed in DefaultBuckets.
+func New(namespace string) *Metrics {
+	return NewWithBuckets(namespace, DefaultBuckets)
+}
+
+// NewWithBuckets is New, with the upper bounds of the request duration
+// histogram's buckets, in seconds, in increasing order.
+func NewWithBuckets(namespace string, buckets []float64) *Metrics {
+	return &Metrics{
+		namespace: namespace,
+		buckets:   append([]float64(nil), buckets...),
+		events:    map[string]float64{},
+		apiErrors: map[[2]string]float64{},
+		retries:   map[string]float64{},
+		durations: map[[2]string]*histogram{},
+	}
+}
+
+// EventReceived counts an event received from a queue.
+func (m *Metrics) EventReceived(eventType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[eventType]++
+}
+
+// MessageSent counts a message the bot sent.
+func (m *Metrics) MessageSent() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent++
+}
+
+// APIError counts an error response from Zulip.
+func (m *Metrics) APIError(endpoint, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiErrors[[2]string{endpoint, code}]++
+}
+
+// Retry counts a retried request.
+func (m *Metrics) Retry(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[endpoint]++
+}
+
+// RequestDuration adds a request's duration to the histogram for its
+// endpoint and method.
+func (m *Metrics) RequestDuration(endpoint, method string, d time.Duration, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{endpoint, method}
+	h := m.durations[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[key] = h
+	}
+	secs := d.Seconds()
+	for i, le := range m.buckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+// write writes the metrics, each sorted by its labels, so the output is the
+// same for the same counts.
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := m.name("events_received_total")
+	header(w, name, "counter", "Events received from the bot's queues, by type.")
+	for _, t := range sortedKeys(m.events) {
+		fmt.Fprintf(w, "%s{type=%s} %s\n", name, quote(t), number(m.events[t]))
+	}
+
+	name = m.name("messages_sent_total")
+	header(w, name, "counter", "Messages sent by the bot.")
+	fmt.Fprintf(w, "%s %s\n", name, number(m.sent))
+
+	name = m.name("api_errors_total")
+	header(w, name, "counter", "Error responses from Zulip, by endpoint and error code.")
+	for _, k := range sortedPairs(m.apiErrors) {
+		fmt.Fprintf(w, "%s{endpoint=%s,code=%s} %s\n", name, quote(k[0]), quote(k[1]), number(m.apiErrors[k]))
+	}
+
+	name = m.name("retries_total")
+	header(w, name, "counter", "Rate limited requests retried, by endpoint.")
+	for _, e := range sortedKeys(m.retries) {
+		fmt.Fprintf(w, "%s{endpoint=%s} %s\n", name, quote(e), number(m.retries[e]))
+	}
+
+	name = m.name("request_duration_seconds")
+	header(w, name, "histogram", "Durations of the bot's requests to Zulip, by endpoint and method.")
+	keys := make([][2]string, 0, len(m.durations))
+	for k := range m.durations {
+		keys = append(keys, k)
+	}
+	sortPairs(keys)
+	for _, k := range keys {
+		h := m.durations[k]
+		labels := "endpoint=" + quote(k[0]) + ",method=" + quote(k[1])
+		for i, le := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, number(le), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, number(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// name returns the metric's name in the namespace.
+func (m *Metrics) name(metric string) string {
+	if m.namespace == "" {
+		return metric
+	}
+	return m.namespace + "_" + metric
+}
+
+func header(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// quote quotes a label value, escaping it as the text format requires.
+func quote(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs(m map[[2]string]float64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortPairs(keys)
+	return keys
+}
+
+func sortPairs(keys [][2]string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+}
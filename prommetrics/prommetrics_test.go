@@ -0,0 +1,99 @@
+package prommetrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gzb "github.com/ifo/gozulipbot"
+	"github.com/ifo/gozulipbot/zuliptest"
+)
+
+func TestServeHTTP(t *testing.T) {
+	m := NewWithBuckets("zulipbot", []float64{0.1, 1})
+	m.EventReceived("message")
+	m.EventReceived("heartbeat")
+	m.EventReceived("message")
+	m.MessageSent()
+	m.APIError("messages", "STREAM_DOES_NOT_EXIST")
+	m.Retry("messages")
+	m.RequestDuration("messages", "POST", 50*time.Millisecond, 200)
+	m.RequestDuration("messages", "POST", 500*time.Millisecond, 400)
+	m.RequestDuration("events", "GET", 2*time.Second, 200)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `# HELP zulipbot_events_received_total Events received from the bot's queues, by type.
+# TYPE zulipbot_events_received_total counter
+zulipbot_events_received_total{type="heartbeat"} 1
+zulipbot_events_received_total{type="message"} 2
+# HELP zulipbot_messages_sent_total Messages sent by the bot.
+# TYPE zulipbot_messages_sent_total counter
+zulipbot_messages_sent_total 1
+# HELP zulipbot_api_errors_total Error responses from Zulip, by endpoint and error code.
+# TYPE zulipbot_api_errors_total counter
+zulipbot_api_errors_total{endpoint="messages",code="STREAM_DOES_NOT_EXIST"} 1
+# HELP zulipbot_retries_total Rate limited requests retried, by endpoint.
+# TYPE zulipbot_retries_total counter
+zulipbot_retries_total{endpoint="messages"} 1
+# HELP zulipbot_request_duration_seconds Durations of the bot's requests to Zulip, by endpoint and method.
+# TYPE zulipbot_request_duration_seconds histogram
+zulipbot_request_duration_seconds_bucket{endpoint="events",method="GET",le="0.1"} 0
+zulipbot_request_duration_seconds_bucket{endpoint="events",method="GET",le="1"} 0
+zulipbot_request_duration_seconds_bucket{endpoint="events",method="GET",le="+Inf"} 1
+zulipbot_request_duration_seconds_sum{endpoint="events",method="GET"} 2
+zulipbot_request_duration_seconds_count{endpoint="events",method="GET"} 1
+zulipbot_request_duration_seconds_bucket{endpoint="messages",method="POST",le="0.1"} 1
+zulipbot_request_duration_seconds_bucket{endpoint="messages",method="POST",le="1"} 2
+zulipbot_request_duration_seconds_bucket{endpoint="messages",method="POST",le="+Inf"} 2
+zulipbot_request_duration_seconds_sum{endpoint="messages",method="POST"} 0.55
+zulipbot_request_duration_seconds_count{endpoint="messages",method="POST"} 2
+`
+	if got := w.Body.String(); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", ct)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestBotMetrics(t *testing.T) {
+	srv := zuliptest.NewServer()
+	defer srv.Close()
+	bot := srv.Bot()
+	m := New("zulipbot")
+	bot.Metrics = m
+
+	q, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.PushMessage(zuliptest.Message{SenderEmail: "user@example.com", Stream: "ops", Topic: "t", Content: "hi"})
+	if _, err := q.GetEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.Message(gzb.Message{Stream: "ops", Topic: "t", Content: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{
+		`zulipbot_events_received_total{type="message"} 1`,
+		`zulipbot_messages_sent_total 1`,
+		`zulipbot_request_duration_seconds_count{endpoint="register",method="POST"} 1`,
+		`zulipbot_request_duration_seconds_count{endpoint="events",method="GET"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, w.Body.String())
+		}
+	}
+}
@@ -1,41 +1,154 @@
 package gozulipbot
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// A Queue is an event queue registered with Zulip. Its methods are safe for
+// concurrent use; requests for events on the same queue are made one at a time.
 type Queue struct {
 	ID           string `json:"queue_id"`
 	LastEventID  int    `json:"last_event_id"`
 	MaxMessageID int    `json:"max_message_id"`
 	Bot          *Bot   `json:"-"`
+
+	opts RegisterOptions
+
+	// noReregister stops an expired queue from being registered again
+	// while polling, so the caller sees the error instead.
+	noReregister bool
+	// reregistered is when the queue was last registered again.
+	reregistered time.Time
+
+	// storeKey is the key the queue is saved by in the bot's QueueStore, if
+	// it is saved there, and savedEventID is the LastEventID last saved.
+	storeKey     string
+	savedEventID int
+
+	// lastEventAt is when the queue was registered, or last received an
+	// event, including a heartbeat.
+	lastEventAt time.Time
+
+	// mu guards the queue's id, position and lastEventAt.
+	mu sync.Mutex
+	// pollMu is held while waiting for events.
+	pollMu sync.Mutex
 }
 
-func (q *Queue) EventsChan() (chan EventMessage, func()) {
-	end := false
-	endFunc := func() {
-		end = true
+// reregisterInterval is the least time between registering a queue again,
+// so a server that keeps rejecting the queue isn't flooded with registrations.
+var reregisterInterval = 10 * time.Second
+
+// register registers a new queue with Zulip with the queue's options,
+// replacing the queue's id and position with the new queue's.
+func (q *Queue) register(ctx context.Context) error {
+	err := q.rawRegister(ctx)
+	if err != nil {
+		q.Bot.logger().Warn("registering queue failed", "error", err)
+		return err
+	}
+	q.save()
+	return nil
+}
+
+// rawRegister is register, without logging.
+func (q *Queue) rawRegister(ctx context.Context) error {
+	resp, err := q.Bot.rawRegister(ctx, q.opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = responseError(resp, body)
+	if err != nil {
+		return err
+	}
+
+	var registered struct {
+		ID           string `json:"queue_id"`
+		LastEventID  int    `json:"last_event_id"`
+		MaxMessageID int    `json:"max_message_id"`
+	}
+	err = json.Unmarshal(body, &registered)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.ID = registered.ID
+	q.LastEventID = registered.LastEventID
+	q.MaxMessageID = registered.MaxMessageID
+	q.lastEventAt = time.Now()
+	q.mu.Unlock()
+
+	q.Bot.logger().Info("registered queue", "queue_id", registered.ID,
+		"last_event_id", registered.LastEventID, "max_message_id", registered.MaxMessageID)
+	return nil
+}
+
+// queueID returns the queue's id.
+func (q *Queue) queueID() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.ID
+}
+
+// advance moves the queue's LastEventID up to id, if it is further along, as
+// an event is received.
+func (q *Queue) advance(id int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastEventAt = time.Now()
+	if id > q.LastEventID {
+		q.LastEventID = id
 	}
+}
+
+// EventsChan sends the messages from continual queue.GetEvents calls on the
+// returned channel. It returns a function which can be called to end the
+// calls, after which the channel is closed.
+//
+// Failures are retried with an increasing delay.
+func (q *Queue) EventsChan() (chan EventMessage, func()) {
+	ctx, endFunc := context.WithCancel(context.Background())
 
 	out := make(chan EventMessage)
 	go func() {
 		defer close(out)
-		for {
-			if end {
-				return
+		delay := pollRetryDelay
+		for ctx.Err() == nil {
+			ems, err := q.GetEventsCtx(ctx)
+			if err == HeartbeatError {
+				continue
 			}
-			ems, err := q.GetEvents()
-			// TODO? do something with the error
 			if err != nil {
+				if !sleepCtx(ctx, delay) {
+					return
+				}
+				delay = nextDelay(delay)
 				continue
 			}
+			delay = pollRetryDelay
 			for _, em := range ems {
-				out <- em
+				select {
+				case out <- em:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}()
@@ -47,21 +160,25 @@ func (q *Queue) EventsChan() (chan EventMessage, func()) {
 // the output of continual queue.GetEvents calls.
 // It returns a function which can be called to end the calls.
 //
+// Failures are retried with an increasing delay.
 // Note, it will never return a HeartbeatError.
 func (q *Queue) EventsCallback(fn func(EventMessage, error)) func() {
-	end := false
-	endFunc := func() {
-		end = true
-	}
+	ctx, endFunc := context.WithCancel(context.Background())
 	go func() {
-		for {
-			if end {
-				return
-			}
-			ems, err := q.GetEvents()
+		delay := pollRetryDelay
+		for ctx.Err() == nil {
+			ems, err := q.GetEventsCtx(ctx)
 			if err == HeartbeatError {
 				continue
 			}
+			if err != nil {
+				if !sleepCtx(ctx, delay) {
+					return
+				}
+				delay = nextDelay(delay)
+				continue
+			}
+			delay = pollRetryDelay
 			for _, em := range ems {
 				fn(em, err)
 			}
@@ -74,8 +191,64 @@ func (q *Queue) EventsCallback(fn func(EventMessage, error)) func() {
 // GetEvents is a blocking call that waits for and parses a list of EventMessages.
 // There will usually only be one EventMessage returned.
 // When a heartbeat is returned, GetEvents will return a HeartbeatError
+//
+// If the server has expired the queue, it is registered again, and polling
+// resumes on the new queue. An error is only returned if registering fails.
 func (q *Queue) GetEvents() ([]EventMessage, error) {
-	resp, err := q.RawGetEvents()
+	return q.GetEventsCtx(context.Background())
+}
+
+// GetEventsCtx is GetEvents, with a context that can cancel the request,
+// such as to stop waiting for events when the bot shuts down.
+func (q *Queue) GetEventsCtx(ctx context.Context) ([]EventMessage, error) {
+	body, err := q.fetchEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.ParseEventMessages(body)
+}
+
+// fetchEvents waits for the next events on the queue, and returns the body of
+// the response. If the queue has expired, it is registered again first.
+func (q *Queue) fetchEvents(ctx context.Context) ([]byte, error) {
+	q.pollMu.Lock()
+	defer q.pollMu.Unlock()
+	q.saveProgress()
+
+	body, err := q.pollEvents(ctx)
+	var ze *ZulipError
+	if q.noReregister || !errors.As(err, &ze) || ze.Code != CodeBadEventQueueID {
+		return body, err
+	}
+
+	if wait := time.Until(q.reregistered.Add(reregisterInterval)); wait > 0 {
+		if !sleepCtx(ctx, wait) {
+			return nil, ctx.Err()
+		}
+	}
+	q.reregistered = time.Now()
+	oldID := q.queueID()
+	q.Bot.logger().Warn("queue expired, registering it again", "queue_id", oldID)
+	if err := q.register(ctx); err != nil {
+		return nil, err
+	}
+	if q.Bot.OnQueueRecovered != nil {
+		q.Bot.OnQueueRecovered(oldID, q)
+	}
+
+	return q.pollEvents(ctx)
+}
+
+// pollEvents waits for the next events on the queue, and returns the body of
+// the response.
+func (q *Queue) pollEvents(ctx context.Context) ([]byte, error) {
+	req, err := q.constructEventsRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := q.Bot.do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -86,33 +259,100 @@ func (q *Queue) GetEvents() ([]EventMessage, error) {
 		return nil, err
 	}
 
-	msgs, err := q.ParseEventMessages(body)
+	err = responseError(resp, body)
 	if err != nil {
 		return nil, err
 	}
 
-	return msgs, nil
+	return body, nil
 }
 
 // RawGetEvents is a blocking call that receives a response containing a list
 // of events (a.k.a. received messages) since the last message id in the queue.
 func (q *Queue) RawGetEvents() (*http.Response, error) {
+	req, err := q.constructEventsRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Bot.do(req)
+}
+
+// constructEventsRequest makes the request for the events after the queue's LastEventID.
+func (q *Queue) constructEventsRequest() (*http.Request, error) {
+	q.mu.Lock()
 	values := url.Values{}
 	values.Set("queue_id", q.ID)
 	values.Set("last_event_id", strconv.Itoa(q.LastEventID))
+	q.mu.Unlock()
 
 	url := "events?" + values.Encode()
 
-	req, err := q.Bot.constructRequest("GET", url, "")
+	return q.Bot.constructRequest("GET", url, "")
+}
+
+// Delete removes the queue from the Zulip server, from the bot's Queues, and
+// from its QueueStore. The queue cannot be used after it has been deleted.
+func (q *Queue) Delete() (*http.Response, error) {
+	return q.DeleteCtx(context.Background())
+}
+
+// DeleteCtx is Delete, with a context that can cancel the request.
+func (q *Queue) DeleteCtx(ctx context.Context) (*http.Response, error) {
+	q.Bot.removeQueue(q)
+	if q.storeKey != "" && q.Bot.QueueStore != nil {
+		if err := q.Bot.QueueStore.DeleteQueue(q.storeKey); err != nil {
+			q.Bot.logger().Warn("deleting saved queue failed", "queue_id", q.queueID(), "error", err)
+		}
+	}
+
+	values := url.Values{}
+	values.Set("queue_id", q.queueID())
+
+	req, err := q.Bot.constructRequest("DELETE", "events?"+values.Encode(), "")
 	if err != nil {
 		return nil, err
 	}
 
-	return q.Bot.Client.Do(req)
+	return q.Bot.do(req.WithContext(ctx))
+}
+
+// removeQueue removes q from the bot's Queues, so its saved queue can be
+// resumed again.
+func (b *Bot) removeQueue(q *Queue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, bq := range b.Queues {
+		if bq == q {
+			b.Queues = append(b.Queues[:i:i], b.Queues[i+1:]...)
+			if q.storeKey != "" {
+				delete(b.storeKeys, q.storeKey)
+			}
+			return
+		}
+	}
+}
+
+// senderAllowed reports whether the message's sender is in the bot's AllowedSenders.
+func (b *Bot) senderAllowed(e EventMessage) bool {
+	if len(b.AllowedSenders) == 0 {
+		return true
+	}
+	id := strconv.Itoa(e.SenderID)
+	for _, s := range b.AllowedSenders {
+		if strings.EqualFold(s, e.SenderEmail) || s == id {
+			return true
+		}
+	}
+	return false
 }
 
 var HeartbeatError = errors.New("EventMessage is a heartbeat")
 
+// ParseEventMessages parses the messages out of a response to a request
+// for events, and advances the queue's LastEventID past the events in it.
+// Heartbeats, and other events without a message, are skipped. If the
+// response only has heartbeats, HeartbeatError is returned.
 func (q *Queue) ParseEventMessages(rawEventResponse []byte) ([]EventMessage, error) {
 	rawResponse := map[string]json.RawMessage{}
 	err := json.Unmarshal(rawEventResponse, &rawResponse)
@@ -126,21 +366,44 @@ func (q *Queue) ParseEventMessages(rawEventResponse []byte) ([]EventMessage, err
 		return nil, err
 	}
 
+	// advance past every event, so none of them are received again
+	for _, event := range events {
+		var id int
+		if json.Unmarshal(event["id"], &id) == nil {
+			q.advance(id)
+		}
+		var eventType string
+		if q.Bot != nil && json.Unmarshal(event["type"], &eventType) == nil {
+			q.Bot.metrics().EventReceived(eventType)
+		}
+	}
+
+	heartbeats := 0
 	messages := []EventMessage{}
 	for _, event := range events {
-		// if the event is a heartbeat, return a special error
 		if string(event["type"]) == `"heartbeat"` {
-			return nil, HeartbeatError
+			heartbeats++
+			continue
+		}
+		// presence, reaction and other events have no message
+		if len(event["message"]) == 0 || string(event["message"]) == "null" {
+			continue
 		}
 		var msg EventMessage
 		err = json.Unmarshal(event["message"], &msg)
-		// TODO? should this check be here
 		if err != nil {
 			return nil, err
 		}
 		msg.Queue = q
+		if q.Bot != nil && !q.Bot.receives(msg) {
+			continue
+		}
 		messages = append(messages, msg)
 	}
 
+	if heartbeats != 0 && heartbeats == len(events) {
+		return nil, HeartbeatError
+	}
+
 	return messages, nil
 }
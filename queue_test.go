@@ -0,0 +1,168 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseEventMessagesAllowedSenders(t *testing.T) {
+	events := []byte(`{"result":"success","msg":"","events":[
+		{"id":0,"type":"message","message":{"id":10,"sender_email":"ops@example.com","sender_id":1}},
+		{"id":1,"type":"message","message":{"id":11,"sender_email":"someone@example.com","sender_id":2}},
+		{"id":2,"type":"message","message":{"id":12,"sender_email":"other@example.com","sender_id":3}}
+	]}`)
+
+	bot := getTestBot()
+	q := &Queue{Bot: bot}
+
+	msgs, err := q.ParseEventMessages(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Errorf("got %d messages with no allowed senders, expected 3", len(msgs))
+	}
+
+	bot.AllowedSenders = []string{"Ops@example.com", "3"}
+	msgs, err = q.ParseEventMessages(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != 10 || msgs[1].ID != 12 {
+		t.Errorf("expected only messages from allowed senders, got %v", msgs)
+	}
+}
+
+func TestGetEventsReregisters(t *testing.T) {
+	bad := `{"result":"error","msg":"Bad event queue id: q1","code":"BAD_EVENT_QUEUE_ID","queue_id":"q1"}`
+	bot := getTestBotWithResponses(
+		bad,
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`,
+		bad,
+		`{"result":"error","msg":"Invalid API key","code":"UNAUTHORIZED"}`,
+	)
+	q := &Queue{Bot: bot, ID: "q1", LastEventID: 5}
+
+	var recovered []string
+	bot.OnQueueRecovered = func(oldID string, nq *Queue) {
+		recovered = append(recovered, oldID+" "+nq.ID+" "+strconv.Itoa(nq.LastEventID))
+	}
+
+	msgs, err := q.GetEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recovered, []string{"q1 q2 -1"}) {
+		t.Errorf("got %q, expected the recovery to be reported once", recovered)
+	}
+	if len(msgs) != 1 || msgs[0].ID != 1 {
+		t.Errorf("got %v, expected the message from the new queue", msgs)
+	}
+	reqs := bot.Client.(*testClient).Requests
+	if id := reqs[2].URL.Query().Get("queue_id"); id != "q2" {
+		t.Errorf("got queue %q, expected to poll the new queue", id)
+	}
+
+	// registering again soon after is delayed, and its failure is returned
+	old := reregisterInterval
+	reregisterInterval = 50 * time.Millisecond
+	defer func() { reregisterInterval = old }()
+
+	start := time.Now()
+	_, err = q.GetEvents()
+	ze, ok := err.(*ZulipError)
+	if !ok || ze.Code != "UNAUTHORIZED" {
+		t.Errorf("got %v, expected the registration error", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("registered again after %v, expected a delay", d)
+	}
+	if len(recovered) != 1 {
+		t.Errorf("got %q, expected a failed registration not to be reported", recovered)
+	}
+}
+
+func TestParseEventMessagesSkipsOtherEvents(t *testing.T) {
+	q := &Queue{}
+
+	msgs, err := q.ParseEventMessages([]byte(`{"result":"success","msg":"","events":[
+		{"id":0,"type":"message","message":{"id":10}},
+		{"id":1,"type":"heartbeat"},
+		{"id":2,"type":"presence","email":"someone@example.com"},
+		{"id":3,"type":"message","message":{"id":11}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != 10 || msgs[1].ID != 11 {
+		t.Errorf("got %v, expected exactly the two messages", msgs)
+	}
+	if q.LastEventID != 3 {
+		t.Errorf("got last event id %d, expected 3", q.LastEventID)
+	}
+
+	_, err = q.ParseEventMessages([]byte(`{"result":"success","msg":"","events":[{"id":4,"type":"heartbeat"}]}`))
+	if err != HeartbeatError {
+		t.Errorf("got %v, expected HeartbeatError for only heartbeats", err)
+	}
+}
+
+func TestGetEventsCtx(t *testing.T) {
+	bot := getTestBot()
+	bot.Client = contextClient{}
+	q := &Queue{Bot: bot, ID: "q1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := q.GetEventsCtx(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected an error wrapping %v", err, context.Canceled)
+	}
+
+	_, err = bot.RegisterEventsCtx(ctx, nil, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, expected an error wrapping %v", err, context.Canceled)
+	}
+}
+
+func TestEventsChan(t *testing.T) {
+	old := pollRetryDelay
+	pollRetryDelay = time.Millisecond
+	defer func() { pollRetryDelay = old }()
+
+	bot := getTestBot()
+	tc := bot.Client.(*testClient)
+	tc.Responses = []*http.Response{
+		jsonResponse(500, `{"result":"error","msg":"Internal server error"}`),
+		jsonResponse(200, `{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`),
+	}
+	bot.Client = waitingClient{tc}
+	q := &Queue{Bot: bot, ID: "q1", LastEventID: -1}
+
+	msgs, end := q.EventsChan()
+	select {
+	case m := <-msgs:
+		if m.ID != 1 {
+			t.Errorf("got message %d, expected 1", m.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message after the failure")
+	}
+
+	end()
+	select {
+	case _, ok := <-msgs:
+		if ok {
+			t.Error("expected no more messages")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the channel to be closed when ended")
+	}
+}
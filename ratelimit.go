@@ -0,0 +1,68 @@
+package gozulipbot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter limits how often the bot sends requests, so bursts of work
+// are spread out instead of being rate limited by Zulip. It allows Burst
+// requests at once, and then Rate requests per second.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a rate limiter allowing rate requests per second,
+// with bursts of up to burst requests. A burst less than 1 is treated as 1.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Wait blocks until a request may be sent, or until the context is done, in
+// which case the context's error is returned.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		d := l.reserve()
+		if d <= 0 {
+			return nil
+		}
+		if !sleepCtx(ctx, d) {
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if there is one, and otherwise returns how long until
+// there will be.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	if l.rate <= 0 {
+		// no tokens are ever added, so check again in a while
+		return time.Second
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
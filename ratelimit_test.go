@@ -0,0 +1,46 @@
+package gozulipbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(100, 2)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst is immediate, and the other two wait 10ms each
+	if d := time.Since(start); d < 15*time.Millisecond || d > time.Second {
+		t.Errorf("got %v, expected about 20ms", d)
+	}
+
+	l = NewRateLimiter(0.001, 1)
+	l.Wait(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestBotRateLimiter(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`)
+	bot.RateLimiter = NewRateLimiter(0.001, 1)
+	bot.RateLimiter.Wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := bot.MessageCtx(ctx, Message{Stream: "a", Topic: "b", Content: "hello"})
+	if err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+	if n := len(bot.Client.(*testClient).Requests); n != 0 {
+		t.Errorf("got %d requests, expected none to be sent", n)
+	}
+}
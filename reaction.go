@@ -0,0 +1,263 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Reaction types, for an Emoji's Type.
+const (
+	UnicodeEmoji    = "unicode_emoji"
+	RealmEmoji      = "realm_emoji"
+	ZulipExtraEmoji = "zulip_extra_emoji"
+)
+
+// An Emoji identifies an emoji to react with. Only Name is needed, but
+// Code and Type pick out the emoji exactly, such as a realm's custom emoji
+// that has been renamed. For realm emoji, Code is the emoji's id.
+type Emoji struct {
+	Name string
+	Code string
+	Type string
+}
+
+// CustomEmoji returns the realm's custom emoji with the given name and id.
+func CustomEmoji(name string, id int) Emoji {
+	return Emoji{Name: name, Code: strconv.Itoa(id), Type: RealmEmoji}
+}
+
+// AddReaction reacts to the message with the given id with an emoji.
+// If the emoji is a unicode emoji in the package's table, its emoji code is
+// sent as well, so servers that require the code accept the reaction.
+// Custom emoji are found by name.
+func (b *Bot) AddReaction(messageID int, emojiName string) (*http.Response, error) {
+	return b.AddEmojiReaction(messageID, Emoji{Name: emojiName})
+}
+
+// AddEmojiReaction reacts to the message with the given id with an emoji.
+func (b *Bot) AddEmojiReaction(messageID int, emoji Emoji) (*http.Response, error) {
+	if emoji.Name == "" {
+		return nil, errors.New("emoji name cannot be empty")
+	}
+	values := reactionValues(emoji)
+
+	req, err := b.constructRequest("POST", fmt.Sprintf("messages/%d/reactions", messageID), values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// reactionValues returns the values identifying the emoji, filling in the
+// code of unicode emoji in the package's table.
+func reactionValues(emoji Emoji) url.Values {
+	if emoji.Code == "" {
+		if code, ok := UnicodeEmojiCode(emoji.Name); ok {
+			emoji.Code = code
+			emoji.Type = UnicodeEmoji
+		}
+	}
+
+	values := url.Values{}
+	values.Set("emoji_name", emoji.Name)
+	if emoji.Code != "" {
+		values.Set("emoji_code", emoji.Code)
+	}
+	if emoji.Type != "" {
+		values.Set("reaction_type", emoji.Type)
+	}
+	return values
+}
+
+// RemoveReaction removes the bot's emoji reaction from the message with the given id.
+func (b *Bot) RemoveReaction(messageID int, emojiName string) (*http.Response, error) {
+	return b.RemoveEmojiReaction(messageID, Emoji{Name: emojiName})
+}
+
+// RemoveEmojiReaction removes the bot's emoji reaction from the message with
+// the given id.
+func (b *Bot) RemoveEmojiReaction(messageID int, emoji Emoji) (*http.Response, error) {
+	if emoji.Name == "" {
+		return nil, errors.New("emoji name cannot be empty")
+	}
+	values := reactionValues(emoji)
+
+	req, err := b.constructRequest("DELETE", fmt.Sprintf("messages/%d/reactions?%s", messageID, values.Encode()), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// Acknowledge reacts to an EventMessage with an emoji, the way Respond
+// replies to one, such as "eyes" to show the bot is working on it.
+func (b *Bot) Acknowledge(e EventMessage, emojiName string) (*http.Response, error) {
+	return b.AddReaction(e.ID, emojiName)
+}
+
+// CompleteWith finishes handling an EventMessage. It swaps the removeEmoji
+// reaction for the addEmoji reaction, and then responds with reply.
+// A removeEmoji reaction that was already removed is not an error.
+// Either emoji, or the reply, can be left empty to skip that step.
+func (b *Bot) CompleteWith(e EventMessage, removeEmoji, addEmoji, reply string) (*http.Response, error) {
+	if removeEmoji != "" {
+		resp, err := b.RemoveReaction(e.ID, removeEmoji)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		var ze *ZulipError
+		if errors.As(err, &ze) && ze.Code == CodeReactionDoesNotExist {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if addEmoji != "" {
+		resp, err := b.Acknowledge(e, addEmoji)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if reply == "" {
+		return nil, nil
+	}
+	return b.Respond(e, reply)
+}
+
+// DefaultPinEmoji is the reaction SendAndPin uses when the bot's PinEmoji is unset.
+const DefaultPinEmoji = "push_pin"
+
+// SendAndPin posts a message and then reacts to it with the bot's PinEmoji,
+// for tools that treat messages with that reaction as pinned.
+func (b *Bot) SendAndPin(m Message) (*MessageResponse, error) {
+	mr, err := b.SendMessage(m)
+	if err != nil {
+		return nil, err
+	}
+
+	emoji := b.PinEmoji
+	if emoji == "" {
+		emoji = DefaultPinEmoji
+	}
+
+	resp, err := b.AddReaction(mr.ID, emoji)
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	return mr, err
+}
+
+// Added reports whether the reaction was added, rather than removed.
+func (r ReactionEvent) Added() bool {
+	return r.Op == "add"
+}
+
+// RespondWithReaction reacts with an emoji to the message a reaction event is
+// on, such as "check" once a deploy someone approved with "+1" is done.
+func (b *Bot) RespondWithReaction(r ReactionEvent, emojiName string) (*http.Response, error) {
+	return b.AddReaction(r.MessageID, emojiName)
+}
+
+// OwnMessageReactions adapts h for a Dispatcher's OnReaction, so it is only
+// called with the reactions other users add to and remove from messages the
+// bot sent, such as to run approve and deny flows:
+//
+//	d.OnReaction(bot.OwnMessageReactions(func(b *Bot, r ReactionEvent) {
+//		if r.Added() && r.EmojiName == "+1" {
+//			deploy(r.MessageID)
+//		}
+//	}))
+//
+// Messages the bot sent recently are recognised by their ids; others are
+// fetched to check their sender. The bot's own reactions are ignored.
+// Reactions that can't be checked, such as when fetching the message fails,
+// are logged and dropped.
+func (b *Bot) OwnMessageReactions(h func(*Bot, ReactionEvent)) func(ReactionEvent) {
+	return func(r ReactionEvent) {
+		userID, err := b.ownUserID()
+		if err != nil {
+			b.logger().Warn("checking reaction failed", "message_id", r.MessageID, "error", err)
+			return
+		}
+		if r.UserID == userID {
+			return
+		}
+
+		own, err := b.isOwnMessage(r.MessageID, userID)
+		if err != nil {
+			b.logger().Warn("checking reaction failed", "message_id", r.MessageID, "error", err)
+			return
+		}
+		if own {
+			h(b, r)
+		}
+	}
+}
+
+// OnReactionToOwnMessage calls h with the reactions other users add to and
+// remove from messages the bot sent, until the context is done. It runs a
+// polling loop like RunDispatcher, and returns the same errors.
+func (b *Bot) OnReactionToOwnMessage(ctx context.Context, h func(*Bot, ReactionEvent)) error {
+	d := NewDispatcher()
+	d.OnReaction(b.OwnMessageReactions(h))
+	return b.RunDispatcher(ctx, d)
+}
+
+// ownUserID returns the bot's user id, fetching its profile the first time.
+func (b *Bot) ownUserID() (int, error) {
+	id, _, err := b.ownUser()
+	return id, err
+}
+
+// ownUser returns the bot's user id and full name, fetching its profile the
+// first time.
+func (b *Bot) ownUser() (int, string, error) {
+	b.mu.Lock()
+	id, name := b.userID, b.fullName
+	b.mu.Unlock()
+	if id != 0 {
+		return id, name, nil
+	}
+
+	u, err := b.GetProfile()
+	if err != nil {
+		return 0, "", err
+	}
+	return u.ID, u.FullName, nil
+}
+
+// isOwnMessage reports whether the bot, with the given user id, sent the
+// message with the given id.
+func (b *Bot) isOwnMessage(messageID, userID int) (bool, error) {
+	b.mu.Lock()
+	sent := b.sent.set[messageID]
+	b.mu.Unlock()
+	if sent {
+		return true, nil
+	}
+
+	m, err := b.GetMessage(messageID)
+	if err != nil {
+		return false, err
+	}
+	if m.SenderID != userID {
+		return false, nil
+	}
+	b.mu.Lock()
+	b.sent.add(messageID)
+	b.mu.Unlock()
+	return true, nil
+}
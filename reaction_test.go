@@ -0,0 +1,211 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestUnicodeEmojiCode(t *testing.T) {
+	cases := map[string]string{
+		"thumbs_up":  "1f44d",
+		"+1":         "1f44d",
+		"tada":       "1f389",
+		"check":      "2705",
+		"eyes":       "1f440",
+		"cross_mark": "274c",
+	}
+	for name, expected := range cases {
+		code, ok := UnicodeEmojiCode(name)
+		if !ok || code != expected {
+			t.Errorf("got %q, %v, expected %q, case %q", code, ok, expected, name)
+		}
+	}
+
+	if _, ok := UnicodeEmojiCode("realm_party_parrot"); ok {
+		t.Error("expected an unknown emoji to be missing")
+	}
+}
+
+func TestAddReaction(t *testing.T) {
+	bot := getTestBot()
+	type C struct {
+		Emoji string
+		Body  string
+	}
+	cases := map[string]C{
+		"unicode": C{Emoji: "thumbs_up", Body: "emoji_code=1f44d&emoji_name=thumbs_up&reaction_type=unicode_emoji"},
+		"custom":  C{Emoji: "party_parrot", Body: "emoji_name=party_parrot"},
+	}
+
+	for name, c := range cases {
+		_, err := bot.AddReaction(12, c.Emoji)
+		if err != nil {
+			t.Fatalf("got %q, case %q", err, name)
+		}
+		req := bot.Client.(*testClient).Request
+		if req.Method != "POST" || req.URL.Path != "/v1/messages/12/reactions" {
+			t.Errorf("got %s %s, case %q", req.Method, req.URL.Path, name)
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != c.Body {
+			t.Errorf("got %q, expected %q, case %q", string(body), c.Body, name)
+		}
+	}
+}
+
+func TestCompleteWith(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"error","msg":"Reaction doesn't exist.","code":"REACTION_DOES_NOT_EXIST"}`,
+		`{"result":"success","msg":""}`,
+		`{"result":"success","msg":"","id":21}`,
+	)
+	e := EventMessage{ID: 20, DisplayRecipient: DisplayRecipient{Topic: "ops"}, Subject: "deploys"}
+
+	_, err := bot.CompleteWith(e, "eyes", "check", "deployed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) != 3 {
+		t.Fatalf("got %d requests, expected 3", len(reqs))
+	}
+	if reqs[0].Method != "DELETE" || reqs[0].URL.Path != "/v1/messages/20/reactions" || reqs[0].URL.Query().Get("emoji_name") != "eyes" {
+		t.Errorf("got %s %s, expected the working reaction to be removed", reqs[0].Method, reqs[0].URL)
+	}
+	body, _ := ioutil.ReadAll(reqs[1].Body)
+	if reqs[1].Method != "POST" || string(body) != "emoji_code=2705&emoji_name=check&reaction_type=unicode_emoji" {
+		t.Errorf("got %s %q, expected the done reaction to be added", reqs[1].Method, string(body))
+	}
+	if reqs[2].URL.Path != "/v1/messages" {
+		t.Errorf("got %s, expected a reply", reqs[2].URL.Path)
+	}
+
+	bot = getTestBotWithResponses(`{"result":"error","msg":"Invalid message(s)","code":"BAD_REQUEST"}`)
+	if _, err = bot.CompleteWith(e, "eyes", "check", "deployed"); err == nil {
+		t.Error("expected other errors removing the reaction to be returned")
+	}
+}
+
+func TestSendAndPin(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","id":30}`, `{"result":"success","msg":""}`)
+	bot.PinEmoji = "star"
+
+	mr, err := bot.SendAndPin(Message{Stream: "ops", Topic: "runbook", Content: "read me"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.ID != 30 {
+		t.Errorf("got id %d, expected 30", mr.ID)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, expected 2", len(reqs))
+	}
+	if reqs[0].URL.Path != "/v1/messages" {
+		t.Errorf("got %s, expected the message to be sent first", reqs[0].URL.Path)
+	}
+	body, _ := ioutil.ReadAll(reqs[1].Body)
+	if reqs[1].URL.Path != "/v1/messages/30/reactions" || string(body) != "emoji_code=2b50&emoji_name=star&reaction_type=unicode_emoji" {
+		t.Errorf("got %s %q, expected the pin reaction on the sent message", reqs[1].URL.Path, string(body))
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.Acknowledge(EventMessage{ID: 14}, "octopus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/messages/14/reactions" {
+		t.Errorf("got path %q", p)
+	}
+
+	if _, err := bot.Acknowledge(EventMessage{ID: 14}, ""); err == nil {
+		t.Error("expected an error for an empty emoji name")
+	}
+	if _, err := bot.RemoveReaction(14, ""); err == nil {
+		t.Error("expected an error for an empty emoji name")
+	}
+}
+
+func TestAddEmojiReaction(t *testing.T) {
+	bot := getTestBot()
+
+	_, err := bot.AddEmojiReaction(7, CustomEmoji("party_parrot", 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	expected := "emoji_code=42&emoji_name=party_parrot&reaction_type=realm_emoji"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+
+	_, err = bot.RemoveEmojiReaction(7, CustomEmoji("party_parrot", 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := bot.Client.(*testClient).Request.URL.RawQuery; q != expected {
+		t.Errorf("got %q, expected %q", q, expected)
+	}
+
+	if _, err := bot.AddEmojiReaction(7, Emoji{Code: "42"}); err == nil {
+		t.Error("expected an error for an emoji without a name")
+	}
+}
+
+func TestOwnMessageReactions(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","id":40}`,
+		`{"result":"success","msg":"","user_id":5,"email":"testbot@example.com"}`,
+		`{"result":"success","msg":"","message":{"id":41,"sender_id":5}}`,
+		`{"result":"success","msg":"","message":{"id":42,"sender_id":8}}`,
+	)
+	if _, err := bot.Message(Message{Stream: "deploys", Topic: "prod", Content: "react :+1: to deploy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	h := bot.OwnMessageReactions(func(b *Bot, r ReactionEvent) {
+		got = append(got, r.MessageID)
+	})
+	h(ReactionEvent{Op: "add", UserID: 8, MessageID: 40, EmojiName: "+1"})
+	h(ReactionEvent{Op: "add", UserID: 5, MessageID: 40, EmojiName: "pin"})
+	h(ReactionEvent{Op: "add", UserID: 8, MessageID: 41, EmojiName: "+1"})
+	h(ReactionEvent{Op: "remove", UserID: 8, MessageID: 41, EmojiName: "+1"})
+	h(ReactionEvent{Op: "add", UserID: 9, MessageID: 42, EmojiName: "+1"})
+
+	if expected := []int{40, 41, 41}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got reactions on %v, expected %v", got, expected)
+	}
+
+	// the profile and each unknown message are only fetched once
+	var paths []string
+	for _, req := range bot.Client.(*testClient).Requests[1:] {
+		paths = append(paths, req.URL.Path)
+	}
+	if expected := []string{"/v1/users/me", "/v1/messages/41", "/v1/messages/42"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("got requests %q, expected %q", paths, expected)
+	}
+}
+
+func TestRespondWithReaction(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`)
+
+	r := ReactionEvent{Op: "add", UserID: 8, MessageID: 40, EmojiName: "+1"}
+	if !r.Added() {
+		t.Error("expected the reaction to be added")
+	}
+	if _, err := bot.RespondWithReaction(r, "check"); err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	req.ParseForm()
+	if req.URL.Path != "/v1/messages/40/reactions" || req.PostForm.Get("emoji_name") != "check" {
+		t.Errorf("got %s %q", req.URL.Path, req.PostForm.Encode())
+	}
+}
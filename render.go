@@ -0,0 +1,32 @@
+package gozulipbot
+
+import (
+	"errors"
+	"net/url"
+)
+
+// Render returns the HTML Zulip renders the markdown content as, without
+// posting it.
+func (b *Bot) Render(content string) (string, error) {
+	if content == "" {
+		return "", errors.New("content cannot be empty")
+	}
+
+	values := url.Values{}
+	values.Set("content", content)
+
+	req, err := b.constructRequest("POST", "messages/render", values.Encode())
+	if err != nil {
+		return "", err
+	}
+
+	var rj struct {
+		Rendered string `json:"rendered"`
+	}
+	err = b.doJSON(req, &rj)
+	if err != nil {
+		return "", err
+	}
+
+	return rj.Rendered, nil
+}
@@ -0,0 +1,36 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","rendered":"<p><strong>hi</strong></p>"}`,
+		`{"result":"error","msg":"Invalid API key","code":"UNAUTHORIZED"}`,
+	)
+
+	if _, err := bot.Render(""); err == nil {
+		t.Error("expected an error for empty content")
+	}
+
+	html, err := bot.Render("**hi**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != "<p><strong>hi</strong></p>" {
+		t.Errorf("got %q", html)
+	}
+
+	req := bot.Client.(*testClient).Request
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.URL.Path != "/v1/messages/render" || string(body) != "content=%2A%2Ahi%2A%2A" {
+		t.Errorf("got %s %q", req.URL.Path, string(body))
+	}
+
+	_, err = bot.Render("**hi**")
+	if ze, ok := err.(*ZulipError); !ok || ze.Code != "UNAUTHORIZED" {
+		t.Errorf("got %v, expected a *ZulipError", err)
+	}
+}
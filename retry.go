@@ -0,0 +1,106 @@
+package gozulipbot
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryBaseDelay is the first backoff delay used when a rate limited
+// response has no Retry-After header, and RetryConfig's BaseDelay is 0.
+const DefaultRetryBaseDelay = time.Second
+
+// RetryConfig controls how the bot retries requests that Zulip responds to
+// with 429 Too Many Requests. Each retry waits for the response's Retry-After
+// duration, or until its X-RateLimit-Reset time, or backs off exponentially
+// from BaseDelay if there is neither.
+type RetryConfig struct {
+	// MaxAttempts is the most times a request is sent, including the first.
+	// If it is 0 or 1, requests are not retried.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry when there is no
+	// Retry-After or X-RateLimit-Reset header. It doubles for each retry
+	// after that. If it is 0, DefaultRetryBaseDelay is used.
+	BaseDelay time.Duration
+}
+
+// doWithRetry sends a request, resending it while it is rate limited, up to
+// the bot's Retry.MaxAttempts. The request's body is rebuilt for each attempt,
+// so requests with bodies that can't be rebuilt are only sent once.
+func (b *Bot) doWithRetry(req *http.Request) (*http.Response, error) {
+	base := b.Retry.BaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := b.doOnce(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= b.Retry.MaxAttempts {
+			return resp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := retryDelay(resp, attempt-1, base)
+		resp.Body.Close()
+		b.logger().Info("rate limited, retrying", "endpoint", b.requestEndpoint(req),
+			"attempt", attempt, "retry_in", delay)
+		b.metrics().Retry(b.requestEndpoint(req))
+		if !sleepCtx(req.Context(), delay) {
+			return nil, req.Context().Err()
+		}
+
+		next := req.WithContext(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			next.Body = body
+		}
+		req = next
+	}
+}
+
+// retryDelay returns how long to wait before retrying a rate limited request.
+// It uses the response's Retry-After or X-RateLimit-Reset header if there is
+// one, and otherwise backs off exponentially from base, with jitter.
+func retryDelay(resp *http.Response, attempt int, base time.Duration) time.Duration {
+	if d, ok := retryAfterHeader(resp.Header); ok {
+		return d
+	}
+
+	d := base << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryAfterHeader returns how long the headers of a rate limited response
+// say to wait, and whether they said. A Retry-After header is either a number
+// of seconds or a date; an X-RateLimit-Reset header is a unix time.
+func retryAfterHeader(h http.Header) (time.Duration, bool) {
+	if ra := h.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.ParseFloat(ra, 64); err == nil && secs >= 0 {
+			return time.Duration(secs * float64(time.Second)), true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return untilOrZero(t), true
+		}
+	}
+	if reset := h.Get("X-RateLimit-Reset"); reset != "" {
+		if secs, err := strconv.ParseFloat(reset, 64); err == nil {
+			return untilOrZero(time.Unix(0, int64(secs*float64(time.Second)))), true
+		}
+	}
+	return 0, false
+}
+
+// untilOrZero returns the duration until t, or 0 if t has passed.
+func untilOrZero(t time.Time) time.Duration {
+	if d := time.Until(t); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func rateLimited() *http.Response {
+	resp := jsonResponse(429, `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT"}`)
+	resp.Header.Set("Retry-After", "0")
+	return resp
+}
+
+func TestRetry(t *testing.T) {
+	type C struct {
+		MaxAttempts int
+		Responses   []*http.Response
+		Requests    int
+		Status      int
+	}
+
+	ok := `{"result":"success","msg":"","id":1}`
+	cases := map[string]C{
+		"off": C{
+			Responses: []*http.Response{rateLimited(), jsonResponse(200, ok)},
+			Requests:  1,
+			Status:    429,
+		},
+		"retried": C{
+			MaxAttempts: 3,
+			Responses:   []*http.Response{rateLimited(), rateLimited(), jsonResponse(200, ok)},
+			Requests:    3,
+			Status:      200,
+		},
+		"gives up": C{
+			MaxAttempts: 2,
+			Responses:   []*http.Response{rateLimited(), rateLimited(), jsonResponse(200, ok)},
+			Requests:    2,
+			Status:      429,
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		bot.Retry = RetryConfig{MaxAttempts: c.MaxAttempts}
+		tc := bot.Client.(*testClient)
+		tc.Responses = c.Responses
+
+		resp, _ := bot.Message(Message{Stream: "a", Topic: "b", Content: "hello"})
+		if resp.StatusCode != c.Status {
+			t.Errorf("got %d, expected %d, case %q", resp.StatusCode, c.Status, k)
+		}
+		if len(tc.Requests) != c.Requests {
+			t.Fatalf("got %d requests, expected %d, case %q", len(tc.Requests), c.Requests, k)
+		}
+		for _, req := range tc.Requests {
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != "content=hello&to=a&topic=b&type=stream" {
+				t.Errorf("got %q, expected the full body on each attempt, case %q", string(body), k)
+			}
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	resp := rateLimited()
+	resp.Header.Set("Retry-After", "2")
+	if d := retryDelay(resp, 3, time.Second); d != 2*time.Second {
+		t.Errorf("got %v, expected %v", d, 2*time.Second)
+	}
+
+	resp.Header.Del("Retry-After")
+	d := retryDelay(resp, 2, time.Second)
+	if d < 2*time.Second || d > 4*time.Second {
+		t.Errorf("got %v, expected between 2s and 4s", d)
+	}
+}
+
+func TestRetryDelayReset(t *testing.T) {
+	resp := rateLimited()
+	resp.Header.Del("Retry-After")
+	reset := time.Now().Add(3 * time.Second)
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	d := retryDelay(resp, 0, time.Hour)
+	if d > 3*time.Second || d < time.Second {
+		t.Errorf("got %v, expected to wait until the reset about 3s away", d)
+	}
+
+	resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	if d := retryDelay(resp, 0, time.Hour); d != 0 {
+		t.Errorf("got %v, expected no wait for a reset that has passed", d)
+	}
+}
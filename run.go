@@ -0,0 +1,226 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// OnMessage registers a queue for messages and calls handler with each
+// message the bot receives, until the context is done. A handler that panics
+// is recovered, and the panic is logged.
+//
+// Temporary failures are retried, and a queue that expires is replaced with
+// a new one. The queue is deleted when OnMessage returns.
+// An error registering the first queue is returned immediately; otherwise
+// OnMessage returns the context's error.
+func (b *Bot) OnMessage(ctx context.Context, handler func(*Bot, EventMessage)) error {
+	ctx, finish := b.startLoop(ctx)
+	defer finish()
+
+	q, err := b.RegisterEventsCtx(ctx, nil, "")
+	if err != nil {
+		return err
+	}
+	defer b.closeQueue(context.Background(), q)
+
+	return b.pollQueue(ctx, q, func(m EventMessage) error {
+		b.handleMessage(handler, m)
+		return nil
+	})
+}
+
+// EventLoopN is OnMessage, with messages handled by a pool of workers, so a
+// slow handler doesn't hold up the messages after it. Up to workers messages
+// are handled at once, so messages may be handled out of order. While every
+// worker is busy, the queue isn't polled; handlers that take minutes risk the
+// queue expiring, which replaces it.
+//
+// When the context is done, EventLoopN waits for the running handlers to
+// return before deleting the queue.
+func (b *Bot) EventLoopN(ctx context.Context, workers int, handler func(*Bot, EventMessage)) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, finish := b.startLoop(ctx)
+	defer finish()
+
+	q, err := b.RegisterEventsCtx(ctx, nil, "")
+	if err != nil {
+		return err
+	}
+	defer b.closeQueue(context.Background(), q)
+
+	msgs := make(chan EventMessage)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range msgs {
+				b.handleMessage(handler, m)
+			}
+		}()
+	}
+	defer func() {
+		close(msgs)
+		wg.Wait()
+	}()
+
+	return b.pollQueue(ctx, q, func(m EventMessage) error {
+		select {
+		case msgs <- m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// handleMessage calls handler with the message, recovering from a panic.
+func (b *Bot) handleMessage(handler func(*Bot, EventMessage), m EventMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logRecovered(m.ID, r)
+		}
+	}()
+	handler(b, m)
+}
+
+// pollQueue calls handler with the messages from the queue until the context
+// is done or handler returns an error, which is returned.
+//
+// Failures are retried with an increasing delay. An expired queue is
+// registered again by GetEventsCtx, unless the queue doesn't allow it, in which
+// case the expiry is returned instead of retried. A request that
+// stalls, per the bot's StallTimeout, is abandoned and retried like a failure.
+func (b *Bot) pollQueue(ctx context.Context, q *Queue, handler func(EventMessage) error) error {
+	return b.pollAllEvents(ctx, q, func(e Event) error {
+		if e.Message == nil {
+			return nil
+		}
+		return handler(*e.Message)
+	})
+}
+
+// pollAllEvents is pollQueue, calling handler with every event from the queue.
+func (b *Bot) pollAllEvents(ctx context.Context, q *Queue, handler func(Event) error) error {
+	delay := pollRetryDelay
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		pollCtx, done := b.watchStall(ctx, q)
+		events, err := q.GetAllEventsCtx(pollCtx)
+		done()
+		var ze *ZulipError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &ze) && ze.Code == CodeBadEventQueueID && q.noReregister:
+			return err
+		case err != nil:
+			// wait out failures, keeping the queue and its place in it
+			b.logger().Warn("polling queue failed", "queue_id", q.queueID(), "error", err, "retry_in", delay)
+			if !sleepCtx(ctx, delay) {
+				return ctx.Err()
+			}
+			delay = nextDelay(delay)
+			continue
+		}
+		delay = pollRetryDelay
+		b.logger().Debug("polled queue", "queue_id", q.queueID(), "events", len(events))
+
+		for _, e := range events {
+			if err := handler(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sleepCtx waits for d, and reports false if the context was done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pollRetryDelay is how long polling waits after a failure before retrying,
+// before the delay starts to increase.
+var pollRetryDelay = time.Second
+
+// nextDelay doubles a retry delay, up to 30 seconds.
+func nextDelay(d time.Duration) time.Duration {
+	if d *= 2; d > 30*time.Second {
+		return 30 * time.Second
+	}
+	return d
+}
+
+// EventStream registers a queue for messages and sends each message the bot
+// receives on the returned channel, until the context is done. The queue is
+// polled in the background, advancing its last event id as events arrive,
+// and is deleted when the stream stops.
+//
+// Failures while polling are sent on the error channel and retried with an
+// increasing delay; a failure to register the queue stops the stream.
+// Both channels are closed once the stream has stopped.
+func (b *Bot) EventStream(ctx context.Context) (<-chan EventMessage, <-chan error) {
+	msgs := make(chan EventMessage)
+	errs := make(chan error, 1)
+
+	ctx, finish := b.startLoop(ctx)
+	go func() {
+		defer finish()
+		defer close(errs)
+		defer close(msgs)
+
+		q, err := b.RegisterEventsCtx(ctx, nil, "")
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer b.closeQueue(context.Background(), q)
+
+		delay := pollRetryDelay
+		for ctx.Err() == nil {
+			ems, err := q.GetEventsCtx(ctx)
+			switch {
+			case err == HeartbeatError:
+				continue
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				select {
+				case errs <- err:
+				case <-ctx.Done():
+					return
+				}
+				if !sleepCtx(ctx, delay) {
+					return
+				}
+				delay = nextDelay(delay)
+				continue
+			}
+			delay = pollRetryDelay
+
+			for _, em := range ems {
+				select {
+				case msgs <- em:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return msgs, errs
+}
@@ -0,0 +1,157 @@
+package gozulipbot
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestOnMessage(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"error","msg":"Bad event queue id: q1","code":"BAD_EVENT_QUEUE_ID","queue_id":"q1"}`,
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[
+			{"id":0,"type":"message","message":{"id":1,"content":"panic"}},
+			{"id":1,"type":"message","message":{"id":2,"content":"stop"}}
+		]}`,
+		`{"result":"success","msg":""}`,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var handled []int
+	err := bot.OnMessage(ctx, func(b *Bot, m EventMessage) {
+		handled = append(handled, m.ID)
+		if m.Content == "panic" {
+			panic("handler failed")
+		}
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Fatalf("got %v, expected the context's error", err)
+	}
+
+	if len(handled) != 2 {
+		t.Errorf("got %v, expected both messages to be handled", handled)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if q := reqs[3].URL.Query().Get("queue_id"); q != "q2" {
+		t.Errorf("got queue %q, expected to poll the new queue", q)
+	}
+	last := reqs[len(reqs)-1]
+	if last.Method != "DELETE" || last.URL.Query().Get("queue_id") != "q2" {
+		t.Errorf("got %s %s, expected the new queue to be deleted", last.Method, last.URL)
+	}
+}
+
+// waitingClient is a testClient that, once it runs out of responses, waits
+// for polls to be cancelled, like a long poll with no new events.
+type waitingClient struct {
+	*testClient
+}
+
+func (c waitingClient) Do(r *http.Request) (*http.Response, error) {
+	if len(c.Responses) == 0 && r.Method == "GET" {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+	return c.testClient.Do(r)
+}
+
+func TestEventStream(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[
+			{"id":0,"type":"message","message":{"id":1}},
+			{"id":1,"type":"message","message":{"id":2}}
+		]}`,
+		`{"result":"success","msg":"","events":[{"id":2,"type":"message","message":{"id":3}}]}`,
+	)
+	tc := bot.Client.(*testClient)
+	bot.Client = waitingClient{tc}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs, errs := bot.EventStream(ctx)
+	var got []int
+	for m := range msgs {
+		got = append(got, m.ID)
+		if len(got) == 3 {
+			cancel()
+		}
+	}
+	for err := range errs {
+		t.Errorf("got unexpected error %v", err)
+	}
+
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("got %v, expected messages 1, 2 and 3", got)
+	}
+	reqs := tc.Requests
+	if last := reqs[2].URL.Query().Get("last_event_id"); last != "1" {
+		t.Errorf("got last_event_id %q, expected the queue to advance", last)
+	}
+	if last := reqs[len(reqs)-1]; last.Method != "DELETE" {
+		t.Errorf("got %s %s, expected the queue to be deleted", last.Method, last.URL)
+	}
+}
+
+func TestEventStreamRegisterError(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"error","msg":"Invalid API key","code":"UNAUTHORIZED"}`)
+
+	msgs, errs := bot.EventStream(context.Background())
+	for range msgs {
+		t.Error("expected no messages")
+	}
+	if err, ok := <-errs; !ok || err == nil {
+		t.Error("expected the registration error")
+	}
+}
+
+func TestEventLoopN(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[
+			{"id":0,"type":"message","message":{"id":1,"content":"slow"}},
+			{"id":1,"type":"message","message":{"id":2,"content":"fast"}}
+		]}`,
+	)
+	tc := bot.Client.(*testClient)
+	bot.Client = waitingClient{tc}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fastDone := make(chan struct{})
+	var mu sync.Mutex
+	var handled []int
+	err := bot.EventLoopN(ctx, 2, func(b *Bot, m EventMessage) {
+		if m.Content == "slow" {
+			// only finishes if the fast message is handled alongside it
+			<-fastDone
+			cancel()
+		} else {
+			close(fastDone)
+		}
+		mu.Lock()
+		handled = append(handled, m.ID)
+		mu.Unlock()
+	})
+	if err != context.Canceled {
+		t.Fatalf("got %v, expected the context's error", err)
+	}
+
+	if !reflect.DeepEqual(handled, []int{2, 1}) {
+		t.Errorf("got %v, expected the fast message to finish first", handled)
+	}
+	last := tc.Requests[len(tc.Requests)-1]
+	if last.Method != "DELETE" {
+		t.Errorf("got %s, expected the queue to be deleted", last.Method)
+	}
+}
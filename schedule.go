@@ -0,0 +1,170 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ScheduleMessage schedules a message to be sent at deliverAt by the server,
+// and returns the id of the scheduled message. Unlike a timer in the bot,
+// the message is still sent if the bot restarts. Private messages are
+// addressed by user id, so the users with the message's Emails are looked up.
+func (b *Bot) ScheduleMessage(m Message, deliverAt time.Time) (int, error) {
+	if m.Content == "" {
+		return 0, errors.New("content cannot be empty")
+	}
+
+	values := url.Values{}
+	if len(m.Emails) != 0 || len(m.UserIDs) != 0 {
+		// scheduled messages are addressed by user id
+		ids := m.UserIDs
+		for _, email := range m.Emails {
+			u, err := b.GetUser(email)
+			if err != nil {
+				return 0, err
+			}
+			ids = append(ids, u.ID)
+		}
+		to, err := json.Marshal(ids)
+		if err != nil {
+			return 0, err
+		}
+		values.Set("type", "private")
+		values.Set("to", string(to))
+	} else {
+		if m.Stream == "" {
+			return 0, errors.New("stream cannot be empty")
+		}
+		if m.Topic == "" {
+			return 0, errors.New("topic cannot be empty")
+		}
+		id, err := b.GetStreamID(m.Stream)
+		if err != nil {
+			return 0, err
+		}
+		values.Set("type", "stream")
+		values.Set("to", strconv.Itoa(id))
+		values.Set("topic", m.Topic)
+	}
+	values.Set("content", m.Content)
+	values.Set("scheduled_delivery_timestamp", strconv.FormatInt(deliverAt.Unix(), 10))
+
+	req, err := b.constructRequest("POST", "scheduled_messages", values.Encode())
+	if err != nil {
+		return 0, err
+	}
+
+	var sj struct {
+		ScheduledMessageID int `json:"scheduled_message_id"`
+	}
+	err = b.doJSON(req, &sj)
+	if err != nil {
+		return 0, err
+	}
+
+	return sj.ScheduledMessageID, nil
+}
+
+// SendWithReminder sends a message, and schedules a reminder with
+// reminderContent to be sent to the same conversation after remindAfter.
+// It returns the id of the sent message and the id of the scheduled reminder.
+// If scheduling the reminder fails, the sent message's id is still returned.
+func (b *Bot) SendWithReminder(m Message, remindAfter time.Duration, reminderContent string) (int, int, error) {
+	if remindAfter <= 0 {
+		return 0, 0, errors.New("remindAfter must be positive")
+	}
+	if reminderContent == "" {
+		return 0, 0, errors.New("reminder content cannot be empty")
+	}
+
+	mr, err := b.SendMessage(m)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reminder := m
+	reminder.Content = reminderContent
+	id, err := b.ScheduleMessage(reminder, time.Now().Add(remindAfter))
+	if err != nil {
+		return mr.ID, 0, err
+	}
+
+	return mr.ID, id, nil
+}
+
+// A ScheduledMessage is a message the bot has scheduled, which the server
+// hasn't sent yet. A stream message has a StreamID and Topic, and a private
+// message has the UserIDs of its recipients. Failed reports whether the
+// server tried to send the message, and couldn't.
+type ScheduledMessage struct {
+	ID        int    `json:"scheduled_message_id"`
+	Type      string `json:"type"`
+	StreamID  int    `json:"-"`
+	UserIDs   []int  `json:"-"`
+	Topic     string `json:"topic"`
+	Content   string `json:"content"`
+	Timestamp int64  `json:"scheduled_delivery_timestamp"`
+	Failed    bool   `json:"failed"`
+}
+
+// UnmarshalJSON reads the recipient out of "to", which is the stream id for
+// a stream message, and the list of user ids for a private message.
+func (s *ScheduledMessage) UnmarshalJSON(b []byte) error {
+	type scheduledMessage ScheduledMessage
+	aux := struct {
+		*scheduledMessage
+		To json.RawMessage `json:"to"`
+	}{scheduledMessage: (*scheduledMessage)(s)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	s.StreamID, s.UserIDs = 0, nil
+	if len(aux.To) == 0 {
+		return nil
+	}
+	if aux.To[0] == '[' {
+		return json.Unmarshal(aux.To, &s.UserIDs)
+	}
+	return json.Unmarshal(aux.To, &s.StreamID)
+}
+
+// DeliverAt returns when the message is scheduled to be sent.
+func (s ScheduledMessage) DeliverAt() time.Time {
+	return time.Unix(s.Timestamp, 0)
+}
+
+// ListScheduledMessages returns the messages the bot has scheduled that
+// haven't been sent yet, such as to restore a reminder bot's pending
+// reminders after a restart.
+func (b *Bot) ListScheduledMessages() ([]ScheduledMessage, error) {
+	req, err := b.constructRequest("GET", "scheduled_messages", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sj struct {
+		ScheduledMessages []ScheduledMessage `json:"scheduled_messages"`
+	}
+	err = b.doJSON(req, &sj)
+	if err != nil {
+		return nil, err
+	}
+
+	return sj.ScheduledMessages, nil
+}
+
+// DeleteScheduledMessage cancels a scheduled message, so it isn't sent.
+func (b *Bot) DeleteScheduledMessage(id int) (*http.Response, error) {
+	req, err := b.constructRequest("DELETE", fmt.Sprintf("scheduled_messages/%d", id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
@@ -0,0 +1,110 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSendWithReminder(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","id":50}`,
+		`{"result":"success","msg":"","stream_id":7}`,
+		`{"result":"success","msg":"","scheduled_message_id":3}`,
+	)
+
+	before := time.Now()
+	msgID, reminderID, err := bot.SendWithReminder(
+		Message{Stream: "standup", Topic: "today", Content: "Post your update"},
+		time.Hour, "Reminder: post your update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgID != 50 || reminderID != 3 {
+		t.Errorf("got ids %d and %d, expected 50 and 3", msgID, reminderID)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) != 3 {
+		t.Fatalf("got %d requests, expected 3", len(reqs))
+	}
+	if reqs[1].URL.Query().Get("stream") != "standup" {
+		t.Errorf("got %s, expected the stream id to be looked up", reqs[1].URL)
+	}
+	body, _ := ioutil.ReadAll(reqs[2].Body)
+	values, _ := url.ParseQuery(string(body))
+	if values.Get("to") != "7" || values.Get("topic") != "today" || values.Get("type") != "stream" ||
+		values.Get("content") != "Reminder: post your update" {
+		t.Errorf("got scheduled message %v", values)
+	}
+	at, _ := strconv.ParseInt(values.Get("scheduled_delivery_timestamp"), 10, 64)
+	if d := time.Unix(at, 0).Sub(before); d < 59*time.Minute || d > 61*time.Minute {
+		t.Errorf("got the reminder scheduled %v away, expected an hour", d)
+	}
+
+	if _, _, err := bot.SendWithReminder(Message{}, 0, "x"); err == nil {
+		t.Error("expected an error for a non positive delay")
+	}
+}
+
+func TestScheduleMessagePrivate(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","user":{"user_id":8,"email":"a@example.com"}}`,
+		`{"result":"success","msg":"","user":{"user_id":9,"email":"b@example.com"}}`,
+		`{"result":"success","msg":"","scheduled_message_id":4}`,
+	)
+
+	id, err := bot.ScheduleMessage(Message{Emails: []string{"a@example.com", "b@example.com"}, Content: "hi"}, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4 {
+		t.Errorf("got id %d, expected 4", id)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if reqs[0].URL.Path != "/v1/users/a@example.com" {
+		t.Errorf("got path %q", reqs[0].URL.Path)
+	}
+	body, _ := ioutil.ReadAll(reqs[2].Body)
+	expected := "content=hi&scheduled_delivery_timestamp=1700000000&to=%5B8%2C9%5D&type=private"
+	if string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+}
+
+func TestListScheduledMessages(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","scheduled_messages":[
+		{"scheduled_message_id":27,"to":14,"type":"stream","content":"Hi","rendered_content":"<p>Hi</p>",
+		 "topic":"Hello","scheduled_delivery_timestamp":1681662420,"failed":false},
+		{"scheduled_message_id":28,"to":[8,9],"type":"private","content":"Ping","topic":"",
+		 "scheduled_delivery_timestamp":1681662480,"failed":true}
+	]}`, `{"result":"success","msg":""}`)
+
+	sms, err := bot.ListScheduledMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ScheduledMessage{
+		{ID: 27, Type: "stream", StreamID: 14, Topic: "Hello", Content: "Hi", Timestamp: 1681662420},
+		{ID: 28, Type: "private", UserIDs: []int{8, 9}, Content: "Ping", Timestamp: 1681662480, Failed: true},
+	}
+	if !reflect.DeepEqual(sms, expected) {
+		t.Errorf("got %+v, expected %+v", sms, expected)
+	}
+	if !sms[0].DeliverAt().Equal(time.Unix(1681662420, 0)) {
+		t.Errorf("got %v", sms[0].DeliverAt())
+	}
+
+	_, err = bot.DeleteScheduledMessage(27)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	if req.Method != "DELETE" || req.URL.Path != "/v1/scheduled_messages/27" {
+		t.Errorf("got %s %s", req.Method, req.URL.Path)
+	}
+}
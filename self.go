@@ -0,0 +1,90 @@
+package gozulipbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// sentHistorySize is how many of the ids of the messages the bot has sent
+// are remembered, to recognise them for SkipSelf and OwnMessageReactions.
+const sentHistorySize = 256
+
+// sentIDs is a fixed size set of the most recently sent message ids.
+type sentIDs struct {
+	ids  []int
+	next int
+	set  map[int]bool
+}
+
+func (s *sentIDs) add(id int) {
+	if s.set == nil {
+		s.ids = make([]int, sentHistorySize)
+		s.set = map[int]bool{}
+	}
+	if s.set[id] {
+		return
+	}
+	delete(s.set, s.ids[s.next])
+	s.ids[s.next] = id
+	s.set[id] = true
+	s.next = (s.next + 1) % len(s.ids)
+}
+
+// receives reports whether a message from the bot's queues should be
+// handled: its sender is allowed, and, if SkipSelf is set, it wasn't sent
+// by the bot.
+func (b *Bot) receives(e EventMessage) bool {
+	reason := ""
+	switch {
+	case !b.senderAllowed(e):
+		reason = "sender not allowed"
+	case b.SkipSelf && b.sentBySelf(e):
+		reason = "sent by the bot"
+	default:
+		return true
+	}
+	b.logger().Debug("dropped message", "message_id", e.ID, "sender_email", e.SenderEmail, "reason", reason)
+	return false
+}
+
+// sentBySelf reports whether the message was sent by the bot: by its email,
+// by its user id once GetProfile has found it, or by being one of the
+// messages the bot recently sent.
+func (b *Bot) sentBySelf(e EventMessage) bool {
+	if b.Email != "" && strings.EqualFold(e.SenderEmail, b.Email) {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.userID != 0 && e.SenderID == b.userID {
+		return true
+	}
+	return e.ID != 0 && b.sent.set[e.ID]
+}
+
+// rememberSent records the id of a message the bot sent, from a successful
+// response to sending it. The body is left intact.
+func (b *Bot) rememberSent(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	var mr MessageResponse
+	if json.Unmarshal(body, &mr) != nil || mr.ID == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.sent.add(mr.ID)
+	b.mu.Unlock()
+}
@@ -0,0 +1,66 @@
+package gozulipbot
+
+import "testing"
+
+func TestSkipSelf(t *testing.T) {
+	events := []byte(`{"result":"success","msg":"","events":[
+		{"id":0,"type":"message","message":{"id":10,"sender_email":"TestBot@example.com","sender_id":5}},
+		{"id":1,"type":"message","message":{"id":11,"sender_email":"user@example.com","sender_id":2}},
+		{"id":2,"type":"message","message":{"id":12,"sender_email":"user5@hidden.example.com","sender_id":5}},
+		{"id":3,"type":"message","message":{"id":13,"sender_email":"user6@hidden.example.com","sender_id":6}}
+	]}`)
+
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","id":13}`,
+		`{"result":"success","msg":"","user_id":5,"email":"testbot@example.com"}`,
+	)
+	q := &Queue{Bot: bot}
+
+	msgs, err := q.ParseEventMessages(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 {
+		t.Errorf("got %d messages without SkipSelf, expected 4", len(msgs))
+	}
+
+	bot.SkipSelf = true
+	_, err = bot.Message(Message{Stream: "test bots", Topic: "echo", Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = q.ParseEventMessages(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != 11 || msgs[1].ID != 12 {
+		t.Errorf("expected the bot's messages to be skipped by email and sent id, got %v", msgs)
+	}
+
+	if _, err := bot.GetProfile(); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err = q.ParseEventMessages(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != 11 {
+		t.Errorf("expected the bot's messages to be skipped by user id, got %v", msgs)
+	}
+}
+
+func TestSentIDs(t *testing.T) {
+	var s sentIDs
+	for id := 1; id <= sentHistorySize+1; id++ {
+		s.add(id)
+	}
+	if s.set[1] {
+		t.Error("expected the oldest id to be forgotten")
+	}
+	if !s.set[2] || !s.set[sentHistorySize+1] {
+		t.Error("expected the most recent ids to be remembered")
+	}
+	if len(s.set) != sentHistorySize {
+		t.Errorf("got %d ids, expected %d", len(s.set), sentHistorySize)
+	}
+}
@@ -0,0 +1,237 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSendQueueSize is the default capacity of the bot's send queue.
+const DefaultSendQueueSize = 100
+
+// ErrSendQueueFull is returned by Enqueue when the send queue is at capacity.
+var ErrSendQueueFull = errors.New("send queue is full")
+
+// ErrSendQueueStopped is returned by Enqueue while the bot's Stop is stopping
+// the send queue.
+var ErrSendQueueStopped = errors.New("send queue is stopped")
+
+// sendAttempts is how many times a rate limited message is tried before giving up.
+const sendAttempts = 5
+
+// sendQueue holds messages waiting to be sent by a background goroutine.
+// The goroutine runs until the bot's Stop cancels the queue's context.
+type sendQueue struct {
+	msgs   chan Message
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	pending int
+	err     error
+	waiters []chan struct{}
+	// stopped is set once the goroutine has stopped, so no more messages
+	// are accepted
+	stopped bool
+}
+
+// Enqueue adds a message to the bot's send queue. Messages in the queue are
+// sent in order by a background goroutine, which waits and retries when
+// Zulip rate limits the bot.
+//
+// Enqueue does not block. It returns ErrSendQueueFull if the queue is at
+// capacity. Errors from sending are returned by FlushQueue. Stop stops the
+// queue, dropping the messages not yet sent, so FlushQueue is called first
+// to send them.
+func (b *Bot) Enqueue(m Message) error {
+	sq := b.startSendQueue()
+
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+	if sq.stopped {
+		return ErrSendQueueStopped
+	}
+
+	select {
+	case sq.msgs <- m:
+		sq.pending++
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// FlushQueue waits for every message in the send queue to be sent, or for the
+// context to be done. It returns the first error encountered while sending
+// since the last flush, or the context's error.
+func (b *Bot) FlushQueue(ctx context.Context) error {
+	sq := b.startSendQueue()
+
+	sq.mu.Lock()
+	if sq.pending == 0 {
+		err := sq.takeErr()
+		sq.mu.Unlock()
+		return err
+	}
+	wait := make(chan struct{})
+	sq.waiters = append(sq.waiters, wait)
+	sq.mu.Unlock()
+
+	select {
+	case <-wait:
+		sq.mu.Lock()
+		defer sq.mu.Unlock()
+		return sq.takeErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startSendQueue returns the bot's send queue, starting it if needed.
+func (b *Bot) startSendQueue() *sendQueue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.sendQueue != nil {
+		return b.sendQueue
+	}
+
+	size := b.SendQueueSize
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sq := &sendQueue{msgs: make(chan Message, size), cancel: cancel}
+	go func() {
+		for {
+			select {
+			case m := <-sq.msgs:
+				_, err := b.sendWithBackoff(ctx, m)
+				sq.done(err)
+			case <-ctx.Done():
+				sq.stop(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	b.sendQueue = sq
+	return sq
+}
+
+// stopSendQueue stops the bot's send queue, if it was started. A later
+// Enqueue starts a new one.
+func (b *Bot) stopSendQueue() {
+	b.mu.Lock()
+	sq := b.sendQueue
+	b.sendQueue = nil
+	b.mu.Unlock()
+
+	if sq != nil {
+		sq.cancel()
+	}
+}
+
+// stop stops the queue accepting messages, and drops the messages not yet
+// sent, recording err for each so flushes return it.
+func (sq *sendQueue) stop(err error) {
+	sq.mu.Lock()
+	sq.stopped = true
+	n := len(sq.msgs)
+	for i := 0; i < n; i++ {
+		<-sq.msgs
+	}
+	sq.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		sq.done(err)
+	}
+}
+
+// done marks a message as handled, waking any flushes once the queue is empty.
+func (sq *sendQueue) done(err error) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	if err != nil && sq.err == nil {
+		sq.err = err
+	}
+	sq.pending--
+	if sq.pending == 0 {
+		for _, w := range sq.waiters {
+			close(w)
+		}
+		sq.waiters = nil
+	}
+}
+
+// takeErr returns and clears the queue's error. sq.mu must be held.
+func (sq *sendQueue) takeErr() error {
+	err := sq.err
+	sq.err = nil
+	return err
+}
+
+// sendWithBackoff sends a message, waiting and retrying when the bot is rate
+// limited, until the context is done. If the bot's Retry already retries
+// rate limited requests, the message is only sent once here.
+func (b *Bot) sendWithBackoff(ctx context.Context, m Message) (*MessageResponse, error) {
+	attempts := sendAttempts
+	if b.Retry.MaxAttempts > 1 {
+		attempts = 1
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := b.MessageCtx(ctx, m)
+		if resp == nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == attempts-1 {
+			var mr MessageResponse
+			err = decodeResponse(resp, &mr)
+			if err != nil {
+				return nil, err
+			}
+			return &mr, nil
+		}
+
+		resp.Body.Close()
+		if !sleepCtx(ctx, retryDelay(resp, attempt, time.Second)) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sendMessages sends each message in turn, waiting when rate limited, and
+// continuing past messages that fail. The results and errors are index
+// aligned with the messages.
+func (b *Bot) sendMessages(ms []Message) ([]MessageResponse, []error) {
+	results := make([]MessageResponse, len(ms))
+	errs := make([]error, len(ms))
+	for i, m := range ms {
+		mr, err := b.sendWithBackoff(context.Background(), m)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = *mr
+	}
+	return results, errs
+}
+
+// Announce posts the same content to the topic in each of the streams.
+// A failure to post to one stream doesn't stop the others. The results and
+// errors are index aligned with streams: a stream that was posted to has a
+// nil error, and one that wasn't has an empty MessageResponse.
+func (b *Bot) Announce(streams []string, topic, content string) ([]MessageResponse, []error) {
+	ms := make([]Message, len(streams))
+	for i, s := range streams {
+		ms[i] = Message{Stream: s, Topic: topic, Content: content}
+	}
+	return b.sendMessages(ms)
+}
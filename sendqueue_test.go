@@ -0,0 +1,159 @@
+package gozulipbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEnqueue(t *testing.T) {
+	limited := jsonResponse(429, `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT"}`)
+	limited.Header.Set("Retry-After", "0")
+	bot := getTestBot()
+	tc := bot.Client.(*testClient)
+	tc.Responses = []*http.Response{
+		jsonResponse(200, `{"result":"success","msg":"","id":1}`),
+		limited,
+		jsonResponse(200, `{"result":"success","msg":"","id":2}`),
+		jsonResponse(200, `{"result":"success","msg":"","id":3}`),
+	}
+
+	for _, c := range []string{"one", "two", "three"} {
+		err := bot.Enqueue(Message{Stream: "a", Topic: "b", Content: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bot.FlushQueue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rate limited message is sent again
+	if len(tc.Requests) != 4 {
+		t.Errorf("got %d requests, expected 4", len(tc.Requests))
+	}
+}
+
+// blockingClient blocks every request until release is closed.
+type blockingClient struct {
+	release chan struct{}
+}
+
+func (c *blockingClient) Do(r *http.Request) (*http.Response, error) {
+	<-c.release
+	return jsonResponse(200, `{"result":"success","msg":""}`), nil
+}
+
+func TestEnqueueFull(t *testing.T) {
+	client := &blockingClient{release: make(chan struct{})}
+	bot := getTestBot()
+	bot.Client = client
+	bot.SendQueueSize = 1
+
+	m := Message{Stream: "a", Topic: "b", Content: "c"}
+	var err error
+	// one message is taken by the sender, one waits in the queue
+	for i := 0; i < 3 && err == nil; i++ {
+		err = bot.Enqueue(m)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != ErrSendQueueFull {
+		t.Fatalf("got %v, expected ErrSendQueueFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := bot.FlushQueue(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, expected the flush to time out", err)
+	}
+
+	close(client.release)
+	if err := bot.FlushQueue(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	limited := jsonResponse(429, `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT"}`)
+	limited.Header.Set("Retry-After", "0")
+	bot := getTestBot()
+	tc := bot.Client.(*testClient)
+	tc.Responses = []*http.Response{
+		jsonResponse(200, `{"result":"success","msg":"","id":1}`),
+		jsonResponse(400, `{"result":"error","msg":"Stream 'missing' does not exist","code":"STREAM_DOES_NOT_EXIST"}`),
+		limited,
+		jsonResponse(200, `{"result":"success","msg":"","id":3}`),
+	}
+
+	results, errs := bot.Announce([]string{"a", "missing", "c"}, "release", "v2 is out")
+	if len(results) != 3 || len(errs) != 3 {
+		t.Fatalf("got %d results and %d errors, expected 3 of each", len(results), len(errs))
+	}
+	if results[0].ID != 1 || errs[0] != nil {
+		t.Errorf("got %v, %v for the first stream", results[0], errs[0])
+	}
+	if results[1].ID != 0 || errs[1] == nil {
+		t.Errorf("got %v, %v, expected the missing stream to fail", results[1], errs[1])
+	}
+	if results[2].ID != 3 || errs[2] != nil {
+		t.Errorf("got %v, %v, expected the rate limited stream to be retried", results[2], errs[2])
+	}
+}
+
+func TestStopSendQueue(t *testing.T) {
+	sent := make(chan struct{}, 10)
+	bot := getTestBot()
+	bot.Client = DoerFunc(func(r *http.Request) (*http.Response, error) {
+		sent <- struct{}{}
+		resp := jsonResponse(429, `{"result":"error","msg":"API usage exceeded rate limit","code":"RATE_LIMIT_HIT"}`)
+		resp.Header.Set("Retry-After", "60")
+		return resp, nil
+	})
+
+	m := Message{Stream: "a", Topic: "b", Content: "c"}
+	if err := bot.Enqueue(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.Enqueue(m); err != nil {
+		t.Fatal(err)
+	}
+	<-sent
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	flushed := make(chan error)
+	go func() { flushed <- bot.FlushQueue(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// the sender is waiting out the rate limit
+	if err := bot.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-flushed; err != context.Canceled {
+		t.Errorf("got %v, expected the stopped messages to fail with %v", err, context.Canceled)
+	}
+	if len(sent) != 0 {
+		t.Errorf("got %d more requests, expected none after Stop", len(sent))
+	}
+}
+
+func TestSendWithBackoffRetryConfig(t *testing.T) {
+	bot := getTestBot()
+	tc := bot.Client.(*testClient)
+	tc.Responses = []*http.Response{rateLimited(), rateLimited(), rateLimited()}
+	bot.Retry = RetryConfig{MaxAttempts: 2}
+
+	_, err := bot.sendWithBackoff(context.Background(), Message{Stream: "a", Topic: "b", Content: "c"})
+	var ze *ZulipError
+	if !errors.As(err, &ze) || ze.Code != CodeRateLimitHit {
+		t.Errorf("got %v, expected the rate limit error", err)
+	}
+	if len(tc.Requests) != 2 {
+		t.Errorf("got %d requests, expected only the bot's Retry attempts", len(tc.Requests))
+	}
+}
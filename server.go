@@ -0,0 +1,120 @@
+package gozulipbot
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// GetServerTimestamp returns the Zulip server's current time, in UTC, read
+// from the Date header of a request for the server's settings. Scheduling
+// against the server's clock avoids problems with clock skew.
+// The time has a resolution of one second.
+func (b *Bot) GetServerTimestamp() (time.Time, error) {
+	req, err := b.constructRequest("GET", "server_settings", "")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New("server response had no Date header")
+	}
+
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.UTC(), nil
+}
+
+// ServerSettings are a Zulip server's version and the settings of its realm.
+type ServerSettings struct {
+	ZulipVersion string `json:"zulip_version"`
+
+	// ZulipFeatureLevel increases with each change to the api. It is 0 for
+	// servers older than Zulip 3.0, which don't report one.
+	ZulipFeatureLevel int `json:"zulip_feature_level"`
+
+	ZulipMergeBase           string          `json:"zulip_merge_base"`
+	PushNotificationsEnabled bool            `json:"push_notifications_enabled"`
+	AuthenticationMethods    map[string]bool `json:"authentication_methods"`
+	RealmURI                 string          `json:"realm_uri"`
+	RealmURL                 string          `json:"realm_url"`
+	RealmName                string          `json:"realm_name"`
+	RealmIcon                string          `json:"realm_icon"`
+	RealmDescription         string          `json:"realm_description"`
+}
+
+// GetServerSettings fetches the server's version and realm settings, which
+// doesn't need the bot's credentials to be valid.
+func (b *Bot) GetServerSettings() (*ServerSettings, error) {
+	req, err := b.constructRequest("GET", "server_settings", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var s ServerSettings
+	err = b.doJSON(req, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.featureLevel = s.ZulipFeatureLevel
+	b.featureLevelKnown = true
+	b.mu.Unlock()
+
+	return &s, nil
+}
+
+// FeatureLevel returns the server's ZulipFeatureLevel. It is fetched with
+// GetServerSettings the first time, and remembered after that.
+func (b *Bot) FeatureLevel() (int, error) {
+	b.mu.Lock()
+	level, known := b.featureLevel, b.featureLevelKnown
+	b.mu.Unlock()
+	if known {
+		return level, nil
+	}
+
+	s, err := b.GetServerSettings()
+	if err != nil {
+		return 0, err
+	}
+	return s.ZulipFeatureLevel, nil
+}
+
+// SupportsFeatureLevel reports whether the server is at least at the given
+// feature level, from the changelog of the Zulip api, such as to choose
+// between an endpoint and the older one it replaced. It returns false if
+// the feature level can't be fetched.
+func (b *Bot) SupportsFeatureLevel(level int) bool {
+	l, err := b.FeatureLevel()
+	return err == nil && l >= level
+}
+
+// legacyServer reports whether the server is known to be older than Zulip
+// 3.0, from a feature level already fetched, without fetching it. Such a
+// server is sent a message's topic as "subject", and private responses are
+// addressed by email.
+func (b *Bot) legacyServer() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.featureLevelKnown && b.featureLevel == 0
+}
+
+// topicParam returns the name of the parameter a message's topic is sent in.
+func (b *Bot) topicParam() string {
+	if b.legacyServer() {
+		return "subject"
+	}
+	return "topic"
+}
@@ -0,0 +1,106 @@
+package gozulipbot
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGetServerTimestamp(t *testing.T) {
+	resp := jsonResponse(200, `{"result":"success","msg":""}`)
+	resp.Header.Set("Date", "Tue, 13 Oct 2026 09:30:00 GMT")
+	bot := getTestBot()
+	bot.Client.(*testClient).Response = resp
+
+	got, err := bot.GetServerTimestamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2026, 10, 13, 9, 30, 0, 0, time.UTC)
+	if !got.Equal(expected) || got.Location() != time.UTC {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	bot.Client.(*testClient).Response = jsonResponse(200, `{}`)
+	if _, err := bot.GetServerTimestamp(); err == nil {
+		t.Error("expected an error without a Date header")
+	}
+}
+
+func TestGetServerSettings(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","zulip_version":"8.0",
+		"zulip_feature_level":237,"realm_name":"Example","realm_uri":"https://example.zulipchat.com",
+		"authentication_methods":{"password":true,"github":false}}`)
+
+	s, err := bot.GetServerSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ZulipVersion != "8.0" || s.ZulipFeatureLevel != 237 || s.RealmName != "Example" || !s.AuthenticationMethods["password"] {
+		t.Errorf("got %+v", *s)
+	}
+
+	// the feature level is remembered, so no more requests are made
+	if !bot.SupportsFeatureLevel(200) || bot.SupportsFeatureLevel(300) {
+		t.Error("expected feature level 237 to support 200 and not 300")
+	}
+	if n := len(bot.Client.(*testClient).Requests); n != 1 {
+		t.Errorf("got %d requests, expected 1", n)
+	}
+}
+
+func TestFeatureLevel(t *testing.T) {
+	// servers before 3.0 don't have a feature level
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","zulip_version":"2.1.7"}`)
+
+	level, err := bot.FeatureLevel()
+	if err != nil || level != 0 {
+		t.Errorf("got %d %v, expected 0", level, err)
+	}
+	if bot.SupportsFeatureLevel(1) {
+		t.Error("expected an old server not to support feature level 1")
+	}
+}
+
+func TestLegacyServer(t *testing.T) {
+	ok := `{"result":"success","msg":""}`
+	bot := getTestBotWithResponses(ok, `{"result":"success","msg":"","zulip_version":"2.1.7"}`, ok, ok, ok)
+	tc := bot.Client.(*testClient)
+	form := func() url.Values {
+		tc.Request.ParseForm()
+		return tc.Request.PostForm
+	}
+
+	// nothing is fetched to pick the parameters
+	if _, err := bot.Message(Message{Stream: "ops", Topic: "deploys", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := form().Get("topic"); got != "deploys" {
+		t.Errorf("got topic %q, expected it to be sent as topic", got)
+	}
+
+	bot.FeatureLevel()
+	if _, err := bot.Message(Message{Stream: "ops", Topic: "deploys", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if f := form(); f.Get("subject") != "deploys" || f.Get("topic") != "" {
+		t.Errorf("got %v, expected the topic to be sent as subject", f)
+	}
+
+	if _, err := bot.EditMessageTopic(9, "", "renamed", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := form().Get("subject"); got != "renamed" {
+		t.Errorf("got subject %q, expected the new topic", got)
+	}
+
+	e := EventMessage{SenderID: 5, SenderEmail: "a@example.com", DisplayRecipient: DisplayRecipient{Users: []User{
+		{Email: "testbot@example.com", ID: 1}, {Email: "a@example.com", ID: 5},
+	}}}
+	if _, err := bot.Respond(e, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := form().Get("to"); got != "a@example.com" {
+		t.Errorf("got to %q, expected the response to be addressed by email", got)
+	}
+}
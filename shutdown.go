@@ -0,0 +1,90 @@
+package gozulipbot
+
+import (
+	"context"
+	"sync"
+)
+
+// A loop is a running polling loop, such as OnMessage, that Stop can cancel.
+type loop struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startLoop registers a polling loop with the bot, so Stop can cancel it,
+// and reports the bot's presence while any loop runs. The loop runs with the
+// returned context, and calls finish when it has returned and deleted its
+// queue.
+func (b *Bot) startLoop(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &loop{cancel: cancel, done: make(chan struct{})}
+
+	b.mu.Lock()
+	if b.loops == nil {
+		b.loops = map[*loop]bool{}
+	}
+	b.loops[l] = true
+	b.startPresence()
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.loops, l)
+			if len(b.loops) == 0 && b.stopPresence != nil {
+				b.stopPresence()
+				b.stopPresence = nil
+			}
+			b.mu.Unlock()
+			cancel()
+			close(l.done)
+		})
+	}
+}
+
+// Stop shuts the bot down. It cancels the bot's polling loops, such as
+// OnMessage and RunDispatcher, waits for them to finish the messages they
+// are handling and delete their queues, and then deletes any other queues
+// the bot registered, so none are left on the server. The stopped loops
+// return context.Canceled. With a QueueStore, the queues are saved and left
+// on the server instead, for the bot to resume. The send queue is stopped
+// too, dropping the messages Enqueue hasn't sent yet.
+//
+// If the context is done first, Stop returns the context's error, and the
+// loops go on stopping in the background. Otherwise the first error deleting
+// a queue is returned.
+func (b *Bot) Stop(ctx context.Context) error {
+	b.stopSendQueue()
+
+	b.mu.Lock()
+	loops := make([]*loop, 0, len(b.loops))
+	for l := range b.loops {
+		loops = append(loops, l)
+	}
+	b.mu.Unlock()
+
+	for _, l := range loops {
+		l.cancel()
+	}
+	for _, l := range loops {
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	b.mu.Lock()
+	queues := append([]*Queue(nil), b.Queues...)
+	b.mu.Unlock()
+
+	var firstErr error
+	for _, q := range queues {
+		err := b.closeQueue(ctx, q)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,57 @@
+package gozulipbot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStop(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":1}}]}`,
+	)
+	tc := bot.Client.(*testClient)
+	bot.Client = waitingClient{tc}
+
+	// a queue without a loop is deleted too
+	if _, err := bot.RegisterAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	finished := false
+	done := make(chan error)
+	go func() {
+		done <- bot.OnMessage(context.Background(), func(b *Bot, m EventMessage) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			finished = true
+		})
+	}()
+	<-started
+
+	if err := bot.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !finished {
+		t.Error("expected Stop to wait for the handler to finish")
+	}
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+
+	deleted := map[string]int{}
+	for _, req := range tc.Requests {
+		if req.Method == "DELETE" {
+			deleted[req.URL.Query().Get("queue_id")]++
+		}
+	}
+	if len(deleted) != 2 || deleted["q1"] != 1 || deleted["q2"] != 1 {
+		t.Errorf("got %v, expected both queues to be deleted once", deleted)
+	}
+	if len(bot.Queues) != 0 {
+		t.Errorf("got %d queues, expected none to be left", len(bot.Queues))
+	}
+}
@@ -0,0 +1,153 @@
+package gozulipbot
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageSplit posts a message like Message, but content longer than the bot's
+// MaxContentLength is split into several messages, posted in order to the same
+// stream and topic, or the same private recipients. Content is split between
+// lines where it can, and otherwise between words. A code block that is split
+// is closed at the end of one message and reopened at the start of the next.
+//
+// It returns a response for each message that was posted. Posting stops at
+// the first error, which is returned along with the responses so far, and
+// the response to the failed message if there was one.
+func (b *Bot) MessageSplit(m Message) ([]*http.Response, error) {
+	var resps []*http.Response
+	for _, chunk := range splitContent(m.Content, b.maxContentLength()) {
+		m.Content = chunk
+		resp, err := b.Message(m)
+		if resp != nil {
+			resps = append(resps, resp)
+		}
+		if err != nil {
+			return resps, err
+		}
+	}
+	return resps, nil
+}
+
+// splitContent splits content into pieces of at most limit characters.
+func splitContent(content string, limit int) []string {
+	if utf8.RuneCountInString(content) <= limit {
+		return []string{content}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	curLen := 0
+	// fence is the line opening the code block being split, if any
+	fence := ""
+
+	flush := func() {
+		chunk := strings.TrimRight(cur.String(), "\n")
+		if fence != "" {
+			chunk += "\n" + fenceMarker(fence)
+		}
+		if strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+		cur.Reset()
+		curLen = 0
+		if fence != "" {
+			cur.WriteString(fence + "\n")
+			curLen = utf8.RuneCountInString(fence) + 1
+		}
+	}
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+		after := nextFence(fence, line)
+
+		// leave room to close the code block the chunk ends in
+		reserve, header := 0, 0
+		if after != "" {
+			reserve = utf8.RuneCountInString(fenceMarker(after)) + 1
+		}
+		if fence != "" {
+			header = utf8.RuneCountInString(fence) + 1
+			if r := utf8.RuneCountInString(fenceMarker(fence)) + 1; r > reserve {
+				reserve = r
+			}
+		}
+
+		n := utf8.RuneCountInString(line)
+		if curLen+n+reserve > limit && curLen > header {
+			flush()
+		}
+
+		if width := limit - curLen - reserve; n > width {
+			if width < 1 {
+				width = 1
+			}
+			pieces := splitLine(line, width)
+			for _, p := range pieces[:len(pieces)-1] {
+				cur.WriteString(p)
+				flush()
+			}
+			line = pieces[len(pieces)-1]
+			n = utf8.RuneCountInString(line)
+		}
+
+		cur.WriteString(line)
+		curLen += n
+		fence = after
+	}
+
+	fence = ""
+	flush()
+	return chunks
+}
+
+// nextFence returns the opening line of the code block that's open after
+// line, given the one open before it.
+func nextFence(fence, line string) string {
+	trimmed := strings.TrimSpace(line)
+	if fence == "" {
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			return strings.TrimRight(line, "\n")
+		}
+		return ""
+	}
+	if marker := fenceMarker(fence); strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == "" {
+		return ""
+	}
+	return fence
+}
+
+// fenceMarker returns the run of backticks or tildes opening a code block.
+func fenceMarker(fence string) string {
+	fence = strings.TrimSpace(fence)
+	c := fence[0]
+	i := 0
+	for i < len(fence) && fence[i] == c {
+		i++
+	}
+	return fence[:i]
+}
+
+// splitLine splits s into pieces of at most width characters, breaking after
+// spaces where it can.
+func splitLine(s string, width int) []string {
+	var pieces []string
+	for utf8.RuneCountInString(s) > width {
+		// the byte offset of the width'th character
+		end := 0
+		for i := 0; i < width; i++ {
+			_, size := utf8.DecodeRuneInString(s[end:])
+			end += size
+		}
+		cut := end
+		if i := strings.LastIndexAny(s[:end], " \t"); i > 0 {
+			cut = i + 1
+		}
+		pieces = append(pieces, s[:cut])
+		s = s[cut:]
+	}
+	return append(pieces, s)
+}
@@ -0,0 +1,71 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitContent(t *testing.T) {
+	type C struct {
+		Content  string
+		Limit    int
+		Expected []string
+	}
+
+	cases := map[string]C{
+		"short": C{Content: "hello there", Limit: 20, Expected: []string{"hello there"}},
+		"lines": C{Content: "one two\nthree four\nfive", Limit: 12,
+			Expected: []string{"one two", "three four", "five"}},
+		"words": C{Content: "one two three four five", Limit: 10,
+			Expected: []string{"one two ", "three ", "four five"}},
+		"no spaces": C{Content: "abcdefghij", Limit: 4,
+			Expected: []string{"abcd", "efgh", "ij"}},
+		"code block": C{Content: "look:\n```go\na := 1\nb := 2\n```\ndone", Limit: 24,
+			Expected: []string{"look:\n```go\na := 1\n```", "```go\nb := 2\n```\ndone"}},
+	}
+
+	for k, c := range cases {
+		got := splitContent(c.Content, c.Limit)
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("got %q, expected %q, case %q", got, c.Expected, k)
+		}
+		for _, chunk := range got {
+			if n := utf8.RuneCountInString(chunk); n > c.Limit {
+				t.Errorf("got a chunk of %d characters, over the limit of %d, case %q", n, c.Limit, k)
+			}
+		}
+	}
+}
+
+func TestMessageSplit(t *testing.T) {
+	ok := `{"result":"success","msg":"","id":1}`
+	bot := getTestBotWithResponses(ok, ok,
+		`{"result":"error","msg":"Stream 'a' does not exist","code":"STREAM_DOES_NOT_EXIST"}`)
+	bot.MaxContentLength = 6
+
+	resps, err := bot.MessageSplit(Message{Stream: "a", Topic: "b", Content: "aa bb\ncc dd\nee ff\ngg"})
+	if _, ok := err.(*ZulipError); !ok {
+		t.Errorf("got %v, expected the error from the third message", err)
+	}
+	if len(resps) != 3 || resps[0].StatusCode != 200 || resps[2].StatusCode != 200 {
+		t.Errorf("got %d responses, expected one for each message sent", len(resps))
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) != 3 {
+		t.Fatalf("got %d requests, expected posting to stop at the error", len(reqs))
+	}
+	var contents []string
+	for _, req := range reqs {
+		body, _ := ioutil.ReadAll(req.Body)
+		values, _ := url.ParseQuery(string(body))
+		contents = append(contents, values.Get("content"))
+	}
+	if got := strings.Join(contents, "|"); got != "aa bb|cc dd|ee ff" {
+		t.Errorf("got %q", got)
+	}
+}
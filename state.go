@@ -0,0 +1,156 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// fetchState registers a short lived queue to fetch the initial state for
+// the given EventTypes, unmarshals the register response into v, and then
+// deletes the queue.
+func (b *Bot) fetchState(v interface{}, ets ...EventType) error {
+	req, err := b.constructRegisterRequest(RegisterOptions{EventTypes: ets})
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.doRegister(req)
+	if err != nil {
+		return err
+	}
+
+	var raw json.RawMessage
+	err = decodeResponse(resp, &raw)
+	if err != nil {
+		return err
+	}
+
+	q := &Queue{Bot: b}
+	if err = json.Unmarshal(raw, q); err == nil && q.ID != "" {
+		// the queue was only needed for its state, so clean it up
+		resp, err := q.Delete()
+		if err == nil && resp != nil {
+			resp.Body.Close()
+		}
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// A DMConversation is a private conversation the bot has taken part in.
+// UserIDs lists the other users in the conversation.
+type DMConversation struct {
+	UserIDs      []int `json:"user_ids"`
+	MaxMessageID int   `json:"max_message_id"`
+}
+
+// GetRecentDMs returns the bot's recent private conversations, with the most
+// recently active conversation first.
+func (b *Bot) GetRecentDMs() ([]DMConversation, error) {
+	var state recentDMState
+	err := b.fetchState(&state, "recent_private_conversations")
+	if err != nil {
+		return nil, err
+	}
+
+	return state.sorted(), nil
+}
+
+type recentDMState struct {
+	RecentPrivateConversations []DMConversation `json:"recent_private_conversations"`
+}
+
+// sorted returns the conversations ordered by MaxMessageID, descending.
+func (s recentDMState) sorted() []DMConversation {
+	dms := s.RecentPrivateConversations
+	sort.SliceStable(dms, func(i, j int) bool {
+		return dms[i].MaxMessageID > dms[j].MaxMessageID
+	})
+	return dms
+}
+
+// RealmSettings are realm wide policies a bot may want to adapt to.
+//
+// A limit of 0 seconds means there is no limit. Newer servers replace
+// AllowMessageDeleting with DeleteOwnMessagePolicy, which is 0 on older ones.
+type RealmSettings struct {
+	Name                             string `json:"realm_name"`
+	MandatoryTopics                  bool   `json:"realm_mandatory_topics"`
+	AllowMessageEditing              bool   `json:"realm_allow_message_editing"`
+	MessageContentEditLimitSeconds   int    `json:"realm_message_content_edit_limit_seconds"`
+	AllowMessageDeleting             bool   `json:"realm_allow_message_deleting"`
+	DeleteOwnMessagePolicy           int    `json:"realm_delete_own_message_policy"`
+	MessageContentDeleteLimitSeconds int    `json:"realm_message_content_delete_limit_seconds"`
+	WaitingPeriodThreshold           int    `json:"realm_waiting_period_threshold"`
+}
+
+// GetRealmSettings returns the settings of the bot's realm.
+func (b *Bot) GetRealmSettings() (*RealmSettings, error) {
+	var rs RealmSettings
+	err := b.fetchState(&rs, "realm")
+	if err != nil {
+		return nil, err
+	}
+
+	return &rs, nil
+}
+
+// Policies for who may delete their own messages.
+const (
+	DeleteOwnMessagePolicyMembers     = 1
+	DeleteOwnMessagePolicyAdmins      = 2
+	DeleteOwnMessagePolicyFullMembers = 3
+	DeleteOwnMessagePolicyModerators  = 4
+	DeleteOwnMessagePolicyEveryone    = 5
+)
+
+// CanDeleteMessage reports whether the bot is allowed to delete the message,
+// based on the realm's settings, the bot's role, and the message's age.
+// It returns false if the settings or the bot's role can't be fetched.
+func (b *Bot) CanDeleteMessage(e EventMessage) bool {
+	me, err := b.GetProfile()
+	if err != nil {
+		return false
+	}
+
+	isAdmin := me.IsAdmin || me.Role == RoleOwner || me.Role == RoleAdmin
+	if isAdmin {
+		// administrators can delete any message
+		return true
+	}
+	if e.SenderEmail != b.Email && (e.SenderID == 0 || e.SenderID != me.ID) {
+		return false
+	}
+
+	rs, err := b.GetRealmSettings()
+	if err != nil {
+		return false
+	}
+
+	switch rs.DeleteOwnMessagePolicy {
+	case 0:
+		if !rs.AllowMessageDeleting {
+			return false
+		}
+	case DeleteOwnMessagePolicyEveryone:
+	case DeleteOwnMessagePolicyMembers, DeleteOwnMessagePolicyFullMembers:
+		if me.Role == RoleGuest {
+			return false
+		}
+	case DeleteOwnMessagePolicyModerators:
+		if me.Role == 0 || me.Role > RoleModerator {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if limit := rs.MessageContentDeleteLimitSeconds; limit > 0 {
+		if time.Since(e.Time()) > time.Duration(limit)*time.Second {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,114 @@
+package gozulipbot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRecentDMs(t *testing.T) {
+	register := `{"result":"success","msg":"","queue_id":"1517975029:0","last_event_id":-1,
+		"recent_private_conversations":[
+			{"max_message_id":10,"user_ids":[2]},
+			{"max_message_id":30,"user_ids":[3,4]},
+			{"max_message_id":20,"user_ids":[]}
+		]}`
+	bot := getTestBotWithResponses(register, `{"result":"success","msg":""}`)
+
+	dms, err := bot.GetRecentDMs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []DMConversation{
+		{UserIDs: []int{3, 4}, MaxMessageID: 30},
+		{UserIDs: []int{}, MaxMessageID: 20},
+		{UserIDs: []int{2}, MaxMessageID: 10},
+	}
+	if !reflect.DeepEqual(dms, expected) {
+		t.Errorf("got %v, expected %v", dms, expected)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) != 2 || reqs[1].Method != "DELETE" {
+		t.Errorf("expected the state queue to be deleted")
+	}
+}
+
+func TestGetRealmSettings(t *testing.T) {
+	register := `{"result":"success","msg":"","queue_id":"1517975029:1","last_event_id":-1,
+		"realm_name":"Example","realm_mandatory_topics":true,"realm_allow_message_editing":true,
+		"realm_message_content_edit_limit_seconds":600,"realm_allow_message_deleting":false,
+		"realm_message_content_delete_limit_seconds":null,"realm_waiting_period_threshold":3,
+		"realm_emails_restricted_to_domains":false}`
+	bot := getTestBotWithResponses(register, `{"result":"success","msg":""}`)
+
+	rs, err := bot.GetRealmSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := RealmSettings{
+		Name:                           "Example",
+		MandatoryTopics:                true,
+		AllowMessageEditing:            true,
+		MessageContentEditLimitSeconds: 600,
+		WaitingPeriodThreshold:         3,
+	}
+	if *rs != expected {
+		t.Errorf("got %+v, expected %+v", *rs, expected)
+	}
+
+	q := bot.Client.(*testClient).Requests[0]
+	q.ParseForm()
+	if et := q.PostForm.Get("event_types"); et != `["realm"]` {
+		t.Errorf("got event types %q", et)
+	}
+}
+
+func TestCanDeleteMessage(t *testing.T) {
+	member := `{"result":"success","msg":"","user_id":5,"email":"testbot@example.com","role":400}`
+	admin := `{"result":"success","msg":"","user_id":5,"email":"testbot@example.com","role":200,"is_admin":true}`
+	realm := func(settings string) string {
+		return `{"result":"success","msg":"","queue_id":"q","last_event_id":-1,` + settings + `}`
+	}
+	now := int(time.Now().Unix())
+	own := EventMessage{ID: 1, SenderEmail: "testbot@example.com", SenderID: 5, Timestamp: now - 60}
+	old := EventMessage{ID: 2, SenderEmail: "testbot@example.com", SenderID: 5, Timestamp: now - 3600}
+	other := EventMessage{ID: 3, SenderEmail: "someone@example.com", SenderID: 6, Timestamp: now - 60}
+
+	type C struct {
+		E         EventMessage
+		Responses []string
+		Expected  bool
+	}
+	cases := map[string]C{
+		"admin":          C{E: other, Responses: []string{admin}, Expected: true},
+		"someone else's": C{E: other, Responses: []string{member}, Expected: false},
+		"own, allowed": C{E: own, Responses: []string{member,
+			realm(`"realm_allow_message_deleting":true,"realm_message_content_delete_limit_seconds":600`), "{}"},
+			Expected: true},
+		"own, too old": C{E: old, Responses: []string{member,
+			realm(`"realm_allow_message_deleting":true,"realm_message_content_delete_limit_seconds":600`), "{}"},
+			Expected: false},
+		"own, disallowed": C{E: own, Responses: []string{member,
+			realm(`"realm_allow_message_deleting":false`), "{}"},
+			Expected: false},
+		"own, admins only policy": C{E: own, Responses: []string{member,
+			realm(`"realm_delete_own_message_policy":2`), "{}"},
+			Expected: false},
+		"own, everyone policy": C{E: old, Responses: []string{member,
+			realm(`"realm_delete_own_message_policy":5,"realm_message_content_delete_limit_seconds":null`), "{}"},
+			Expected: true},
+		"unknown settings": C{E: own, Responses: []string{member,
+			`{"result":"error","msg":"Internal server error"}`},
+			Expected: false},
+	}
+
+	for name, c := range cases {
+		bot := getTestBotWithResponses(c.Responses...)
+		if got := bot.CanDeleteMessage(c.E); got != c.Expected {
+			t.Errorf("got %v, expected %v, case %q", got, c.Expected, name)
+		}
+	}
+}
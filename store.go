@@ -0,0 +1,243 @@
+package gozulipbot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// QueueState is what a QueueStore saves of a queue, to resume it.
+type QueueState struct {
+	ID           string `json:"queue_id"`
+	LastEventID  int    `json:"last_event_id"`
+	MaxMessageID int    `json:"max_message_id"`
+}
+
+// A QueueStore saves the bot's queues, so a restarted bot resumes them and
+// receives the events sent while it was down, instead of registering new
+// queues. Queues are saved by key, which identifies the options they were
+// registered with and the account they belong to, so a bot with several
+// queues resumes each of them, and bots can share a store.
+//
+// LoadQueue reports false if there is no queue saved with the key.
+type QueueStore interface {
+	LoadQueue(key string) (QueueState, bool, error)
+	SaveQueue(key string, s QueueState) error
+	DeleteQueue(key string) error
+}
+
+// queueKey returns the key the bot's queue registered with opts is saved by.
+func (b *Bot) queueKey(opts RegisterOptions) (string, error) {
+	values, err := registerValues(opts)
+	if err != nil {
+		return "", err
+	}
+	values.Set("bot_email", b.Email)
+	values.Set("bot_api_url", b.APIURL)
+	return values.Encode(), nil
+}
+
+// claimStoreKey marks the saved queue with the key as open, and reports
+// false if it already is.
+func (b *Bot) claimStoreKey(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.storeKeys[key] {
+		return false
+	}
+	if b.storeKeys == nil {
+		b.storeKeys = map[string]bool{}
+	}
+	b.storeKeys[key] = true
+	return true
+}
+
+// releaseStoreKey marks the saved queue q was claimed for as no longer open,
+// when q fails to register.
+func (b *Bot) releaseStoreKey(q *Queue) {
+	if q.storeKey == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.storeKeys, q.storeKey)
+}
+
+// resumeQueue returns the queue saved in the bot's QueueStore for opts, or
+// nil if there isn't one. The queue may have expired on the server while the
+// bot was down, in which case it is registered again as it is polled.
+func (b *Bot) resumeQueue(key string, opts RegisterOptions) *Queue {
+	s, ok, err := b.QueueStore.LoadQueue(key)
+	if err != nil {
+		b.logger().Warn("loading saved queue failed", "error", err)
+		return nil
+	}
+	if !ok || s.ID == "" {
+		return nil
+	}
+
+	b.logger().Info("resumed queue", "queue_id", s.ID, "last_event_id", s.LastEventID)
+	return &Queue{
+		ID:           s.ID,
+		LastEventID:  s.LastEventID,
+		MaxMessageID: s.MaxMessageID,
+		Bot:          b,
+		opts:         opts,
+		storeKey:     key,
+		savedEventID: s.LastEventID,
+		lastEventAt:  time.Now(),
+	}
+}
+
+// save saves the queue in the bot's QueueStore, if it is stored there.
+// Failures are logged, and don't stop the queue from being polled.
+func (q *Queue) save() {
+	if q.storeKey == "" || q.Bot.QueueStore == nil {
+		return
+	}
+
+	q.mu.Lock()
+	s := QueueState{ID: q.ID, LastEventID: q.LastEventID, MaxMessageID: q.MaxMessageID}
+	q.mu.Unlock()
+
+	if err := q.Bot.QueueStore.SaveQueue(q.storeKey, s); err != nil {
+		q.Bot.logger().Warn("saving queue failed", "queue_id", s.ID, "error", err)
+		return
+	}
+	q.mu.Lock()
+	q.savedEventID = s.LastEventID
+	q.mu.Unlock()
+}
+
+// saveProgress saves the queue if it has moved on since it was last saved.
+// It is called before waiting for more events, once the previous events have
+// been handled, so an event is never saved as received before it is handled.
+func (q *Queue) saveProgress() {
+	q.mu.Lock()
+	moved := q.LastEventID != q.savedEventID
+	q.mu.Unlock()
+	if moved {
+		q.save()
+	}
+}
+
+// closeQueue is called when the bot has finished with a queue, such as when
+// a polling loop returns. A queue in the bot's QueueStore is saved, and left
+// on the server for the bot to resume when it restarts; Zulip removes it if
+// the bot doesn't come back within a few minutes. Any other queue is deleted.
+func (b *Bot) closeQueue(ctx context.Context, q *Queue) error {
+	if q.storeKey != "" && b.QueueStore != nil {
+		b.removeQueue(q)
+		q.save()
+		return nil
+	}
+
+	resp, err := q.DeleteCtx(ctx)
+	if err != nil {
+		return err
+	}
+	err = parseResponse(resp)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+// A FileQueueStore is a QueueStore that saves queues in a json file.
+type FileQueueStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileQueueStore returns a QueueStore saving queues in the file at path,
+// which is created when the first queue is saved.
+func NewFileQueueStore(path string) *FileQueueStore {
+	return &FileQueueStore{path: path}
+}
+
+// LoadQueue returns the queue saved with the key.
+func (s *FileQueueStore) LoadQueue(key string) (QueueState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queues, err := s.read()
+	if err != nil {
+		return QueueState{}, false, err
+	}
+	qs, ok := queues[key]
+	return qs, ok, nil
+}
+
+// SaveQueue saves the queue with the key, replacing any saved before.
+func (s *FileQueueStore) SaveQueue(key string, qs QueueState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queues, err := s.read()
+	if err != nil {
+		return err
+	}
+	queues[key] = qs
+	return s.write(queues)
+}
+
+// DeleteQueue removes the queue saved with the key.
+func (s *FileQueueStore) DeleteQueue(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queues, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := queues[key]; !ok {
+		return nil
+	}
+	delete(queues, key)
+	return s.write(queues)
+}
+
+// read returns the saved queues. A missing file has none.
+func (s *FileQueueStore) read() (map[string]QueueState, error) {
+	queues := map[string]QueueState{}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return queues, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// write saves the queues, replacing the file in one step, so a crash while
+// writing doesn't leave it half written.
+func (s *FileQueueStore) write(queues map[string]QueueState) error {
+	data, err := json.MarshalIndent(queues, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
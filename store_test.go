@@ -0,0 +1,197 @@
+package gozulipbot
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileQueueStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queues.json")
+
+	s := NewFileQueueStore(path)
+	if _, ok, err := s.LoadQueue("messages"); ok || err != nil {
+		t.Fatalf("got %v %v, expected no saved queue", ok, err)
+	}
+
+	saved := QueueState{ID: "q1", LastEventID: 4, MaxMessageID: 20}
+	if err := s.SaveQueue("messages", saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveQueue("reactions", QueueState{ID: "q2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store reads the same file, as after a restart
+	s = NewFileQueueStore(path)
+	got, ok, err := s.LoadQueue("messages")
+	if err != nil || !ok || got != saved {
+		t.Errorf("got %+v %v %v, expected %+v", got, ok, err, saved)
+	}
+
+	if err := s.DeleteQueue("messages"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.LoadQueue("messages"); ok {
+		t.Error("expected the queue to be deleted")
+	}
+	if _, ok, _ := s.LoadQueue("reactions"); !ok {
+		t.Error("expected the other queue to be kept")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.LoadQueue("reactions"); err == nil {
+		t.Error("expected an error for a malformed file")
+	}
+}
+
+func TestQueueStoreResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileQueueStore(filepath.Join(dir, "queues.json"))
+
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1,"max_message_id":9}`,
+		`{"result":"success","msg":"","events":[{"id":0,"type":"message","message":{"id":10}},{"id":1,"type":"heartbeat"}]}`,
+		`{"result":"success","msg":"","events":[{"id":2,"type":"heartbeat"}]}`,
+	)
+	bot.QueueStore = store
+
+	q, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := bot.queueKey(q.opts)
+	if s, _, _ := store.LoadQueue(key); s.ID != "q1" || s.LastEventID != -1 {
+		t.Errorf("got %+v, expected the new queue to be saved", s)
+	}
+
+	q.GetEvents()
+	if s, _, _ := store.LoadQueue(key); s.LastEventID != -1 {
+		t.Errorf("got %+v, expected events not to be saved before the next poll", s)
+	}
+	q.GetEvents()
+	if s, _, _ := store.LoadQueue(key); s.LastEventID != 1 {
+		t.Errorf("got %+v, expected the handled events to be saved", s)
+	}
+
+	if err := bot.closeQueue(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if s, _, _ := store.LoadQueue(key); s.ID != "q1" || s.LastEventID != 2 {
+		t.Errorf("got %+v, expected the queue to be saved as it closed", s)
+	}
+
+	// a restarted bot resumes the queue, without registering
+	restarted := getTestBotWithResponses(`{"result":"success","msg":""}`)
+	restarted.QueueStore = store
+	rq, err := restarted.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rq.ID != "q1" || rq.LastEventID != 2 || rq.MaxMessageID != 9 {
+		t.Errorf("got queue %q at %d, %d, expected q1 at 2, 9", rq.ID, rq.LastEventID, rq.MaxMessageID)
+	}
+	if n := len(restarted.Client.(*testClient).Requests); n != 0 {
+		t.Errorf("got %d requests, expected the queue to be resumed without any", n)
+	}
+	if len(restarted.Queues) != 1 {
+		t.Errorf("got %d queues, expected the resumed queue", len(restarted.Queues))
+	}
+
+	if _, err := rq.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.LoadQueue(key); ok {
+		t.Error("expected deleting the queue to remove it from the store")
+	}
+}
+
+func TestQueueStoreSharedByBots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileQueueStore(filepath.Join(dir, "queues.json"))
+
+	ops := getTestBotWithResponses(`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`)
+	ops.QueueStore = store
+	if _, err := ops.RegisterAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a bot on another account, with the same options, registers its own queue
+	support := getTestBotWithResponses(`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`)
+	support.Email = "support-bot@example.com"
+	support.QueueStore = store
+	q, err := support.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.ID != "q2" {
+		t.Errorf("got queue %q, expected the bot's own q2", q.ID)
+	}
+
+	// and so does one on another server
+	other := getTestBotWithResponses(`{"result":"success","msg":"","queue_id":"q3","last_event_id":-1}`)
+	other.APIURL = "https://other.example.com/api/v1/"
+	other.QueueStore = store
+	if q, err := other.RegisterAll(); err != nil || q.ID != "q3" {
+		t.Errorf("got queue %v %v, expected the bot's own q3", q, err)
+	}
+}
+
+func TestQueueStoreTwoLoops(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queuestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileQueueStore(filepath.Join(dir, "queues.json"))
+
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","queue_id":"q1","last_event_id":-1}`,
+		`{"result":"success","msg":"","queue_id":"q2","last_event_id":-1}`,
+	)
+	bot.QueueStore = store
+
+	first, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != "q2" {
+		t.Errorf("got queue %q, expected a new queue while q1 is open", second.ID)
+	}
+	key, _ := bot.queueKey(first.opts)
+	if s, _, _ := store.LoadQueue(key); s.ID != "q1" {
+		t.Errorf("got %+v, expected the first queue to stay saved", s)
+	}
+
+	if err := bot.closeQueue(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.ID != "q1" {
+		t.Errorf("got queue %q, expected q1 to be resumed once closed", resumed.ID)
+	}
+}
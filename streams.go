@@ -0,0 +1,521 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// GetStreamList gets the raw http response when requesting all public streams.
+func (b *Bot) GetStreamList() (*http.Response, error) {
+	req, err := b.constructRequest("GET", "streams", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.do(req)
+}
+
+type StreamJSON struct {
+	Msg     string   `json:"msg"`
+	Streams []Stream `json:"streams"`
+	Result  string   `json:"result"`
+}
+
+// GetStreams returns a list of all public streams
+func (b *Bot) GetStreams() ([]string, error) {
+	list, err := b.ListStreams()
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []string
+	for _, s := range list {
+		streams = append(streams, s.Name)
+	}
+
+	return streams, nil
+}
+
+// ListStreams returns the streams the bot can see.
+func (b *Bot) ListStreams() ([]Stream, error) {
+	req, err := b.constructRequest("GET", "streams", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sj StreamJSON
+	err = b.doJSON(req, &sj)
+	if err != nil {
+		return nil, err
+	}
+
+	return sj.Streams, nil
+}
+
+// A Stream is the metadata Zulip keeps about a stream.
+//
+// MessageRetentionDays is 0 when the stream uses the realm's retention policy,
+// and -1 when messages are kept forever.
+type Stream struct {
+	StreamID                   int    `json:"stream_id"`
+	Name                       string `json:"name"`
+	Description                string `json:"description"`
+	RenderedDescription        string `json:"rendered_description"`
+	InviteOnly                 bool   `json:"invite_only"`
+	IsWebPublic                bool   `json:"is_web_public"`
+	IsAnnouncementOnly         bool   `json:"is_announcement_only"`
+	StreamPostPolicy           int    `json:"stream_post_policy"`
+	HistoryPublicToSubscribers bool   `json:"history_public_to_subscribers"`
+	MessageRetentionDays       int    `json:"-"`
+	FirstMessageID             int    `json:"first_message_id"`
+	DateCreated                int    `json:"date_created"`
+}
+
+// UnmarshalJSON handles Zulip's "unlimited" message retention.
+func (s *Stream) UnmarshalJSON(b []byte) error {
+	type stream Stream
+	aux := struct {
+		*stream
+		MessageRetentionDays json.RawMessage `json:"message_retention_days"`
+	}{stream: (*stream)(s)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	s.MessageRetentionDays = 0
+	switch r := string(aux.MessageRetentionDays); r {
+	case "", "null":
+	case `"unlimited"`:
+		s.MessageRetentionDays = -1
+	default:
+		if err := json.Unmarshal(aux.MessageRetentionDays, &s.MessageRetentionDays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrStreamNotFound is returned when a stream doesn't exist, or isn't visible to the bot.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Stream post policies, which restrict who may post to a stream.
+const (
+	StreamPostPolicyEveryone           = 1
+	StreamPostPolicyAdmins             = 2
+	StreamPostPolicyRestrictNewMembers = 3
+	StreamPostPolicyModerators         = 4
+)
+
+// GetStream returns the stream with the given id.
+func (b *Bot) GetStream(streamID int) (*Stream, error) {
+	req, err := b.constructRequest("GET", fmt.Sprintf("streams/%d", streamID), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var sj struct {
+		Stream Stream `json:"stream"`
+	}
+	err = b.doJSON(req, &sj)
+	var ze *ZulipError
+	if errors.As(err, &ze) && (ze.Code == CodeStreamDoesNotExist || ze.Msg == "Invalid stream ID") {
+		return nil, ErrStreamNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sj.Stream, nil
+}
+
+// GetStreamID returns the id of the stream with the given name.
+func (b *Bot) GetStreamID(name string) (int, error) {
+	values := url.Values{}
+	values.Set("stream", name)
+
+	req, err := b.constructRequest("GET", "get_stream_id?"+values.Encode(), "")
+	if err != nil {
+		return 0, err
+	}
+
+	var sj struct {
+		StreamID int `json:"stream_id"`
+	}
+	err = b.doJSON(req, &sj)
+	var ze *ZulipError
+	if errors.As(err, &ze) && ze.Code == CodeStreamDoesNotExist {
+		return 0, ErrStreamNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return sj.StreamID, nil
+}
+
+// CanPostToStream reports whether the bot is allowed to post to the given
+// stream, based on the stream's posting policy and the bot's role.
+//
+// Streams restricted to full members are treated as open to anyone who isn't
+// a guest, since the realm's waiting period isn't checked.
+func (b *Bot) CanPostToStream(streamID int) (bool, error) {
+	s, err := b.GetStream(streamID)
+	if err != nil {
+		return false, err
+	}
+
+	policy := s.StreamPostPolicy
+	if policy == 0 && s.IsAnnouncementOnly {
+		// older servers only have the announcement only setting
+		policy = StreamPostPolicyAdmins
+	}
+	if policy == 0 || policy == StreamPostPolicyEveryone {
+		return true, nil
+	}
+
+	me, err := b.GetProfile()
+	if err != nil {
+		return false, err
+	}
+
+	switch policy {
+	case StreamPostPolicyAdmins:
+		return me.IsAdmin || me.Role == RoleOwner || me.Role == RoleAdmin, nil
+	case StreamPostPolicyModerators:
+		return me.IsAdmin || (me.Role != 0 && me.Role <= RoleModerator), nil
+	case StreamPostPolicyRestrictNewMembers:
+		return me.Role != RoleGuest, nil
+	}
+
+	return false, fmt.Errorf("unknown stream post policy %d", policy)
+}
+
+// AddDefaultStream adds a stream to the realm's default streams, which new
+// users are subscribed to when they join. It requires an administrator bot.
+func (b *Bot) AddDefaultStream(streamID int) (*http.Response, error) {
+	return b.defaultStreamRequest("POST", streamID)
+}
+
+// RemoveDefaultStream removes a stream from the realm's default streams.
+// It requires an administrator bot.
+func (b *Bot) RemoveDefaultStream(streamID int) (*http.Response, error) {
+	return b.defaultStreamRequest("DELETE", streamID)
+}
+
+func (b *Bot) defaultStreamRequest(method string, streamID int) (*http.Response, error) {
+	values := url.Values{}
+	values.Set("stream_id", strconv.Itoa(streamID))
+
+	req, err := b.constructRequest(method, "default_streams?"+values.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, parseResponse(resp)
+}
+
+// Subscribe will set the bot to receive messages from the given streams.
+// If streams is nil, it will subscribe the bot to the streams in the bot struct.
+// It returns an error if there are no streams to subscribe to.
+// Once subscribed, any new streams are added to the bot's Streams.
+// Streams the bot has already subscribed to are skipped, and if there are no
+// others no request is made, and Subscribe returns a nil response.
+func (b *Bot) Subscribe(streams []string) (*http.Response, error) {
+	b.mu.Lock()
+	if streams == nil {
+		streams = append([]string(nil), b.Streams...)
+	}
+	streams = dedupeStreams(streams)
+	if len(streams) == 0 {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("No streams were provided")
+	}
+	var unsubscribed []string
+	for _, s := range streams {
+		if !b.subscribed[s] {
+			unsubscribed = append(unsubscribed, s)
+		}
+	}
+	b.mu.Unlock()
+	streams = unsubscribed
+	if len(streams) == 0 {
+		return nil, nil
+	}
+
+	var toSubStreams []map[string]string
+	for _, name := range streams {
+		toSubStreams = append(toSubStreams, map[string]string{"name": name})
+	}
+
+	bodyBts, err := json.Marshal(toSubStreams)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("subscriptions", string(bodyBts))
+
+	req, err := b.constructRequest("POST", "users/me/subscriptions", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseResponse(resp); err != nil {
+		return resp, err
+	}
+
+	b.mu.Lock()
+	b.Streams = dedupeStreams(append(b.Streams, streams...))
+	b.markSubscribed(streams...)
+	b.mu.Unlock()
+
+	return resp, nil
+}
+
+// Unsubscribe will remove the bot from the given streams.
+// If no streams are given, nothing will happen and the function will error.
+// Once unsubscribed, the streams are removed from the bot's Streams.
+func (b *Bot) Unsubscribe(streams []string) (*http.Response, error) {
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("No streams were provided")
+	}
+
+	names, err := json.Marshal(dedupeStreams(streams))
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("subscriptions", string(names))
+
+	req, err := b.constructRequest("DELETE", "users/me/subscriptions?"+values.Encode(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseResponse(resp); err != nil {
+		return resp, err
+	}
+
+	removed := map[string]bool{}
+	for _, s := range streams {
+		removed[s] = true
+	}
+	b.mu.Lock()
+	var kept []string
+	for _, s := range b.Streams {
+		if !removed[s] {
+			kept = append(kept, s)
+		}
+	}
+	b.Streams = kept
+	for s := range removed {
+		delete(b.subscribed, s)
+	}
+	b.mu.Unlock()
+
+	return resp, nil
+}
+
+// dedupeStreams returns the stream names without repeats, in order.
+// markSubscribed records that the bot has subscribed to the streams. It is
+// called with b.mu held.
+func (b *Bot) markSubscribed(streams ...string) {
+	if b.subscribed == nil {
+		b.subscribed = map[string]bool{}
+	}
+	for _, s := range streams {
+		b.subscribed[s] = true
+	}
+}
+
+func dedupeStreams(streams []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range streams {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// CreateStream creates a stream with the given name and description, and
+// subscribes the bot to it. If the stream already exists, the bot is just
+// subscribed. Once subscribed, the stream is added to the bot's Streams.
+func (b *Bot) CreateStream(name, description string, inviteOnly bool) (*http.Response, error) {
+	if name == "" {
+		return nil, errors.New("stream name cannot be empty")
+	}
+
+	subs, err := json.Marshal([]map[string]string{
+		{"name": name, "description": description},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("subscriptions", string(subs))
+	values.Set("invite_only", strconv.FormatBool(inviteOnly))
+
+	req, err := b.constructRequest("POST", "users/me/subscriptions", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseResponse(resp); err != nil {
+		return resp, err
+	}
+
+	b.mu.Lock()
+	b.Streams = dedupeStreams(append(b.Streams, name))
+	b.markSubscribed(name)
+	b.mu.Unlock()
+
+	return resp, nil
+}
+
+// A Subscription is a stream the bot is subscribed to, with the bot's
+// settings for it.
+type Subscription struct {
+	Stream
+	Color    string `json:"color"`
+	IsMuted  bool   `json:"is_muted"`
+	PinToTop bool   `json:"pin_to_top"`
+}
+
+// UnmarshalJSON reads the subscription's settings along with its stream,
+// which has its own UnmarshalJSON.
+func (s *Subscription) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &s.Stream); err != nil {
+		return err
+	}
+
+	var settings struct {
+		Color    string `json:"color"`
+		IsMuted  bool   `json:"is_muted"`
+		PinToTop bool   `json:"pin_to_top"`
+	}
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return err
+	}
+	s.Color = settings.Color
+	s.IsMuted = settings.IsMuted
+	s.PinToTop = settings.PinToTop
+	return nil
+}
+
+// GetSubscriptions returns the streams the bot is subscribed to.
+func (b *Bot) GetSubscriptions() ([]Subscription, error) {
+	resp, err := b.ListSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	var sj struct {
+		Subscriptions []Subscription `json:"subscriptions"`
+	}
+	err = decodeResponse(resp, &sj)
+	if err != nil {
+		return nil, err
+	}
+
+	return sj.Subscriptions, nil
+}
+
+// ListSubscriptions gets the raw http response when requesting the bot's
+// subscriptions.
+func (b *Bot) ListSubscriptions() (*http.Response, error) {
+	req, err := b.constructRequest("GET", "users/me/subscriptions", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.do(req)
+}
+
+// UpdateSubscriptionSettings changes the bot's settings for a stream it is
+// subscribed to. props maps each Zulip subscription property, such as
+// "is_muted", "pin_to_top" or "color", to its new value.
+func (b *Bot) UpdateSubscriptionSettings(streamID int, props map[string]interface{}) (*http.Response, error) {
+	if len(props) == 0 {
+		return nil, errors.New("there must be at least one property to update")
+	}
+
+	// sort the properties, so the request is the same every time
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type change struct {
+		StreamID int         `json:"stream_id"`
+		Property string      `json:"property"`
+		Value    interface{} `json:"value"`
+	}
+	changes := make([]change, 0, len(names))
+	for _, name := range names {
+		changes = append(changes, change{StreamID: streamID, Property: name, Value: props[name]})
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("subscription_data", string(data))
+
+	req, err := b.constructRequest("POST", "users/me/subscriptions/properties", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// MuteStream mutes or unmutes a stream for the bot.
+func (b *Bot) MuteStream(streamID int, muted bool) (*http.Response, error) {
+	return b.UpdateSubscriptionSettings(streamID, map[string]interface{}{"is_muted": muted})
+}
+
+// PinStream pins a stream to the top of the bot's stream list, or unpins it.
+func (b *Bot) PinStream(streamID int, pinned bool) (*http.Response, error) {
+	return b.UpdateSubscriptionSettings(streamID, map[string]interface{}{"pin_to_top": pinned})
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// SetStreamColor sets the color the bot's clients show a stream in, as a
+// hex color such as "#76ce90".
+func (b *Bot) SetStreamColor(streamID int, color string) (*http.Response, error) {
+	if !hexColor.MatchString(color) {
+		return nil, fmt.Errorf("color %q must be a hex color, such as \"#76ce90\"", color)
+	}
+	return b.UpdateSubscriptionSettings(streamID, map[string]interface{}{"color": color})
+}
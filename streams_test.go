@@ -0,0 +1,317 @@
+package gozulipbot
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCanPostToStream(t *testing.T) {
+	type C struct {
+		Stream   string
+		Profile  string
+		Expected bool
+	}
+	member := `{"result":"success","msg":"","user_id":5,"role":400,"is_admin":false}`
+	admin := `{"result":"success","msg":"","user_id":5,"role":200,"is_admin":true}`
+	cases := map[string]C{
+		"open": C{Stream: `{"result":"success","msg":"","stream":{"stream_id":1,"stream_post_policy":1}}`,
+			Expected: true},
+		"admins only, member": C{Stream: `{"result":"success","msg":"","stream":{"stream_id":1,"stream_post_policy":2}}`,
+			Profile: member, Expected: false},
+		"admins only, admin": C{Stream: `{"result":"success","msg":"","stream":{"stream_id":1,"stream_post_policy":2}}`,
+			Profile: admin, Expected: true},
+		"announcement only, member": C{Stream: `{"result":"success","msg":"","stream":{"stream_id":1,"is_announcement_only":true}}`,
+			Profile: member, Expected: false},
+		"moderators only, member": C{Stream: `{"result":"success","msg":"","stream":{"stream_id":1,"stream_post_policy":4}}`,
+			Profile: member, Expected: false},
+	}
+
+	for name, c := range cases {
+		bot := getTestBotWithResponses(c.Stream, c.Profile)
+		got, err := bot.CanPostToStream(1)
+		if err != nil {
+			t.Fatalf("got error %q, case %q", err, name)
+		}
+		if got != c.Expected {
+			t.Errorf("got %v, expected %v, case %q", got, c.Expected, name)
+		}
+	}
+
+	bot := getTestBotWithResponses(`{"result":"error","msg":"Invalid stream ID","code":"BAD_REQUEST"}`)
+	_, err := bot.CanPostToStream(1)
+	if err != ErrStreamNotFound {
+		t.Errorf("got %v, expected ErrStreamNotFound", err)
+	}
+}
+
+func TestDefaultStreams(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":""}`,
+		`{"result":"error","msg":"Must be an organization administrator","code":"UNAUTHORIZED_PRINCIPAL"}`)
+	tc := bot.Client.(*testClient)
+
+	_, err := bot.AddDefaultStream(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Request.Method != "POST" || tc.Request.URL.Path != "/v1/default_streams" || tc.Request.URL.RawQuery != "stream_id=7" {
+		t.Errorf("got %s %s", tc.Request.Method, tc.Request.URL)
+	}
+
+	resp, err := bot.RemoveDefaultStream(7)
+	if err == nil || err.Error() != "Must be an organization administrator" {
+		t.Errorf("got %v, expected the permission error", err)
+	}
+	if resp == nil {
+		t.Error("expected the response to be returned with the error")
+	}
+	if tc.Request.Method != "DELETE" || tc.Request.URL.RawQuery != "stream_id=7" {
+		t.Errorf("got %s %s", tc.Request.Method, tc.Request.URL)
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","stream":{
+		"stream_id":7,"name":"ops","description":"Operations","invite_only":true,
+		"stream_post_policy":2,"history_public_to_subscribers":true,"message_retention_days":30}}`)
+
+	s, err := bot.GetStream(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Stream{StreamID: 7, Name: "ops", Description: "Operations", InviteOnly: true,
+		StreamPostPolicy: 2, HistoryPublicToSubscribers: true, MessageRetentionDays: 30}
+	if *s != expected {
+		t.Errorf("got %+v, expected %+v", *s, expected)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/streams/7" {
+		t.Errorf("got path %q", p)
+	}
+
+	bot = getTestBotWithResponses(`{"result":"success","msg":"","stream":{"stream_id":8,"message_retention_days":"unlimited"}}`)
+	s, err = bot.GetStream(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MessageRetentionDays != -1 {
+		t.Errorf("got retention %d, expected -1 for unlimited", s.MessageRetentionDays)
+	}
+
+	bot = getTestBotWithResponses(`{"result":"error","msg":"Invalid stream ID","code":"BAD_REQUEST"}`)
+	if _, err = bot.GetStream(9); err != ErrStreamNotFound {
+		t.Errorf("got %v, expected ErrStreamNotFound", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ok := `{"result":"success","msg":"","subscribed":{},"already_subscribed":{}}`
+	bot := getTestBotWithResponses(ok, ok)
+
+	_, err := bot.Subscribe([]string{"new & shiny", "test bots", "new & shiny"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	req.ParseForm()
+	expected := `[{"name":"new \u0026 shiny"},{"name":"test bots"}]`
+	if got := req.PostForm.Get("subscriptions"); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	streams := []string{"stream a", "test bots", "new & shiny"}
+	if !reflect.DeepEqual(bot.Streams, streams) {
+		t.Errorf("got %q, expected %q", bot.Streams, streams)
+	}
+
+	// subscribing again makes no request, and leaves the streams as they are
+	tc := bot.Client.(*testClient)
+	n := len(tc.Requests)
+	resp, err := bot.Subscribe([]string{"new & shiny", "test bots"})
+	if resp != nil || err != nil {
+		t.Fatalf("got %v %v, expected no response", resp, err)
+	}
+	if got := len(tc.Requests); got != n {
+		t.Errorf("got %d requests, expected none for streams already subscribed to", got-n)
+	}
+	if !reflect.DeepEqual(bot.Streams, streams) {
+		t.Errorf("got %q, expected %q", bot.Streams, streams)
+	}
+
+	// nil subscribes to the bot's other streams, once
+	if _, err := bot.Subscribe(nil); err != nil {
+		t.Fatal(err)
+	}
+	tc.Request.ParseForm()
+	if got := tc.Request.PostForm.Get("subscriptions"); got != `[{"name":"stream a"}]` {
+		t.Errorf("got %q, expected only the stream not yet subscribed to", got)
+	}
+	n = len(tc.Requests)
+	if _, err := bot.Subscribe(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(tc.Requests); got != n {
+		t.Errorf("got %d requests, expected a repeated call to make none", got-n)
+	}
+
+	if _, err := bot.Subscribe([]string{}); err == nil {
+		t.Error("expected an error subscribing to no streams")
+	}
+	if got := len(bot.Client.(*testClient).Requests); got != n {
+		t.Errorf("got %d requests, expected none to be made", got-n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","removed":["stream a"],"not_removed":[]}`)
+
+	_, err := bot.Unsubscribe([]string{"stream a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	if req.Method != "DELETE" || req.URL.Query().Get("subscriptions") != `["stream a"]` {
+		t.Errorf("got %s %s", req.Method, req.URL)
+	}
+	if !reflect.DeepEqual(bot.Streams, []string{"test bots"}) {
+		t.Errorf("got %q, expected only test bots", bot.Streams)
+	}
+}
+
+func TestListStreams(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","streams":[
+		{"stream_id":1,"name":"general","message_retention_days":null},
+		{"stream_id":2,"name":"ops","invite_only":true}
+	]}`)
+
+	streams, err := bot.ListStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || streams[0].Name != "general" || !streams[1].InviteOnly {
+		t.Errorf("got %+v", streams)
+	}
+}
+
+func TestGetStreamID(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","stream_id":15}`,
+		`{"result":"error","msg":"Invalid stream name 'nope'","code":"STREAM_DOES_NOT_EXIST"}`,
+	)
+
+	id, err := bot.GetStreamID("general")
+	if err != nil || id != 15 {
+		t.Errorf("got %d, %v, expected 15", id, err)
+	}
+	if q := bot.Client.(*testClient).Request.URL.Query().Get("stream"); q != "general" {
+		t.Errorf("got stream %q", q)
+	}
+
+	_, err = bot.GetStreamID("nope")
+	if err != ErrStreamNotFound {
+		t.Errorf("got %v, expected ErrStreamNotFound", err)
+	}
+}
+
+func TestCreateStream(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","subscribed":{"testbot@example.com":["alerts"]}}`)
+
+	if _, err := bot.CreateStream("", "", false); err == nil {
+		t.Error("expected an error for an empty name")
+	}
+
+	_, err := bot.CreateStream("alerts", "Alerts from ops", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := bot.Client.(*testClient).Request
+	req.ParseForm()
+	if subs := req.PostForm.Get("subscriptions"); subs != `[{"description":"Alerts from ops","name":"alerts"}]` {
+		t.Errorf("got subscriptions %q", subs)
+	}
+	if req.PostForm.Get("invite_only") != "true" {
+		t.Errorf("got invite_only %q", req.PostForm.Get("invite_only"))
+	}
+	if !reflect.DeepEqual(bot.Streams, []string{"stream a", "test bots", "alerts"}) {
+		t.Errorf("got %q, expected the new stream to be added", bot.Streams)
+	}
+}
+
+func TestGetSubscriptions(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","subscriptions":[
+		{"stream_id":1,"name":"general","color":"#76ce90","is_muted":true,"pin_to_top":false,"message_retention_days":"unlimited"}
+	]}`)
+
+	subs, err := bot.GetSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 {
+		t.Fatalf("got %d subscriptions, expected 1", len(subs))
+	}
+	s := subs[0]
+	if s.StreamID != 1 || s.Name != "general" || s.Color != "#76ce90" || !s.IsMuted || s.MessageRetentionDays != -1 {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestUpdateSubscriptionSettings(t *testing.T) {
+	type C struct {
+		Update func(*Bot) (*http.Response, error)
+		Data   string
+		Err    bool
+	}
+
+	cases := map[string]C{
+		"props": C{
+			Update: func(b *Bot) (*http.Response, error) {
+				return b.UpdateSubscriptionSettings(7, map[string]interface{}{"pin_to_top": true, "is_muted": false})
+			},
+			Data: `[{"stream_id":7,"property":"is_muted","value":false},{"stream_id":7,"property":"pin_to_top","value":true}]`,
+		},
+		"mute": C{
+			Update: func(b *Bot) (*http.Response, error) { return b.MuteStream(7, true) },
+			Data:   `[{"stream_id":7,"property":"is_muted","value":true}]`,
+		},
+		"pin": C{
+			Update: func(b *Bot) (*http.Response, error) { return b.PinStream(7, false) },
+			Data:   `[{"stream_id":7,"property":"pin_to_top","value":false}]`,
+		},
+		"color": C{
+			Update: func(b *Bot) (*http.Response, error) { return b.SetStreamColor(7, "#76ce90") },
+			Data:   `[{"stream_id":7,"property":"color","value":"#76ce90"}]`,
+		},
+		"bad color": C{
+			Update: func(b *Bot) (*http.Response, error) { return b.SetStreamColor(7, "green") },
+			Err:    true,
+		},
+		"no props": C{
+			Update: func(b *Bot) (*http.Response, error) { return b.UpdateSubscriptionSettings(7, nil) },
+			Err:    true,
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBotWithResponses(`{"result":"success","msg":""}`)
+		_, err := c.Update(bot)
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+
+		req := bot.Client.(*testClient).Request
+		req.ParseForm()
+		if req.Method != "POST" || req.URL.Path != "/v1/users/me/subscriptions/properties" {
+			t.Errorf("got %s %s, case %q", req.Method, req.URL.Path, k)
+		}
+		if got := req.PostForm.Get("subscription_data"); got != c.Data {
+			t.Errorf("got %q, expected %q, case %q", got, c.Data, k)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package gozulipbot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ResolvedTopicPrefix is the prefix Zulip adds to the name of a topic when it
+// is marked as resolved.
+const ResolvedTopicPrefix = "✔ "
+
+// A Topic is a topic in a stream. MaxID is the id of its latest message.
+type Topic struct {
+	Name  string `json:"name"`
+	MaxID int    `json:"max_id"`
+}
+
+// IsResolvedTopic reports whether the message is in a resolved topic.
+func (e EventMessage) IsResolvedTopic() bool {
+	return strings.HasPrefix(e.Subject, ResolvedTopicPrefix)
+}
+
+// IsTopicResolved reports whether the named topic in the stream has been
+// resolved, which renames it with the ResolvedTopicPrefix.
+// It returns false if the stream's topics can't be fetched.
+func (b *Bot) IsTopicResolved(streamID int, topic string) bool {
+	if strings.HasPrefix(topic, ResolvedTopicPrefix) {
+		return true
+	}
+
+	topics, err := b.GetStreamTopics(streamID)
+	if err != nil {
+		return false
+	}
+
+	// a topic can be resolved and then reused, so go by the latest message
+	resolved, unresolved := -1, -1
+	for _, t := range topics {
+		switch {
+		case strings.EqualFold(t.Name, topic):
+			unresolved = t.MaxID
+		case strings.EqualFold(t.Name, ResolvedTopicPrefix+topic):
+			resolved = t.MaxID
+		}
+	}
+	return resolved > unresolved
+}
+
+// GetStreamTopics returns the topics in the stream with the given id, most
+// recent first.
+func (b *Bot) GetStreamTopics(streamID int) ([]Topic, error) {
+	req, err := b.constructRequest("GET", fmt.Sprintf("users/me/%d/topics", streamID), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var tj struct {
+		Topics []Topic `json:"topics"`
+	}
+	err = b.doJSON(req, &tj)
+	if err != nil {
+		return nil, err
+	}
+
+	return tj.Topics, nil
+}
+
+// ErrTopicNotFound is returned when a stream has no topic with the given name.
+var ErrTopicNotFound = errors.New("topic not found")
+
+// MuteTopic mutes the topic in the stream with the given id for the bot, so
+// its messages no longer count as unread.
+func (b *Bot) MuteTopic(streamID int, topic string) (*http.Response, error) {
+	return b.muteTopic(streamID, topic, "add")
+}
+
+// UnmuteTopic unmutes the topic in the stream with the given id for the bot.
+func (b *Bot) UnmuteTopic(streamID int, topic string) (*http.Response, error) {
+	return b.muteTopic(streamID, topic, "remove")
+}
+
+func (b *Bot) muteTopic(streamID int, topic, op string) (*http.Response, error) {
+	if topic == "" {
+		return nil, errors.New("topic cannot be empty")
+	}
+
+	values := url.Values{}
+	values.Set("stream_id", strconv.Itoa(streamID))
+	values.Set("topic", topic)
+	values.Set("op", op)
+
+	req, err := b.constructRequest("PATCH", "users/me/subscriptions/muted_topics", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// MoveStreamTopic moves the whole of the named topic, in the stream with the
+// given id, like MoveTopic.
+func (b *Bot) MoveStreamTopic(streamID int, topic string, mv Move) (*http.Response, error) {
+	id, err := b.topicMaxID(streamID, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.MoveTopic(id, mv)
+}
+
+// ResolveTopic marks the named topic in the stream with the given id as
+// resolved, by renaming it with the ResolvedTopicPrefix, as Zulip's "mark as
+// resolved" does. Zulip posts a notification about it in the topic.
+func (b *Bot) ResolveTopic(streamID int, topic string) (*http.Response, error) {
+	if strings.HasPrefix(topic, ResolvedTopicPrefix) {
+		return nil, errors.New("topic is already resolved")
+	}
+
+	return b.MoveStreamTopic(streamID, topic, Move{Topic: ResolvedTopicPrefix + topic, NoNotifyOldThread: true})
+}
+
+// UnresolveTopic marks the named resolved topic in the stream with the given
+// id as unresolved again. topic can be given with or without the
+// ResolvedTopicPrefix.
+func (b *Bot) UnresolveTopic(streamID int, topic string) (*http.Response, error) {
+	topic = strings.TrimPrefix(topic, ResolvedTopicPrefix)
+
+	return b.MoveStreamTopic(streamID, ResolvedTopicPrefix+topic, Move{Topic: topic, NoNotifyOldThread: true})
+}
+
+// topicMaxID returns the id of the latest message in the named topic.
+func (b *Bot) topicMaxID(streamID int, topic string) (int, error) {
+	topics, err := b.GetStreamTopics(streamID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, t := range topics {
+		if strings.EqualFold(t.Name, topic) {
+			return t.MaxID, nil
+		}
+	}
+	return 0, ErrTopicNotFound
+}
@@ -0,0 +1,107 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestIsResolvedTopic(t *testing.T) {
+	if !(EventMessage{Subject: "✔ outage"}).IsResolvedTopic() {
+		t.Error("expected a topic with the prefix to be resolved")
+	}
+	if (EventMessage{Subject: "✔outage"}).IsResolvedTopic() {
+		t.Error("expected the prefix to need its trailing space")
+	}
+	if (EventMessage{Subject: "outage"}).IsResolvedTopic() {
+		t.Error("expected a plain topic not to be resolved")
+	}
+}
+
+func TestIsTopicResolved(t *testing.T) {
+	topics := `{"result":"success","msg":"","topics":[
+		{"name":"✔ outage","max_id":30},
+		{"name":"deploys","max_id":20},
+		{"name":"reused","max_id":25},
+		{"name":"✔ reused","max_id":10}
+	]}`
+	type C struct {
+		Topic    string
+		Expected bool
+	}
+	cases := map[string]C{
+		"resolved":   C{Topic: "outage", Expected: true},
+		"unresolved": C{Topic: "deploys", Expected: false},
+		"reused":     C{Topic: "reused", Expected: false},
+		"missing":    C{Topic: "nothing", Expected: false},
+	}
+
+	for name, c := range cases {
+		bot := getTestBotWithResponses(topics)
+		if got := bot.IsTopicResolved(7, c.Topic); got != c.Expected {
+			t.Errorf("got %v, expected %v, case %q", got, c.Expected, name)
+		}
+		if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/users/me/7/topics" {
+			t.Errorf("got path %q, case %q", p, name)
+		}
+	}
+}
+
+func TestMuteTopic(t *testing.T) {
+	bot := getTestBot()
+
+	if _, err := bot.MuteTopic(7, ""); err == nil {
+		t.Error("expected an error for an empty topic")
+	}
+
+	if _, err := bot.UnmuteTopic(7, "deploys"); err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	body, _ := ioutil.ReadAll(req.Body)
+	expected := "op=remove&stream_id=7&topic=deploys"
+	if req.Method != "PATCH" || req.URL.Path != "/v1/users/me/subscriptions/muted_topics" || string(body) != expected {
+		t.Errorf("got %s %s %q, expected %q", req.Method, req.URL.Path, string(body), expected)
+	}
+}
+
+func TestResolveTopic(t *testing.T) {
+	topics := `{"result":"success","msg":"","topics":[
+		{"name":"✔ outage","max_id":30},
+		{"name":"Deploys","max_id":20}
+	]}`
+	type C struct {
+		Resolve bool
+		Topic   string
+		Path    string
+		NewName string
+		Err     error
+	}
+	cases := map[string]C{
+		"resolve":        C{Resolve: true, Topic: "deploys", Path: "/v1/messages/20", NewName: "✔ deploys"},
+		"unresolve":      C{Topic: "outage", Path: "/v1/messages/30", NewName: "outage"},
+		"unresolve full": C{Topic: "✔ outage", Path: "/v1/messages/30", NewName: "outage"},
+		"missing":        C{Resolve: true, Topic: "nothing", Err: ErrTopicNotFound},
+	}
+
+	for k, c := range cases {
+		bot := getTestBotWithResponses(topics, `{"result":"success","msg":""}`)
+		var err error
+		if c.Resolve {
+			_, err = bot.ResolveTopic(7, c.Topic)
+		} else {
+			_, err = bot.UnresolveTopic(7, c.Topic)
+		}
+		if err != c.Err {
+			t.Errorf("got %v, expected %v, case %q", err, c.Err, k)
+		}
+		if c.Err != nil {
+			continue
+		}
+
+		req := bot.Client.(*testClient).Request
+		req.ParseForm()
+		if req.URL.Path != c.Path || req.PostForm.Get("topic") != c.NewName || req.PostForm.Get("propagate_mode") != "change_all" {
+			t.Errorf("got %s %v, expected %s to %q, case %q", req.URL.Path, req.PostForm, c.Path, c.NewName, k)
+		}
+	}
+}
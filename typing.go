@@ -0,0 +1,106 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Typing notification operations, for SendTyping.
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+// SendTyping tells the users with the given emails that the bot has started
+// or stopped typing to them. op must be TypingStart or TypingStop.
+func (b *Bot) SendTyping(op string, emails []string) (*http.Response, error) {
+	if op != TypingStart && op != TypingStop {
+		return nil, errors.New(`typing op must be "start" or "stop"`)
+	}
+	if len(emails) == 0 {
+		return nil, errors.New("there must be at least one recipient")
+	}
+
+	to, err := json.Marshal(emails)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("op", op)
+	values.Set("to", string(to))
+
+	req, err := b.constructRequest("POST", "typing", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// SetTyping is SendTyping, with the recipients first.
+func (b *Bot) SetTyping(to []string, op string) (*http.Response, error) {
+	return b.SendTyping(op, to)
+}
+
+// typingRefresh is how often WhileTyping repeats its start notification.
+// Zulip clients stop showing a typing indicator that isn't refreshed after
+// about 15 seconds.
+var typingRefresh = 10 * time.Second
+
+// WhileTyping shows the users a response to the private message would go to
+// that the bot is typing while fn runs, such as while fetching a slow
+// response, and returns fn's error. The notification is repeated while fn
+// runs, and stopped when it returns or panics. Failures to send
+// notifications are ignored, so they don't stop fn.
+func (b *Bot) WhileTyping(e EventMessage, fn func() error) error {
+	notify := func(op string) {
+		resp, _ := b.TypingTo(e, op)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+
+	notify(TypingStart)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	// stop typing even if fn panics
+	defer func() {
+		close(done)
+		<-stopped
+		notify(TypingStop)
+	}()
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(typingRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				notify(TypingStart)
+			}
+		}
+	}()
+
+	return fn()
+}
+
+// TypingTo sends a typing notification to the users a response to the
+// private message would go to, such as before a slow Respond.
+func (b *Bot) TypingTo(e EventMessage, op string) (*http.Response, error) {
+	if e.Subject != "" || e.DisplayRecipient.Topic != "" {
+		return nil, errors.New("typing notifications can only be sent to private conversations")
+	}
+
+	emails, err := b.privateResponseList(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.SendTyping(op, emails)
+}
@@ -0,0 +1,141 @@
+package gozulipbot
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSendTyping(t *testing.T) {
+	type C struct {
+		Op     string
+		Emails []string
+		Body   string
+		E      error
+	}
+
+	cases := map[string]C{
+		"start": C{Op: "start", Emails: []string{"a@example.com", "b@example.com"},
+			Body: "op=start&to=%5B%22a%40example.com%22%2C%22b%40example.com%22%5D"},
+		"stop": C{Op: "stop", Emails: []string{"a@example.com"},
+			Body: "op=stop&to=%5B%22a%40example.com%22%5D"},
+		"bad op": C{Op: "pause", Emails: []string{"a@example.com"},
+			E: errors.New(`typing op must be "start" or "stop"`)},
+		"no recipients": C{Op: "start",
+			E: errors.New("there must be at least one recipient")},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		_, err := bot.SendTyping(c.Op, c.Emails)
+		if c.E != nil {
+			if err == nil || err.Error() != c.E.Error() {
+				t.Errorf("got %v, expected %q, case %q", err, c.E, k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+
+		req := bot.Client.(*testClient).Request
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.URL.Path != "/v1/typing" || string(body) != c.Body {
+			t.Errorf("got %s %q, expected %q, case %q", req.URL.Path, string(body), c.Body, k)
+		}
+	}
+}
+
+func TestTypingTo(t *testing.T) {
+	bot := getTestBot()
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Users: []User{
+		{Email: "testbot@example.com"}, {Email: "a@example.com"},
+	}}}
+
+	_, err := bot.TypingTo(e, "start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(bot.Client.(*testClient).Request.Body)
+	if expected := "op=start&to=%5B%22a%40example.com%22%5D"; string(body) != expected {
+		t.Errorf("got %q, expected %q", string(body), expected)
+	}
+
+	_, err = bot.TypingTo(EventMessage{DisplayRecipient: DisplayRecipient{Topic: "a"}, Subject: "b"}, "start")
+	if err == nil {
+		t.Error("expected an error for a stream message")
+	}
+}
+
+func TestWhileTyping(t *testing.T) {
+	old := typingRefresh
+	typingRefresh = 5 * time.Millisecond
+	defer func() { typingRefresh = old }()
+
+	bot := getTestBot()
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Users: []User{
+		{Email: "testbot@example.com"}, {Email: "a@example.com"},
+	}}}
+
+	expected := errors.New("api down")
+	err := bot.WhileTyping(e, func() error {
+		time.Sleep(30 * time.Millisecond)
+		return expected
+	})
+	if err != expected {
+		t.Errorf("got %v, expected %v", err, expected)
+	}
+
+	reqs := bot.Client.(*testClient).Requests
+	if len(reqs) < 3 {
+		t.Fatalf("got %d requests, expected the start to be repeated", len(reqs))
+	}
+	for i, req := range reqs {
+		req.ParseForm()
+		op := TypingStart
+		if i == len(reqs)-1 {
+			op = TypingStop
+		}
+		if got := req.PostForm.Get("op"); got != op {
+			t.Errorf("got op %q, expected %q, request %d", got, op, i)
+		}
+	}
+}
+
+func TestWhileTypingPanic(t *testing.T) {
+	old := typingRefresh
+	typingRefresh = 5 * time.Millisecond
+	defer func() { typingRefresh = old }()
+
+	bot := getTestBot()
+	e := EventMessage{DisplayRecipient: DisplayRecipient{Users: []User{
+		{Email: "testbot@example.com"}, {Email: "a@example.com"},
+	}}}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected fn's panic to be passed on")
+			}
+		}()
+		bot.WhileTyping(e, func() error { panic("handler failed") })
+	}()
+
+	reqs := bot.Client.(*testClient).Requests
+	n := len(reqs)
+	if n < 2 {
+		t.Fatalf("got %d requests, expected a start and a stop", n)
+	}
+	last := reqs[n-1]
+	last.ParseForm()
+	if got := last.PostForm.Get("op"); got != TypingStop {
+		t.Errorf("got op %q, expected %q", got, TypingStop)
+	}
+
+	// the refresh has stopped
+	time.Sleep(20 * time.Millisecond)
+	if got := len(bot.Client.(*testClient).Requests); got != n {
+		t.Errorf("got %d requests after the panic, expected none", got-n)
+	}
+}
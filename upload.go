@@ -0,0 +1,262 @@
+package gozulipbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Upload streams the contents of r to Zulip as a file with the given name,
+// and returns the uri of the uploaded file. The uri can be linked to in a
+// message's content, such as "[report.pdf](uri)".
+//
+// The response is returned with its body intact, including when Zulip
+// responds with an error.
+func (b *Bot) Upload(filename string, r io.Reader) (string, *http.Response, error) {
+	req, err := b.constructUploadRequest(filename, r)
+	if err != nil {
+		return "", nil, err
+	}
+	// make sure the writer stops if the request ends before reading the body
+	defer req.Body.Close()
+
+	resp, err := b.do(req)
+	if err != nil {
+		return "", nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", resp, err
+	}
+
+	err = responseError(resp, body)
+	if err != nil {
+		return "", resp, err
+	}
+
+	var uj struct {
+		URI string `json:"uri"`
+	}
+	err = json.Unmarshal(body, &uj)
+	if err != nil {
+		return "", resp, err
+	}
+
+	return uj.URI, resp, nil
+}
+
+// UploadFile uploads the contents of r like Upload, returning just the uri.
+// The uri can be added to a message with Message.Attach.
+func (b *Bot) UploadFile(filename string, r io.Reader) (string, error) {
+	uri, _, err := b.Upload(filename, r)
+	return uri, err
+}
+
+// Attach adds a link to an uploaded file to the end of the message's
+// content, on a line of its own.
+func (m *Message) Attach(filename, uri string) {
+	link := fileLink(filename, uri)
+	if m.Content == "" {
+		m.Content = link
+		return
+	}
+	m.Content = strings.TrimRight(m.Content, "\n") + "\n" + link
+}
+
+// fileLink returns the markdown link to an uploaded file.
+func fileLink(filename, uri string) string {
+	return "[" + EscapeMarkdown(filename) + "](" + uri + ")"
+}
+
+// constructUploadRequest makes a multipart request uploading the contents of
+// r as a file. The body is written as the request is sent, so r isn't read
+// into memory, and the request can only be sent once.
+func (b *Bot) constructUploadRequest(filename string, r io.Reader) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := mw.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := b.newRequest("POST", "user_uploads", pr, mw.FormDataContentType())
+	if err != nil {
+		// stop the writer, since nothing will read the body
+		pr.Close()
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// An Attachment is a file the bot has uploaded.
+// CreateTime is in milliseconds since the Unix epoch, and Messages lists the
+// ids of the messages linking to the file.
+type Attachment struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	PathID     string `json:"path_id"`
+	Size       int    `json:"size"`
+	CreateTime int    `json:"create_time"`
+	Messages   []int  `json:"-"`
+}
+
+// UnmarshalJSON reads the message ids out of Zulip's message objects.
+func (a *Attachment) UnmarshalJSON(b []byte) error {
+	type attachment Attachment
+	aux := struct {
+		*attachment
+		Messages []struct {
+			ID int `json:"id"`
+		} `json:"messages"`
+	}{attachment: (*attachment)(a)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	a.Messages = nil
+	for _, m := range aux.Messages {
+		a.Messages = append(a.Messages, m.ID)
+	}
+	return nil
+}
+
+// Created returns when the attachment was uploaded, in UTC.
+func (a Attachment) Created() time.Time {
+	return time.Unix(0, int64(a.CreateTime)*int64(time.Millisecond)).UTC()
+}
+
+// GetAttachments returns the files the bot has uploaded.
+func (b *Bot) GetAttachments() ([]Attachment, error) {
+	req, err := b.constructRequest("GET", "attachments", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var aj struct {
+		Attachments []Attachment `json:"attachments"`
+	}
+	err = b.doJSON(req, &aj)
+	if err != nil {
+		return nil, err
+	}
+
+	return aj.Attachments, nil
+}
+
+// DeleteAttachment deletes a file the bot has uploaded.
+func (b *Bot) DeleteAttachment(id int) (*http.Response, error) {
+	req, err := b.constructRequest("DELETE", fmt.Sprintf("attachments/%d", id), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// uploadLink matches the path of a link to an uploaded file, in markdown or
+// rendered content.
+var uploadLink = regexp.MustCompile(`/user_uploads/[^\s()<>"'\]]+`)
+
+// UploadLinks returns the paths of the uploaded files the message links to,
+// such as "/user_uploads/1/4e/report.txt", in the order they appear. Each
+// path is listed once.
+func (e EventMessage) UploadLinks() []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, l := range uploadLink.FindAllString(e.Content, -1) {
+		if !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// DownloadFile fetches an uploaded file with the bot's credentials, and
+// returns its contents, which the caller must close. uri is a path such as
+// "/user_uploads/1/4e/report.txt", from UploadLinks or Upload, or an absolute
+// url on the bot's realm, with its scheme; the credentials are never sent to
+// other hosts, or over another scheme.
+//
+// If Zulip responds with an error, such as for a file the bot can't access,
+// it is returned as a *ZulipError.
+func (b *Bot) DownloadFile(uri string) (io.ReadCloser, error) {
+	u, err := b.uploadURL(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.userAgent != "" {
+		req.Header.Set("User-Agent", b.userAgent)
+	}
+	req.SetBasicAuth(b.Email, b.APIKey)
+
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError(resp, body)
+	}
+
+	return resp.Body, nil
+}
+
+// uploadURL resolves the uri of an uploaded file against the bot's realm.
+func (b *Bot) uploadURL(uri string) (string, error) {
+	site, err := url.Parse(b.siteURL())
+	if err != nil {
+		return "", err
+	}
+	u, err := site.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	// the credentials are only sent to the realm, the way the bot's other
+	// requests reach it, so never over plain http to an https realm
+	if u.Host != site.Host || u.Scheme != site.Scheme {
+		return "", fmt.Errorf("%q is not on the bot's realm", uri)
+	}
+	if !strings.HasPrefix(u.Path, "/user_uploads/") {
+		return "", fmt.Errorf("%q is not an uploaded file", uri)
+	}
+	return u.String(), nil
+}
+
+// siteURL returns the url of the bot's realm, its api url without the api
+// path, such as "https://myrealm.example.com/".
+func (b *Bot) siteURL() string {
+	api := b.apiURL()
+	if strings.HasSuffix(api, "/api/v1/") {
+		return strings.TrimSuffix(api, "api/v1/")
+	}
+	if u, err := url.Parse(api); err == nil {
+		return u.Scheme + "://" + u.Host + "/"
+	}
+	return api
+}
@@ -0,0 +1,202 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetAttachments(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","attachments":[
+		{"id":1,"name":"report.csv","path_id":"2/ce/report.csv","size":1024,"create_time":1588145417000,
+		 "messages":[{"id":101,"date_sent":1588145426},{"id":102,"date_sent":1588145500}]}
+	],"upload_space_used":1024}`)
+
+	as, err := bot.GetAttachments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(as) != 1 {
+		t.Fatalf("got %d attachments, expected 1", len(as))
+	}
+	a := as[0]
+	if a.ID != 1 || a.Name != "report.csv" || a.PathID != "2/ce/report.csv" || a.Size != 1024 {
+		t.Errorf("got %+v", a)
+	}
+	if !reflect.DeepEqual(a.Messages, []int{101, 102}) {
+		t.Errorf("got messages %v", a.Messages)
+	}
+	if !a.Created().Equal(time.Date(2020, 4, 29, 7, 30, 17, 0, time.UTC)) {
+		t.Errorf("got created %v", a.Created())
+	}
+
+	_, err = bot.DeleteAttachment(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	if req.Method != "DELETE" || req.URL.Path != "/v1/attachments/1" {
+		t.Errorf("got %s %s", req.Method, req.URL.Path)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	bot := getTestBotWithResponses(
+		`{"result":"success","msg":"","uri":"/user_uploads/1/4e/report.txt"}`,
+		`{"result":"error","msg":"File too large","code":"BAD_REQUEST"}`,
+	)
+
+	uri, resp, err := bot.Upload("report.txt", strings.NewReader("all good"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri != "/user_uploads/1/4e/report.txt" {
+		t.Errorf("got %q", uri)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), uri) {
+		t.Errorf("expected the response body to still be readable, got %q", string(body))
+	}
+
+	req := bot.Client.(*testClient).Request
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		t.Errorf("got content type %q", req.Header.Get("Content-Type"))
+	}
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	f, fh, err := req.FormFile("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, _ := ioutil.ReadAll(f)
+	if fh.Filename != "report.txt" || string(uploaded) != "all good" {
+		t.Errorf("got file %q with %q", fh.Filename, string(uploaded))
+	}
+
+	_, resp, err = bot.Upload("big.txt", strings.NewReader("too much"))
+	if _, ok := err.(*ZulipError); !ok || resp == nil {
+		t.Errorf("got %v, %v, expected a *ZulipError with the response", resp, err)
+	}
+}
+
+func TestMessageAttach(t *testing.T) {
+	type C struct {
+		Content  string
+		Expected string
+	}
+	cases := map[string]C{
+		"empty":   C{Content: "", Expected: `[graph\_1.png](/user_uploads/1/ab/graph_1.png)`},
+		"content": C{Content: "today's graph:\n", Expected: "today's graph:\n" + `[graph\_1.png](/user_uploads/1/ab/graph_1.png)`},
+	}
+
+	for k, c := range cases {
+		m := Message{Content: c.Content}
+		m.Attach("graph_1.png", "/user_uploads/1/ab/graph_1.png")
+		if m.Content != c.Expected {
+			t.Errorf("got %q, expected %q, case %q", m.Content, c.Expected, k)
+		}
+	}
+}
+
+func TestUploadLinks(t *testing.T) {
+	e := EventMessage{Content: "logs: [app.log](/user_uploads/2/ce/app.log), " +
+		`<a href="/user_uploads/2/1f/graph.png">graph</a> and [again](/user_uploads/2/ce/app.log)`}
+	expected := []string{"/user_uploads/2/ce/app.log", "/user_uploads/2/1f/graph.png"}
+	if got := e.UploadLinks(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	type C struct {
+		APIURL string
+		URI    string
+		URL    string
+		Err    bool
+	}
+
+	cases := map[string]C{
+		"path": C{
+			APIURL: "https://myrealm.example.com/api/v1/",
+			URI:    "/user_uploads/2/ce/app.log",
+			URL:    "https://myrealm.example.com/user_uploads/2/ce/app.log",
+		},
+		"absolute": C{
+			APIURL: "https://myrealm.example.com/api/v1/",
+			URI:    "https://myrealm.example.com/user_uploads/2/ce/app.log",
+			URL:    "https://myrealm.example.com/user_uploads/2/ce/app.log",
+		},
+		"default api": C{
+			URI: "/user_uploads/2/ce/app.log",
+			URL: "https://api.zulip.com/user_uploads/2/ce/app.log",
+		},
+		"other host": C{
+			APIURL: "https://myrealm.example.com/api/v1/",
+			URI:    "https://elsewhere.example.com/user_uploads/2/ce/app.log",
+			Err:    true,
+		},
+		"other scheme": C{
+			APIURL: "https://myrealm.example.com/api/v1/",
+			URI:    "http://myrealm.example.com/user_uploads/2/ce/app.log",
+			Err:    true,
+		},
+		"not an upload": C{
+			APIURL: "https://myrealm.example.com/api/v1/",
+			URI:    "/api/v1/users/me",
+			Err:    true,
+		},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		bot.APIURL = c.APIURL
+		tc := bot.Client.(*testClient)
+		tc.Response = &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader("started"))}
+
+		rc, err := bot.DownloadFile(c.URI)
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			if tc.Request != nil {
+				t.Errorf("got a request to %q, expected none, case %q", tc.Request.URL, k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+		body, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(body) != "started" {
+			t.Errorf("got %q, expected %q, case %q", body, "started", k)
+		}
+		if got := tc.Request.URL.String(); got != c.URL {
+			t.Errorf("got %q, expected %q, case %q", got, c.URL, k)
+		}
+		if email, key, ok := tc.Request.BasicAuth(); !ok || email != bot.Email || key != bot.APIKey {
+			t.Errorf("got credentials %q %q, case %q", email, key, k)
+		}
+	}
+}
+
+func TestDownloadFileError(t *testing.T) {
+	bot := getTestBot()
+	bot.Client.(*testClient).Response = &http.Response{
+		StatusCode: 404,
+		Body:       ioutil.NopCloser(strings.NewReader("<html>not found</html>")),
+	}
+
+	_, err := bot.DownloadFile("/user_uploads/2/ce/app.log")
+	ze, ok := err.(*ZulipError)
+	if !ok {
+		t.Fatalf("got %v, expected a *ZulipError", err)
+	}
+	if ze.HTTPStatus != 404 {
+		t.Errorf("got status %d, expected 404", ze.HTTPStatus)
+	}
+}
@@ -0,0 +1,102 @@
+package gozulipbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// A UserGroup is a named group of users in the realm, which can be mentioned
+// to notify all of its members. System groups, like role based groups, are
+// managed by Zulip.
+type UserGroup struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Members       []int  `json:"members"`
+	IsSystemGroup bool   `json:"is_system_group"`
+}
+
+// Mention returns the markdown mentioning the group, notifying its members.
+func (g UserGroup) Mention() string {
+	return MentionGroup(g.Name)
+}
+
+// ListUserGroups fetches the realm's user groups.
+func (b *Bot) ListUserGroups() ([]UserGroup, error) {
+	req, err := b.constructRequest("GET", "user_groups", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var gj struct {
+		UserGroups []UserGroup `json:"user_groups"`
+	}
+	err = b.doJSON(req, &gj)
+	if err != nil {
+		return nil, err
+	}
+
+	return gj.UserGroups, nil
+}
+
+// CreateUserGroup creates a user group with the given members, listed by
+// user id. A group needs at least one member.
+func (b *Bot) CreateUserGroup(name, description string, members []int) (*http.Response, error) {
+	if name == "" {
+		return nil, errors.New("group name cannot be empty")
+	}
+	if len(members) == 0 {
+		return nil, errors.New("there must be at least one member")
+	}
+
+	ids, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	values.Set("name", name)
+	values.Set("description", description)
+	values.Set("members", string(ids))
+
+	req, err := b.constructRequest("POST", "user_groups/create", values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
+
+// UpdateUserGroupMembers adds and removes members, listed by user id, from
+// the user group with the given id, such as to hand over an on-call rotation.
+func (b *Bot) UpdateUserGroupMembers(groupID int, add, remove []int) (*http.Response, error) {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil, errors.New("there must be at least one member to add or remove")
+	}
+
+	values := url.Values{}
+	if len(add) != 0 {
+		ids, err := json.Marshal(add)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("add", string(ids))
+	}
+	if len(remove) != 0 {
+		ids, err := json.Marshal(remove)
+		if err != nil {
+			return nil, err
+		}
+		values.Set("delete", string(ids))
+	}
+
+	req, err := b.constructRequest("POST", fmt.Sprintf("user_groups/%d/members", groupID), values.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.doChecked(req)
+}
@@ -0,0 +1,79 @@
+package gozulipbot
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestListUserGroups(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","user_groups":[
+		{"id":1,"name":"oncall","description":"Paged for outages","members":[4,5],"is_system_group":false}
+	]}`)
+
+	groups, err := bot.ListUserGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []UserGroup{{ID: 1, Name: "oncall", Description: "Paged for outages", Members: []int{4, 5}}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got %+v, expected %+v", groups, expected)
+	}
+	if m := groups[0].Mention(); m != "@*oncall*" {
+		t.Errorf("got %q, expected %q", m, "@*oncall*")
+	}
+	if m := SilentMentionGroup("oncall"); m != "@_*oncall*" {
+		t.Errorf("got %q, expected %q", m, "@_*oncall*")
+	}
+}
+
+func TestCreateUserGroup(t *testing.T) {
+	bot := getTestBot()
+
+	if _, err := bot.CreateUserGroup("oncall", "", nil); err == nil {
+		t.Error("expected an error without members")
+	}
+
+	if _, err := bot.CreateUserGroup("oncall", "Paged", []int{4}); err != nil {
+		t.Fatal(err)
+	}
+	req := bot.Client.(*testClient).Request
+	body, _ := ioutil.ReadAll(req.Body)
+	expected := "description=Paged&members=%5B4%5D&name=oncall"
+	if req.URL.Path != "/v1/user_groups/create" || string(body) != expected {
+		t.Errorf("got %s %q, expected %q", req.URL.Path, string(body), expected)
+	}
+}
+
+func TestUpdateUserGroupMembers(t *testing.T) {
+	type C struct {
+		Add    []int
+		Remove []int
+		Body   string
+		Err    bool
+	}
+	cases := map[string]C{
+		"add":     C{Add: []int{4}, Body: "add=%5B4%5D"},
+		"both":    C{Add: []int{4}, Remove: []int{5, 6}, Body: "add=%5B4%5D&delete=%5B5%2C6%5D"},
+		"nothing": C{Err: true},
+	}
+
+	for k, c := range cases {
+		bot := getTestBot()
+		_, err := bot.UpdateUserGroupMembers(1, c.Add, c.Remove)
+		if c.Err {
+			if err == nil {
+				t.Errorf("expected an error, case %q", k)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("got %q, expected nil, case %q", err, k)
+		}
+		req := bot.Client.(*testClient).Request
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.URL.Path != "/v1/user_groups/1/members" || string(body) != c.Body {
+			t.Errorf("got %s %q, expected %q, case %q", req.URL.Path, string(body), c.Body, k)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package gozulipbot
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// User roles within a realm. Lower values have more permissions.
+const (
+	RoleOwner     = 100
+	RoleAdmin     = 200
+	RoleModerator = 300
+	RoleMember    = 400
+	RoleGuest     = 600
+)
+
+// GetProfile returns the bot's own user.
+func (b *Bot) GetProfile() (*User, error) {
+	req, err := b.constructRequest("GET", "users/me", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	err = b.doJSON(req, &u)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.userID = u.ID
+	b.fullName = u.FullName
+	b.mu.Unlock()
+
+	return &u, nil
+}
+
+// GetUsers returns the users in the realm, including bots and deactivated users.
+func (b *Bot) GetUsers() ([]User, error) {
+	req, err := b.constructRequest("GET", "users", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var uj struct {
+		Members []User `json:"members"`
+	}
+	err = b.doJSON(req, &uj)
+	if err != nil {
+		return nil, err
+	}
+
+	return uj.Members, nil
+}
+
+// GetUser returns the user with the given email. It is the same as
+// GetUserByEmail.
+func (b *Bot) GetUser(email string) (*User, error) {
+	return b.GetUserByEmail(email)
+}
+
+// GetUserByEmail returns the user with the given email.
+func (b *Bot) GetUserByEmail(email string) (*User, error) {
+	return b.getUser(url.PathEscape(email))
+}
+
+// GetUserByID returns the user with the given id.
+func (b *Bot) GetUserByID(id int) (*User, error) {
+	return b.getUser(strconv.Itoa(id))
+}
+
+// getUser returns the user with the given id or email.
+func (b *Bot) getUser(idOrEmail string) (*User, error) {
+	req, err := b.constructRequest("GET", "users/"+idOrEmail, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var uj struct {
+		User User `json:"user"`
+	}
+	err = b.doJSON(req, &uj)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uj.User, nil
+}
+
+// A UserPresence is whether a user is active, on each of their clients and
+// across all of them.
+type UserPresence struct {
+	Aggregated ClientPresence
+	Clients    map[string]ClientPresence
+}
+
+// Active reports whether the user is active on any client.
+func (p UserPresence) Active() bool {
+	return p.Aggregated.Status == "active"
+}
+
+// GetUserPresence returns the presence of the user with the given email.
+func (b *Bot) GetUserPresence(email string) (*UserPresence, error) {
+	req, err := b.constructRequest("GET", "users/"+url.PathEscape(email)+"/presence", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var pj struct {
+		Presence map[string]ClientPresence `json:"presence"`
+	}
+	err = b.doJSON(req, &pj)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &UserPresence{Clients: map[string]ClientPresence{}}
+	for client, cp := range pj.Presence {
+		if client == "aggregated" {
+			p.Aggregated = cp
+			continue
+		}
+		p.Clients[client] = cp
+	}
+	return p, nil
+}
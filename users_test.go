@@ -0,0 +1,76 @@
+package gozulipbot
+
+import "testing"
+
+func TestGetUsers(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","members":[
+		{"user_id":1,"email":"a@example.com","full_name":"A","is_active":true,"is_bot":false,"role":400},
+		{"user_id":2,"email":"bot@example.com","full_name":"Bot","is_active":false,"is_bot":true,"role":400}
+	]}`)
+
+	users, err := bot.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("got %d users, expected 2", len(users))
+	}
+	if u := users[0]; u.ID != 1 || u.Email != "a@example.com" || !u.IsActive || u.IsBot {
+		t.Errorf("got %+v", u)
+	}
+	if u := users[1]; u.ID != 2 || u.IsActive || !u.IsBot {
+		t.Errorf("got %+v", u)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/users" {
+		t.Errorf("got path %q", p)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","user":{"user_id":7,"email":"a@example.com","is_active":true}}`)
+
+	u, err := bot.GetUser("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 7 || !u.IsActive {
+		t.Errorf("got %+v", u)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/users/a@example.com" {
+		t.Errorf("got path %q", p)
+	}
+}
+
+func TestGetUserByID(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","user":{"user_id":7,"email":"a@example.com"}}`)
+
+	u, err := bot.GetUserByID(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 7 || u.Email != "a@example.com" {
+		t.Errorf("got %+v", u)
+	}
+	if p := bot.Client.(*testClient).Request.URL.Path; p != "/v1/users/7" {
+		t.Errorf("got path %q", p)
+	}
+}
+
+func TestGetUserPresence(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","presence":{
+		"aggregated":{"client":"website","status":"active","timestamp":1700000000},
+		"website":{"client":"website","status":"active","timestamp":1700000000,"pushable":false},
+		"ZulipMobile":{"client":"ZulipMobile","status":"idle","timestamp":1699990000,"pushable":true}
+	}}`)
+
+	p, err := bot.GetUserPresence("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Active() || len(p.Clients) != 2 || p.Clients["ZulipMobile"].Status != "idle" {
+		t.Errorf("got %+v", p)
+	}
+	if path := bot.Client.(*testClient).Request.URL.Path; path != "/v1/users/a@example.com/presence" {
+		t.Errorf("got path %q", path)
+	}
+}
@@ -0,0 +1,97 @@
+package gozulipbot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// A ReplyHandler handles a message, and returns the content of the reply to
+// it, or "" to not reply. The same ReplyHandler can run a bot that polls a
+// queue, with Replying, or an outgoing webhook bot, with WebhookHandler.
+type ReplyHandler func(*Bot, EventMessage) string
+
+// Replying adapts a ReplyHandler for OnMessage, posting its replies with Reply.
+// Errors posting a reply are logged, with the bot's Logger if it has one, and
+// the log package otherwise.
+func Replying(h ReplyHandler) func(*Bot, EventMessage) {
+	return func(b *Bot, e EventMessage) {
+		content := h(b, e)
+		if content == "" {
+			return
+		}
+		// with a Logger, the failure was logged as the reply was sent
+		if _, err := b.Reply(e, content); err != nil && b.Logger == nil {
+			log.Printf("gozulipbot: replying to message %d: %v", e.ID, err)
+		}
+	}
+}
+
+// webhookPayload is the body of a request from Zulip to an outgoing webhook bot.
+type webhookPayload struct {
+	BotEmail    string       `json:"bot_email"`
+	BotFullName string       `json:"bot_full_name"`
+	Data        string       `json:"data"`
+	Message     EventMessage `json:"message"`
+	Token       string       `json:"token"`
+	Trigger     string       `json:"trigger"`
+}
+
+// webhookResponse is the body of an outgoing webhook bot's response. Zulip
+// posts Content as the bot's reply.
+type webhookResponse struct {
+	Content             string `json:"content,omitempty"`
+	ResponseNotRequired bool   `json:"response_not_required,omitempty"`
+}
+
+// WebhookHandler returns an http.Handler that receives messages for an
+// outgoing webhook bot, and calls h with each of them. The reply h returns is
+// written in the response, for Zulip to post.
+//
+// Requests whose token doesn't match the bot's token, from its settings in
+// Zulip, are rejected, as is every request while token is empty. Messages
+// from senders not in the bot's AllowedSenders, or from the bot itself if
+// SkipSelf is set, are ignored. A handler that panics is recovered, and the
+// panic is logged.
+func (b *Bot) WebhookHandler(token string, h ReplyHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "malformed payload", http.StatusBadRequest)
+			return
+		}
+		if token == "" || p.Token == "" || subtle.ConstantTimeCompare([]byte(p.Token), []byte(token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		var resp webhookResponse
+		if b.receives(p.Message) {
+			resp.Content = b.handleWebhookMessage(h, p.Message)
+		}
+		if resp.Content == "" {
+			resp.ResponseNotRequired = true
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// handleWebhookMessage calls h with the message, recovering from a panic.
+func (b *Bot) handleWebhookMessage(h ReplyHandler, e EventMessage) (content string) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logRecovered(e.ID, r)
+			content = ""
+		}
+	}()
+	return h(b, e)
+}
@@ -0,0 +1,130 @@
+package gozulipbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	type C struct {
+		Method string
+		Body   string
+		Status int
+		Reply  string
+	}
+
+	message := `"message":{"id":7,"content":"@**Test Bot** ping","sender_email":"user@example.com","type":"stream"}`
+	cases := map[string]C{
+		"reply": C{
+			Method: "POST",
+			Body:   `{"token":"secret","trigger":"mention",` + message + `}`,
+			Status: 200,
+			Reply:  `{"content":"pong"}`,
+		},
+		"no reply": C{
+			Method: "POST",
+			Body:   `{"token":"secret","message":{"id":8,"content":"hello"}}`,
+			Status: 200,
+			Reply:  `{"response_not_required":true}`,
+		},
+		"bad token": C{
+			Method: "POST",
+			Body:   `{"token":"guess",` + message + `}`,
+			Status: http.StatusUnauthorized,
+		},
+		"malformed": C{
+			Method: "POST",
+			Body:   `{"token":`,
+			Status: http.StatusBadRequest,
+		},
+		"get": C{
+			Method: "GET",
+			Status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	bot := getTestBot()
+	h := bot.WebhookHandler("secret", func(b *Bot, e EventMessage) string {
+		if strings.HasSuffix(e.Content, "ping") {
+			return "pong"
+		}
+		return ""
+	})
+
+	for k, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.Method, "/", strings.NewReader(c.Body)))
+
+		if w.Code != c.Status {
+			t.Errorf("got %d, expected %d, case %q", w.Code, c.Status, k)
+		}
+		if c.Reply == "" {
+			continue
+		}
+		if got := strings.TrimSpace(w.Body.String()); got != c.Reply {
+			t.Errorf("got %q, expected %q, case %q", got, c.Reply, k)
+		}
+	}
+}
+
+func TestWebhookHandlerPanic(t *testing.T) {
+	bot := getTestBot()
+	h := bot.WebhookHandler("secret", func(b *Bot, e EventMessage) string {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"token":"secret","message":{"id":1}}`)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "response_not_required") {
+		t.Errorf("got %d %q, expected no reply", w.Code, w.Body.String())
+	}
+}
+
+func TestReplying(t *testing.T) {
+	bot := getTestBotWithResponses(`{"result":"success","msg":"","id":3}`)
+	handler := Replying(func(b *Bot, e EventMessage) string {
+		if e.Content == "quiet" {
+			return ""
+		}
+		return "pong"
+	})
+
+	handler(bot, EventMessage{Content: "quiet", Type: "stream", DisplayRecipient: DisplayRecipient{Topic: "s"}, Subject: "t"})
+	tc := bot.Client.(*testClient)
+	if len(tc.Requests) != 0 {
+		t.Fatalf("got %d requests, expected none for an empty reply", len(tc.Requests))
+	}
+
+	handler(bot, EventMessage{Content: "ping", Type: "stream", DisplayRecipient: DisplayRecipient{Topic: "s"}, Subject: "t"})
+	if len(tc.Requests) != 1 {
+		t.Fatalf("got %d requests, expected a reply to be sent", len(tc.Requests))
+	}
+	tc.Request.ParseForm()
+	if got := tc.Request.PostForm.Get("content"); got != "pong" {
+		t.Errorf("got %q, expected %q", got, "pong")
+	}
+}
+
+func TestWebhookHandlerEmptyToken(t *testing.T) {
+	bot := getTestBot()
+	reply := func(b *Bot, e EventMessage) string { return "pong" }
+
+	type C struct {
+		Token string
+		Body  string
+	}
+	cases := map[string]C{
+		"unconfigured":         C{Token: "", Body: `{"token":"","message":{"id":1}}`},
+		"unconfigured, any":    C{Token: "", Body: `{"token":"guess","message":{"id":1}}`},
+		"missing from payload": C{Token: "secret", Body: `{"message":{"id":1}}`},
+	}
+	for k, c := range cases {
+		w := httptest.NewRecorder()
+		bot.WebhookHandler(c.Token, reply).ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(c.Body)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got %d, expected %d, case %q", w.Code, http.StatusUnauthorized, k)
+		}
+	}
+}
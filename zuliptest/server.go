@@ -0,0 +1,385 @@
+// Package zuliptest provides a fake Zulip server for testing bots.
+//
+// The server records the messages bots send, and serves event queues with
+// the messages and events pushed to it, heartbeats when there are none, and
+// queue expiry on demand. Requests to other endpoints succeed with an empty
+// success result, and are recorded.
+//
+//	srv := zuliptest.NewServer()
+//	defer srv.Close()
+//	bot := srv.Bot()
+//	srv.PushMessage(zuliptest.Message{SenderEmail: "user@example.com", Stream: "ops", Topic: "deploys", Content: "!status"})
+package zuliptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gzb "github.com/ifo/gozulipbot"
+)
+
+// DefaultHeartbeatInterval is how long a request for events waits for an
+// event before a heartbeat is sent, when the server's HeartbeatInterval is 0.
+const DefaultHeartbeatInterval = 100 * time.Millisecond
+
+// A Message is a message pushed to the server's queues, to be received by bots.
+// It is a stream message if Stream is set, and a private message to the bot
+// and Recipients otherwise.
+type Message struct {
+	ID             int
+	SenderEmail    string
+	SenderFullName string
+	SenderID       int
+	Stream         string
+	Topic          string
+	Recipients     []string
+	Content        string
+}
+
+// A SentMessage is a message a bot sent to the server.
+type SentMessage struct {
+	ID   int
+	Type string
+	// To is the stream, or the emails or user ids of the private recipients.
+	To      []string
+	Topic   string
+	Content string
+}
+
+// A Request is a request a bot made to the server, with its form values.
+type Request struct {
+	Method   string
+	Endpoint string
+	Form     map[string][]string
+}
+
+// A Server is a fake Zulip server.
+type Server struct {
+	// URL is the server's base url, such as "http://127.0.0.1:1234".
+	URL string
+
+	// HeartbeatInterval is how long a request for events waits for an
+	// event before a heartbeat is sent. If it is 0,
+	// DefaultHeartbeatInterval is used.
+	HeartbeatInterval time.Duration
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	queues    map[string]*queue
+	nextQueue int
+	nextMsgID int
+	sent      []SentMessage
+	requests  []Request
+	// pushed is closed, and replaced, whenever an event is added to a queue.
+	pushed chan struct{}
+}
+
+type queue struct {
+	events []map[string]interface{}
+	// lastID is the id of the last event added to the queue.
+	lastID int
+}
+
+// NewServer starts a fake Zulip server. It should be closed when the test ends.
+func NewServer() *Server {
+	s := &Server{
+		queues: map[string]*queue{},
+		pushed: make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// APIURL returns the url of the server's api, for a bot's APIURL.
+func (s *Server) APIURL() string {
+	return s.URL + "/api/v1/"
+}
+
+// Bot returns a bot using the server, with an email and api key set. It
+// panics if the bot can't be initialized.
+func (s *Server) Bot() *gzb.Bot {
+	bot := &gzb.Bot{
+		Email:  "bot@example.com",
+		APIKey: "apikey",
+		APIURL: s.APIURL(),
+	}
+	if _, err := bot.Init(); err != nil {
+		panic("zuliptest: initializing bot: " + err.Error())
+	}
+	return bot
+}
+
+// PushMessage adds a message event to every queue, and returns the
+// message's id. If the message has no ID, one is assigned.
+func (s *Server) PushMessage(m Message) int {
+	s.mu.Lock()
+	if m.ID == 0 {
+		s.nextMsgID++
+		m.ID = s.nextMsgID
+	}
+	s.mu.Unlock()
+
+	msg := map[string]interface{}{
+		"id":               m.ID,
+		"content":          m.Content,
+		"sender_email":     m.SenderEmail,
+		"sender_full_name": m.SenderFullName,
+		"sender_id":        m.SenderID,
+		"timestamp":        time.Now().Unix(),
+	}
+	if m.Stream != "" {
+		msg["type"] = "stream"
+		msg["display_recipient"] = m.Stream
+		msg["subject"] = m.Topic
+	} else {
+		users := []map[string]interface{}{{"email": m.SenderEmail, "full_name": m.SenderFullName, "id": m.SenderID}}
+		for _, r := range m.Recipients {
+			users = append(users, map[string]interface{}{"email": r})
+		}
+		msg["type"] = "private"
+		msg["display_recipient"] = users
+	}
+
+	s.PushEvent(map[string]interface{}{"type": "message", "message": msg})
+	return m.ID
+}
+
+// PushEvent adds an event to every queue. Its id is set by the server.
+func (s *Server) PushEvent(event map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, q := range s.queues {
+		e := map[string]interface{}{}
+		for k, v := range event {
+			e[k] = v
+		}
+		q.lastID++
+		e["id"] = q.lastID
+		q.events = append(q.events, e)
+	}
+	close(s.pushed)
+	s.pushed = make(chan struct{})
+}
+
+// ExpireQueues removes every queue, as the server does to idle queues, so
+// requests for their events fail with BAD_EVENT_QUEUE_ID.
+func (s *Server) ExpireQueues() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queues = map[string]*queue{}
+	close(s.pushed)
+	s.pushed = make(chan struct{})
+}
+
+// Queues returns the number of registered queues.
+func (s *Server) Queues() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queues)
+}
+
+// WaitForQueues waits until n queues are registered, or the timeout passes,
+// and reports whether they were. Bots register their queues as they start, so
+// tests wait for them before pushing messages.
+func (s *Server) WaitForQueues(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.Queues() < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// Messages returns the messages sent to the server, in order.
+func (s *Server) Messages() []SentMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SentMessage(nil), s.sent...)
+}
+
+// WaitForMessages waits until n messages have been sent to the server, or
+// the timeout passes, and returns the messages sent.
+func (s *Server) WaitForMessages(n int, timeout time.Duration) []SentMessage {
+	deadline := time.Now().Add(timeout)
+	for {
+		msgs := s.Messages()
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Requests returns the requests made to the server, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if email, key, ok := r.BasicAuth(); !ok || email == "" || key == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"result": "error", "msg": "Missing credentials", "code": "UNAUTHORIZED",
+		})
+		return
+	}
+	r.ParseForm()
+
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Endpoint: endpoint, Form: r.Form})
+	s.mu.Unlock()
+
+	switch {
+	case endpoint == "register" && r.Method == "POST":
+		s.register(w)
+	case endpoint == "events" && r.Method == "GET":
+		s.events(w, r)
+	case endpoint == "events" && r.Method == "DELETE":
+		s.mu.Lock()
+		delete(s.queues, r.Form.Get("queue_id"))
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, success(nil))
+	case endpoint == "messages" && r.Method == "POST":
+		s.message(w, r)
+	default:
+		writeJSON(w, http.StatusOK, success(nil))
+	}
+}
+
+func (s *Server) register(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.nextQueue++
+	id := "queue" + strconv.Itoa(s.nextQueue)
+	s.queues[id] = &queue{lastID: -1}
+	maxID := s.nextMsgID
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{
+		"queue_id":       id,
+		"last_event_id":  -1,
+		"max_message_id": maxID,
+	}))
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	id := r.Form.Get("queue_id")
+	last, _ := strconv.Atoi(r.Form.Get("last_event_id"))
+
+	interval := s.HeartbeatInterval
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	heartbeat := time.NewTimer(interval)
+	defer heartbeat.Stop()
+
+	timedOut := false
+	for {
+		s.mu.Lock()
+		q, ok := s.queues[id]
+		if !ok {
+			s.mu.Unlock()
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"result":   "error",
+				"msg":      "Bad event queue id: " + id,
+				"code":     "BAD_EVENT_QUEUE_ID",
+				"queue_id": id,
+			})
+			return
+		}
+		var events []map[string]interface{}
+		for _, e := range q.events {
+			if e["id"].(int) > last {
+				events = append(events, e)
+			}
+		}
+		if len(events) == 0 && timedOut {
+			q.lastID++
+			events = append(events, map[string]interface{}{"type": "heartbeat", "id": q.lastID})
+		}
+		pushed := s.pushed
+		s.mu.Unlock()
+
+		if len(events) > 0 {
+			writeJSON(w, http.StatusOK, success(map[string]interface{}{"events": events}))
+			return
+		}
+
+		select {
+		case <-pushed:
+		case <-heartbeat.C:
+			timedOut = true
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) message(w http.ResponseWriter, r *http.Request) {
+	m := SentMessage{
+		Type:    r.Form.Get("type"),
+		Topic:   r.Form.Get("topic"),
+		Content: r.Form.Get("content"),
+	}
+	if m.Topic == "" {
+		m.Topic = r.Form.Get("subject")
+	}
+	m.To = recipients(r.Form.Get("to"))
+
+	s.mu.Lock()
+	s.nextMsgID++
+	m.ID = s.nextMsgID
+	s.sent = append(s.sent, m)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, success(map[string]interface{}{"id": m.ID}))
+}
+
+// recipients parses a message's to field, a json list of emails or user ids,
+// or a comma separated list.
+func recipients(to string) []string {
+	var list []interface{}
+	dec := json.NewDecoder(strings.NewReader(to))
+	dec.UseNumber()
+	if dec.Decode(&list) != nil {
+		return strings.Split(to, ",")
+	}
+	out := make([]string, len(list))
+	for i, v := range list {
+		out[i] = fmt.Sprint(v)
+	}
+	return out
+}
+
+// success returns a success result with the given fields.
+func success(fields map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{"result": "success", "msg": ""}
+	for k, v := range fields {
+		result[k] = v
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,110 @@
+package zuliptest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	gzb "github.com/ifo/gozulipbot"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.HeartbeatInterval = 10 * time.Millisecond
+
+	bot := srv.Bot()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bot.OnMessage(ctx, func(b *gzb.Bot, e gzb.EventMessage) {
+			b.Respond(e, "got "+e.Content)
+		})
+	}()
+
+	if !srv.WaitForQueues(1, time.Second) {
+		t.Fatal("expected the bot to register a queue")
+	}
+	// let a heartbeat go by before the first message
+	time.Sleep(30 * time.Millisecond)
+	srv.PushMessage(Message{SenderEmail: "user@example.com", Stream: "ops", Topic: "deploys", Content: "one"})
+	srv.PushMessage(Message{SenderEmail: "user@example.com", SenderFullName: "User", Content: "two"})
+
+	msgs := srv.WaitForMessages(2, time.Second)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+
+	expected := []SentMessage{
+		{ID: 3, Type: "stream", To: []string{"ops"}, Topic: "deploys", Content: "got one"},
+		{ID: 4, Type: "private", To: []string{"user@example.com"}, Content: "got two"},
+	}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("got %+v, expected %+v", msgs, expected)
+	}
+	if srv.Queues() != 0 {
+		t.Errorf("got %d queues, expected the bot to delete its queue", srv.Queues())
+	}
+}
+
+func TestServerExpireQueues(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	bot := srv.Bot()
+	q, err := bot.RegisterAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID := q.ID
+	srv.ExpireQueues()
+
+	// the bot registers again, and receives the message on the new queue
+	go func() {
+		srv.WaitForQueues(1, time.Second)
+		srv.PushMessage(Message{SenderEmail: "user@example.com", Stream: "ops", Topic: "a", Content: "hi"})
+	}()
+	ems, err := q.GetEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.ID == oldID || len(ems) != 1 || ems[0].Content != "hi" {
+		t.Errorf("got queue %q and %+v, expected the message on a new queue", q.ID, ems)
+	}
+
+	// without credentials, requests are rejected
+	bot.APIKey = ""
+	bot.Email = ""
+	_, err = bot.GetProfile()
+	var ze *gzb.ZulipError
+	if !errors.As(err, &ze) || ze.HTTPStatus != 401 {
+		t.Errorf("got %v, expected an unauthorized error", err)
+	}
+}
+
+func TestServerUserIDs(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	bot := srv.Bot()
+	if _, err := bot.PrivateMessage(gzb.Message{UserIDs: []int{8, 9}, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.PrivateMessage(gzb.Message{Emails: []string{"a@example.com", "b@example.com"}, Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := srv.WaitForMessages(2, time.Second)
+	expected := [][]string{{"8", "9"}, {"a@example.com", "b@example.com"}}
+	if len(msgs) != len(expected) {
+		t.Fatalf("got %d messages, expected %d", len(msgs), len(expected))
+	}
+	for i, m := range msgs {
+		if !reflect.DeepEqual(m.To, expected[i]) {
+			t.Errorf("got %q, expected %q", m.To, expected[i])
+		}
+	}
+}